@@ -0,0 +1,290 @@
+// Copyright (c) 2014 Square, Inc
+//
+// Scrapes metrics from many inspect servers and serves them merged,
+// with a host label, along with cross-host aggregates.
+
+package federate
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"github.com/square/prodeng/metrics/client"
+)
+
+// Federator periodically scrapes a set of targets concurrently
+type Federator struct {
+	Targets    map[string]*Target // keyed by host
+	Timeout    time.Duration      // per scrape timeout
+	StaleAfter time.Duration      // data older than this is not served
+	Metrics    *FederatorMetrics
+	m          *metrics.MetricContext
+	mu         sync.RWMutex
+}
+
+// Target is a single inspect server
+type Target struct {
+	Host        string
+	URL         string
+	LastScrape  time.Time
+	LastSuccess time.Time
+	LastError   error
+	snapshot    *metrics.Snapshot
+	client      *client.Client
+}
+
+// metrics about the federator itself
+type FederatorMetrics struct {
+	Scrapes        *metrics.Counter
+	ScrapeFailures *metrics.Counter
+	TargetsUp      *metrics.Gauge
+	TargetsStale   *metrics.Gauge
+	ScrapeLatency  *metrics.StatsTimer
+}
+
+// Sample is a single value of a metric on a host. For counters Value
+// is the rate per second.
+type Sample struct {
+	Host  string  `json:"host"`
+	Name  string  `json:"name"`
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+}
+
+// MarshalJSON encodes NaN and infinite values as null
+func (s Sample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Host  string      `json:"host"`
+		Name  string      `json:"name"`
+		Type  string      `json:"type"`
+		Value interface{} `json:"value"`
+	}{s.Host, s.Name, s.Type, jsonValue(s.Value)})
+}
+
+// supported aggregation operators
+const (
+	OpSum   = "sum"
+	OpMax   = "max"
+	OpMin   = "min"
+	OpAvg   = "avg"
+	OpCount = "count"
+	OpTopK  = "topk"
+)
+
+// New returns a Federator for hosts which are host:port addresses or
+// URLs of inspect servers. Targets are scraped every Step.
+func New(m *metrics.MetricContext, Step time.Duration, hosts []string) *Federator {
+	f := new(Federator)
+	f.m = m
+	f.Timeout = Step
+	f.StaleAfter = Step * 3
+	f.Targets = make(map[string]*Target, len(hosts))
+	for _, h := range hosts {
+		c := client.New(h)
+		f.Targets[h] = &Target{Host: h, URL: c.URL, client: c}
+	}
+
+	f.Metrics = new(FederatorMetrics)
+	f.Metrics.Scrapes = metrics.NewCounter()
+	f.Metrics.ScrapeFailures = metrics.NewCounter()
+	f.Metrics.TargetsUp = metrics.NewGauge()
+	f.Metrics.TargetsStale = metrics.NewGauge()
+	f.Metrics.ScrapeLatency = metrics.NewStatsTimer(time.Millisecond, 1000)
+	m.Register(f.Metrics.Scrapes, "federate.Scrapes")
+	m.Register(f.Metrics.ScrapeFailures, "federate.ScrapeFailures")
+	m.Register(f.Metrics.TargetsUp, "federate.TargetsUp")
+	m.Register(f.Metrics.TargetsStale, "federate.TargetsStale")
+	m.Register(f.Metrics.ScrapeLatency, "federate.ScrapeLatency")
+
+	return f
+}
+
+// Run collects once and then every Step in the background
+func (f *Federator) Run(Step time.Duration) {
+	f.Collect()
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			f.Collect()
+		}
+	}()
+}
+
+// Collect scrapes all targets concurrently and waits for them to
+// finish or time out
+func (f *Federator) Collect() {
+	var wg sync.WaitGroup
+	for _, t := range f.Targets {
+		wg.Add(1)
+		go func(t *Target) {
+			defer wg.Done()
+			f.scrape(t)
+		}(t)
+	}
+	wg.Wait()
+
+	up, stale := 0, 0
+	f.mu.RLock()
+	for _, t := range f.Targets {
+		if f.isStale(t) {
+			stale++
+		} else {
+			up++
+		}
+	}
+	f.mu.RUnlock()
+	f.Metrics.TargetsUp.Set(float64(up))
+	f.Metrics.TargetsStale.Set(float64(stale))
+}
+
+// Stale returns true if the last successful scrape of target is older
+// than StaleAfter
+func (f *Federator) Stale(t *Target) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.isStale(t)
+}
+
+// Select returns the latest samples of all metrics matching glob
+// on all targets with fresh data, sorted by host and name
+func (f *Federator) Select(glob string) ([]Sample, error) {
+	re, err := misc.GlobToRegexp(glob)
+	if err != nil {
+		return nil, err
+	}
+	return f.selectRe(re), nil
+}
+
+// All returns samples of all metrics on all targets with fresh data
+func (f *Federator) All() []Sample {
+	return f.selectRe(nil)
+}
+
+// Aggregate reduces samples across hosts with op. Samples with NaN
+// values are ignored. topk returns the k largest samples; every
+// other operator returns a single sample with an empty host.
+func Aggregate(samples []Sample, op string, k int) ([]Sample, error) {
+	var vals []Sample
+	for _, s := range samples {
+		if !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+			vals = append(vals, s)
+		}
+	}
+
+	if op == OpTopK {
+		sort.Sort(byValue(vals))
+		if k >= 0 && len(vals) > k {
+			vals = vals[:k]
+		}
+		return vals, nil
+	}
+
+	agg := Sample{Name: op, Type: "aggregate", Value: math.NaN()}
+	switch op {
+	case OpSum, OpAvg:
+		if len(vals) > 0 {
+			agg.Value = 0
+		}
+		for _, s := range vals {
+			agg.Value += s.Value
+		}
+		if op == OpAvg && len(vals) > 0 {
+			agg.Value /= float64(len(vals))
+		}
+	case OpMax, OpMin:
+		for _, s := range vals {
+			if math.IsNaN(agg.Value) ||
+				(op == OpMax && s.Value > agg.Value) ||
+				(op == OpMin && s.Value < agg.Value) {
+				agg.Value = s.Value
+				agg.Host = s.Host
+			}
+		}
+	case OpCount:
+		agg.Value = float64(len(vals))
+	default:
+		return nil, errors.New("unknown aggregation: " + op)
+	}
+	return []Sample{agg}, nil
+}
+
+// Unexported functions
+
+func (f *Federator) scrape(t *Target) {
+	t.client.HTTPClient.Timeout = f.Timeout
+	timer := f.Metrics.ScrapeLatency.Start()
+	s, err := t.client.FetchSnapshot()
+	f.Metrics.ScrapeLatency.Stop(timer)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Metrics.Scrapes.Add(1)
+	t.LastScrape = time.Now()
+	t.LastError = err
+	if err != nil {
+		f.Metrics.ScrapeFailures.Add(1)
+		return
+	}
+	t.LastSuccess = t.LastScrape
+	t.snapshot = s
+}
+
+func (f *Federator) isStale(t *Target) bool {
+	return t.snapshot == nil || time.Since(t.LastSuccess) > f.StaleAfter
+}
+
+func (f *Federator) selectRe(re *regexp.Regexp) []Sample {
+	var ret []Sample
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for host, t := range f.Targets {
+		if f.isStale(t) {
+			continue
+		}
+		s := t.snapshot
+		for _, c := range s.Counters {
+			if re == nil || re.MatchString(c.Name) {
+				ret = append(ret, Sample{host, c.Name, "counter", c.Rate})
+			}
+		}
+		for _, g := range s.Gauges {
+			if re == nil || re.MatchString(g.Name) {
+				ret = append(ret, Sample{host, g.Name, "gauge", g.Value})
+			}
+		}
+		for _, c := range s.BasicCounters {
+			if re == nil || re.MatchString(c.Name) {
+				ret = append(ret,
+					Sample{host, c.Name, "basiccounter", float64(c.Value)})
+			}
+		}
+	}
+
+	sort.Sort(byHostName(ret))
+	return ret
+}
+
+type byValue []Sample
+
+func (a byValue) Len() int           { return len(a) }
+func (a byValue) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byValue) Less(i, j int) bool { return a[i].Value > a[j].Value }
+
+type byHostName []Sample
+
+func (a byHostName) Len() int      { return len(a) }
+func (a byHostName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byHostName) Less(i, j int) bool {
+	if a[i].Host != a[j].Host {
+		return a[i].Host < a[j].Host
+	}
+	return a[i].Name < a[j].Name
+}
@@ -0,0 +1,158 @@
+//Copyright (c) 2014 Square, Inc
+//
+// Tests for federate.go. A fleet of inspect servers is simulated
+// with httptest servers each exposing their own metric context.
+
+package federate
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/metrics"
+)
+
+// newHost starts a fake inspect server with one gauge per name
+func newHost(gauges map[string]float64) *httptest.Server {
+	m := metrics.NewMetricContext("system")
+	for name, v := range gauges {
+		g := metrics.NewGauge()
+		g.Set(v)
+		m.Register(g, name)
+	}
+	return httptest.NewServer(http.HandlerFunc(m.HttpJsonHandler))
+}
+
+func initFleet(t *testing.T) (*Federator, []*httptest.Server) {
+	fleet := []*httptest.Server{
+		newHost(map[string]float64{
+			"cpustat.cgroup.web.Throttle": 10, "memstat.MemFree": 1}),
+		newHost(map[string]float64{
+			"cpustat.cgroup.web.Throttle": 30, "cpustat.cgroup.db.Throttle": 5}),
+		newHost(map[string]float64{
+			"memstat.MemFree": 3}),
+	}
+	var hosts []string
+	for _, s := range fleet {
+		hosts = append(hosts, s.URL)
+	}
+	f := New(metrics.NewMetricContext("federate"), time.Second, hosts)
+	f.Collect()
+	return f, fleet
+}
+
+func closeFleet(fleet []*httptest.Server) {
+	for _, s := range fleet {
+		s.Close()
+	}
+}
+
+func TestSelectAndAggregate(t *testing.T) {
+	f, fleet := initFleet(t)
+	defer closeFleet(fleet)
+
+	samples, err := f.Select("cpustat.cgroup.*.Throttle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3: %v", len(samples), samples)
+	}
+
+	want := map[string]float64{OpSum: 45, OpMax: 30, OpMin: 5, OpAvg: 15, OpCount: 3}
+	for op, v := range want {
+		res, err := Aggregate(samples, op, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res[0].Value != v {
+			t.Errorf("%s = %v, want %v", op, res[0].Value, v)
+		}
+	}
+
+	res, _ := Aggregate(samples, OpMax, 0)
+	if res[0].Host != fleet[1].URL {
+		t.Errorf("max host = %v, want %v", res[0].Host, fleet[1].URL)
+	}
+
+	top, _ := Aggregate(samples, OpTopK, 2)
+	if len(top) != 2 || top[0].Value != 30 || top[1].Value != 10 {
+		t.Errorf("topk = %v", top)
+	}
+
+	if _, err := Aggregate(samples, "median", 0); err == nil {
+		t.Errorf("expected error for unknown aggregation")
+	}
+}
+
+func TestStaleTargets(t *testing.T) {
+	f, fleet := initFleet(t)
+	defer closeFleet(fleet)
+
+	if f.Metrics.TargetsUp.Get() != 3 {
+		t.Errorf("TargetsUp = %v, want 3", f.Metrics.TargetsUp.Get())
+	}
+
+	// take one host down and age out its data
+	fleet[0].Close()
+	f.Targets[fleet[0].URL].LastSuccess = time.Now().Add(-2 * f.StaleAfter)
+	f.Collect()
+
+	if f.Metrics.TargetsStale.Get() != 1 || f.Metrics.ScrapeFailures.Get() != 1 {
+		t.Errorf("stale: %v failures: %v, want 1 1",
+			f.Metrics.TargetsStale.Get(), f.Metrics.ScrapeFailures.Get())
+	}
+
+	samples, _ := f.Select("memstat.MemFree")
+	if len(samples) != 1 || samples[0].Host != fleet[2].URL {
+		t.Errorf("stale host data served: %v", samples)
+	}
+}
+
+func TestHttpHandlers(t *testing.T) {
+	f, fleet := initFleet(t)
+	defer closeFleet(fleet)
+
+	r := httptest.NewRecorder()
+	f.HttpMetricsHandler(r, httptest.NewRequest("GET", "/metrics.json?name=memstat.*", nil))
+	var merged []map[string]interface{}
+	if err := json.Unmarshal(r.Body.Bytes(), &merged); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if body := r.Body.String(); !strings.Contains(body, `"host":"`+fleet[2].URL+`"`) ||
+		strings.Contains(body, "cpustat") {
+		t.Errorf("unexpected merged output: %s", body)
+	}
+
+	r = httptest.NewRecorder()
+	f.HttpAggregateHandler(r, httptest.NewRequest("GET",
+		"/aggregate.json?name=cpustat.cgroup.*.Throttle&op=topk&k=1", nil))
+	var out struct {
+		Hosts   int
+		Results []Sample
+	}
+	if err := json.Unmarshal(r.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Hosts != 2 || len(out.Results) != 1 || out.Results[0].Value != 30 {
+		t.Errorf("unexpected aggregate: %+v", out)
+	}
+
+	// min of no samples is NaN
+	r = httptest.NewRecorder()
+	f.HttpAggregateHandler(r, httptest.NewRequest("GET",
+		"/aggregate.json?name=nothing&op=min", nil))
+	if !strings.Contains(r.Body.String(), `"value":null`) {
+		t.Errorf("NaN not encoded as null: %s", r.Body.String())
+	}
+
+	r = httptest.NewRecorder()
+	f.HttpAggregateHandler(r, httptest.NewRequest("GET", "/aggregate.json", nil))
+	if r.Code != http.StatusBadRequest {
+		t.Errorf("missing name: got %v, want 400", r.Code)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2014 Square, Inc
+
+package federate
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// HttpMetricsHandler exposes metrics of all fresh targets with a host
+// label, in the same format as MetricContext.HttpJsonHandler.
+// An optional "name" query parameter restricts output to a glob.
+func (f *Federator) HttpMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	samples, err := f.samples(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	out := make([]map[string]interface{}, 0, len(samples))
+	for _, s := range samples {
+		valueField := "value"
+		if s.Type == "counter" {
+			valueField = "rate"
+		}
+		out = append(out, map[string]interface{}{
+			"type": s.Type, "host": s.Host, "name": s.Name,
+			valueField: jsonValue(s.Value),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// HttpAggregateHandler computes a cross-host aggregate
+//   /aggregate.json?name=<glob>&op=sum|max|min|avg|count|topk[&k=10]
+func (f *Federator) HttpAggregateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("name") == "" {
+		http.Error(w, "missing name", http.StatusBadRequest)
+		return
+	}
+	samples, err := f.samples(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	op := r.FormValue("op")
+	if op == "" {
+		op = OpSum
+	}
+	k := 10
+	if v := r.FormValue("k"); v != "" {
+		k, err = strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid k: "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := Aggregate(samples, op, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := struct {
+		Name    string   `json:"name"`
+		Op      string   `json:"op"`
+		Hosts   int      `json:"hosts"`
+		Results []Sample `json:"results"`
+	}{r.FormValue("name"), op, countHosts(samples), res}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// HttpTargetsHandler reports scrape status of every target
+func (f *Federator) HttpTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	type targetStatus struct {
+		Host        string    `json:"host"`
+		URL         string    `json:"url"`
+		Stale       bool      `json:"stale"`
+		LastScrape  time.Time `json:"last_scrape"`
+		LastSuccess time.Time `json:"last_success"`
+		Error       string    `json:"error,omitempty"`
+	}
+
+	var out []targetStatus
+	f.mu.RLock()
+	for _, t := range f.Targets {
+		ts := targetStatus{
+			Host:        t.Host,
+			URL:         t.URL,
+			Stale:       f.isStale(t),
+			LastScrape:  t.LastScrape,
+			LastSuccess: t.LastSuccess,
+		}
+		if t.LastError != nil {
+			ts.Error = t.LastError.Error()
+		}
+		out = append(out, ts)
+	}
+	f.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// Unexported functions
+
+func (f *Federator) samples(r *http.Request) ([]Sample, error) {
+	name := r.FormValue("name")
+	if name == "" {
+		return f.All(), nil
+	}
+	return f.Select(name)
+}
+
+// jsonValue returns v, or nil for NaN and infinities which JSON
+// can't represent
+func jsonValue(v float64) interface{} {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return v
+}
+
+func countHosts(samples []Sample) int {
+	hosts := make(map[string]bool)
+	for _, s := range samples {
+		hosts[s.Host] = true
+	}
+	return len(hosts)
+}
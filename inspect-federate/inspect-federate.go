@@ -0,0 +1,84 @@
+//Copyright (c) 2014 Square, Inc
+//Scrapes many inspect servers and serves merged metrics and aggregates
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/square/prodeng/inspect-federate/federate"
+	"github.com/square/prodeng/metrics"
+)
+
+func main() {
+	var address, targets, targetsFile string
+	var stepSec, timeoutSec, staleSec int
+
+	flag.StringVar(&address, "address", ":19998", "address to listen on for http")
+	flag.StringVar(&targets, "targets", "", "comma separated list of inspect servers (host:port or URL)")
+	flag.StringVar(&targetsFile, "targets-file", "", "file with one inspect server per line")
+	flag.IntVar(&stepSec, "step", 10, "targets are scraped every step seconds")
+	flag.IntVar(&timeoutSec, "timeout", 5, "timeout in seconds for a single scrape")
+	flag.IntVar(&staleSec, "stale", 30, "seconds after which data from a target is considered stale")
+	flag.Parse()
+
+	hosts, err := readTargets(targets, targetsFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(hosts) == 0 {
+		fmt.Println("no targets given; use -targets or -targets-file")
+		os.Exit(1)
+	}
+
+	m := metrics.NewMetricContext("federate")
+	step := time.Millisecond * time.Duration(stepSec) * 1000
+
+	f := federate.New(m, step, hosts)
+	f.Timeout = time.Millisecond * time.Duration(timeoutSec) * 1000
+	f.StaleAfter = time.Millisecond * time.Duration(staleSec) * 1000
+	f.Run(step)
+
+	http.HandleFunc("/metrics.json", f.HttpMetricsHandler)
+	http.HandleFunc("/aggregate.json", f.HttpAggregateHandler)
+	http.HandleFunc("/targets.json", f.HttpTargetsHandler)
+	http.HandleFunc("/self/metrics.json", m.HttpJsonHandler)
+	log.Fatal(http.ListenAndServe(address, nil))
+}
+
+// readTargets merges targets from the command line and targets file.
+// Blank lines and lines starting with '#' are ignored.
+func readTargets(list, path string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(list, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	if path == "" {
+		return hosts, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		h := strings.TrimSpace(scanner.Text())
+		if h != "" && !strings.HasPrefix(h, "#") {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts, scanner.Err()
+}
@@ -10,15 +10,31 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/square/prodeng/inspect-mysql/mysqlstat"
 	"github.com/square/prodeng/inspect-mysql/mysqlstattable"
+	"github.com/square/prodeng/inspect/check"
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/rules"
 	"github.com/square/prodeng/metrics"
 )
 
+// checks available with -check; thresholds can be overridden
+// with -warn/-crit
+var checks = []*check.Check{
+	{Name: "replication_lag", Select: "mysqlstat.SlaveSecondsBehindMaster", Warn: 60, Crit: 300, Unit: "s",
+		Sentinels: []check.Sentinel{
+			{Value: mysqlstat.SlaveStopped, Status: check.CRITICAL, Text: "replication stopped"},
+			{Value: mysqlstat.SlaveBackup, Status: check.UNKNOWN, Text: "backup running"},
+		}},
+	{Name: "connections", Select: "mysqlstat.CurrentConnectionsPct", Warn: 80, Crit: 90, Unit: "%"},
+}
+
 func main() {
-	var user, password, address, conf string
+	var user, password, address, conf, configFile string
+	var checkNames, warn, crit string
 	var stepSec int
 	var servermode, human bool
 
@@ -29,17 +45,44 @@ func main() {
 	flag.BoolVar(&servermode, "server", false, "Runs continously and exposes metrics as JSON on HTTP")
 	flag.StringVar(&address, "address", ":12345", "address to listen on for http if running in server mode")
 	flag.IntVar(&stepSec, "step", 2, "metrics are collected every step seconds")
-	flag.StringVar(&conf, "conf", "/root/.my.cnf", "configuration file")
+	flag.StringVar(&conf, "conf", "",
+		"configuration file (default: /etc/my_nrpe.cnf for user nrpe, /root/.my.cnf otherwise)")
+	flag.StringVar(&configFile, "config", "",
+		"YAML/JSON inspect configuration file (collectors: mysqlstat, mysqlstattable); reloaded on SIGHUP")
 	flag.BoolVar(&human, "h", false, "Makes output in MB for human readable sizes")
+	flag.StringVar(&checkNames, "check", "",
+		"Run NRPE style checks (comma separated: "+
+			strings.Join(check.Names(checks), ", ")+") and exit with status")
+	flag.StringVar(&warn, "warn", "", "warning threshold for -check (default: per check)")
+	flag.StringVar(&crit, "crit", "", "critical threshold for -check (default: per check)")
 	flag.Parse()
 
+	loader, err := config.NewLoader(configFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cfg := loader.Config()
+
+	if checkNames != "" {
+		r := runChecks(m, checkNames, warn, crit, user, password, conf)
+		fmt.Println(r.Output)
+		os.Exit(r.Status)
+	}
+
 	if servermode {
 		go func() {
 			http.HandleFunc("/metrics.json", m.HttpJsonHandler)
 			log.Fatal(http.ListenAndServe(address, nil))
 		}()
 	}
-	step := time.Millisecond * time.Duration(stepSec) * 1000
+	// -step overrides the config file
+	step := cfg.StepDuration()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "step" {
+			step = time.Millisecond * time.Duration(stepSec) * 1000
+		}
+	})
 
 	sqlstat, err := mysqlstat.New(m, step, user, password, conf)
 	if err != nil {
@@ -51,6 +94,16 @@ func main() {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+
+	configure := func(c *config.Config) {
+		sqlstat.Schedule.SetInterval(c.Interval(config.MysqlStat))
+		sqlstat.Schedule.SetEnabled(c.Enabled(config.MysqlStat))
+		sqlstatTables.Schedule.SetInterval(c.Interval(config.MysqlStatTable))
+		sqlstatTables.Schedule.SetEnabled(c.Enabled(config.MysqlStatTable))
+	}
+	configure(cfg)
+	loader.OnReload = append(loader.OnReload, configure)
+	loader.WatchSignals()
 	ticker := time.NewTicker(step * 2)
 	for _ = range ticker.C {
 		//Print stats here, more stats than printed are actually collected
@@ -71,3 +124,26 @@ func main() {
 	}
 
 }
+
+// runChecks collects mysql stats once and evaluates checks
+func runChecks(m *metrics.MetricContext, names, warn, crit,
+	user, password, conf string) *check.Result {
+
+	selected, err := check.Select(checks, names, warn, crit)
+	if err != nil {
+		return check.Unknown("MYSQL", err)
+	}
+
+	// stats are collected right away; give the queries time to
+	// finish
+	step := time.Millisecond * 1000
+	_, err = mysqlstat.New(m, step, user, password, conf)
+	if err != nil {
+		return check.Unknown("MYSQL", err)
+	}
+	time.Sleep(step * 2)
+
+	values := make(map[string]float64)
+	rules.MetricValues(m, values)
+	return check.Run("MYSQL", selected, values)
+}
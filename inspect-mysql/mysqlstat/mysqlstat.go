@@ -19,9 +19,10 @@ import (
 
 // Collection of metrics and connection to database
 type MysqlStat struct {
-	Metrics *MysqlStatMetrics //collection of metrics
-	m       *metrics.MetricContext
-	db      mysqltools.MysqlDB //mysql connection
+	Metrics  *MysqlStatMetrics //collection of metrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	db       mysqltools.MysqlDB //mysql connection
 }
 
 // metrics being collected about the server/database
@@ -146,6 +147,12 @@ type MysqlStatMetrics struct {
 	QueryResponseSec1000000_ *metrics.Counter
 }
 
+// SlaveSecondsBehindMaster values that are not a lag
+const (
+	SlaveBackup  = -1 // a backup is running, replication may be paused
+	SlaveStopped = -2 // replication is stopped
+)
+
 const (
 	slaveQuery  = "SHOW SLAVE STATUS;"
 	mutexQuery  = "SHOW ENGINE INNODB MUTEX;"
@@ -227,7 +234,7 @@ func New(m *metrics.MetricContext, Step time.Duration, user, password, config st
 
 	// connect to database
 	var err error
-	s.db, err = mysqltools.New(user, password, config)
+	s.db, err = mysqltools.New(m, "mysqlstat.sqldb", user, password, config)
 	if err != nil {
 		s.db.Log(err)
 		return nil, err
@@ -236,12 +243,7 @@ func New(m *metrics.MetricContext, Step time.Duration, user, password, config st
 
 	s.Collect()
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			go s.Collect()
-		}
-	}()
+	s.Schedule = misc.NewSchedule("mysqlstat", Step, func() { go s.Collect() })
 	return s, nil
 }
 
@@ -276,11 +278,15 @@ func (s *MysqlStat) Collect() {
 
 // get_slave_stats gets slave statistics
 func (s *MysqlStat) getSlaveStats() {
+	backup := false
 	res, err := s.db.QueryReturnColumnDict(slaveBackupQuery)
 	if err != nil {
 		s.db.Log(err)
-	} else if len(res) > 0 {
-		s.Metrics.SlaveSecondsBehindMaster.Set(float64(-1))
+	}
+	for _, count := range res {
+		if len(count) > 0 && count[0] != "0" {
+			backup = true
+		}
 	}
 	res, err = s.db.QueryReturnColumnDict(slaveQuery)
 	if err != nil {
@@ -288,12 +294,19 @@ func (s *MysqlStat) getSlaveStats() {
 		return
 	}
 
+	// Seconds_Behind_Master is NULL while replication is stopped
 	if len(res["Seconds_Behind_Master"]) > 0 {
 		seconds_behind_master, err := strconv.ParseFloat(string(res["Seconds_Behind_Master"][0]), 64)
-		if err != nil {
-			s.db.Log(err)
+		switch {
+		case err == nil:
+			s.Metrics.SlaveSecondsBehindMaster.Set(seconds_behind_master)
+		case backup:
+			s.Metrics.SlaveSecondsBehindMaster.Set(SlaveBackup)
+		default:
+			s.Metrics.SlaveSecondsBehindMaster.Set(SlaveStopped)
 		}
-		s.Metrics.SlaveSecondsBehindMaster.Set(float64(seconds_behind_master))
+	} else if backup {
+		s.Metrics.SlaveSecondsBehindMaster.Set(SlaveBackup)
 	}
 
 	relay_master_log_file, _ := res["Relay_Master_Log_File"]
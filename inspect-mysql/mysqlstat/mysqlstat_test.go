@@ -409,6 +409,50 @@ func TestSlave1(t *testing.T) {
 	}
 }
 
+// Test replication stopped: Seconds_Behind_Master is NULL
+func TestSlaveStopped(t *testing.T) {
+	s := initMysqlStat()
+	testquerycol = map[string]map[string][]string{
+		slaveBackupQuery: map[string][]string{"COUNT(*)": []string{"0"}},
+		slaveQuery: map[string][]string{
+			"Seconds_Behind_Master": []string{""},
+			"Relay_Master_Log_File": []string{"some-name-bin.01345"},
+			"Exec_Master_Log_Pos":   []string{"7"},
+		},
+	}
+	expectedValues = map[interface{}]interface{}{
+		s.Metrics.SlaveSecondsBehindMaster: float64(SlaveStopped),
+	}
+	s.Collect()
+	time.Sleep(time.Millisecond * 1000 * 1)
+	err := checkResults()
+	if err != "" {
+		t.Error(err)
+	}
+}
+
+// Test replication paused by a backup
+func TestSlaveBackup(t *testing.T) {
+	s := initMysqlStat()
+	testquerycol = map[string]map[string][]string{
+		slaveBackupQuery: map[string][]string{"COUNT(*)": []string{"1"}},
+		slaveQuery: map[string][]string{
+			"Seconds_Behind_Master": []string{""},
+			"Relay_Master_Log_File": []string{"some-name-bin.01345"},
+			"Exec_Master_Log_Pos":   []string{"7"},
+		},
+	}
+	expectedValues = map[interface{}]interface{}{
+		s.Metrics.SlaveSecondsBehindMaster: float64(SlaveBackup),
+	}
+	s.Collect()
+	time.Sleep(time.Millisecond * 1000 * 1)
+	err := checkResults()
+	if err != "" {
+		t.Error(err)
+	}
+}
+
 // Test basic parsing of slave info query
 func TestSlave2(t *testing.T) {
 	//intitialize MysqlStat
@@ -37,10 +37,11 @@ SELECT table_schema AS db, table_name AS tbl,
 
 // MysqlStatTables - main struct that contains connection to database, metric context, and map to database stats struct
 type MysqlStatTables struct {
-	DBs   map[string]*DBStats
-	m     *metrics.MetricContext
-	db    mysqltools.MysqlDB
-	nLock *sync.Mutex
+	DBs      map[string]*DBStats
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	db       mysqltools.MysqlDB
+	nLock    *sync.Mutex
 }
 
 //database stats struct
@@ -71,7 +72,7 @@ func New(m *metrics.MetricContext, Step time.Duration, user, password, config st
 	s.nLock = &sync.Mutex{}
 	// connect to database
 	var err error
-	s.db, err = mysqltools.New(user, password, config)
+	s.db, err = mysqltools.New(m, "mysqltablestat.sqldb", user, password, config)
 	s.nLock.Lock()
 	s.DBs = make(map[string]*DBStats)
 	s.nLock.Unlock()
@@ -81,12 +82,7 @@ func New(m *metrics.MetricContext, Step time.Duration, user, password, config st
 
 	s.Collect()
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			go s.Collect()
-		}
-	}()
+	s.Schedule = misc.NewSchedule("mysqlstattable", Step, func() { go s.Collect() })
 	return s, nil
 }
 
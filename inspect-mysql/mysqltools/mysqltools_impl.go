@@ -7,7 +7,6 @@ package mysqltools
 
 import (
 	"errors"
-	"fmt"
 	"log"
 	"os"
 	"regexp"
@@ -16,6 +15,7 @@ import (
 	"strings"
 
 	"code.google.com/p/goconf/conf" // used for parsing config files
+	"github.com/square/prodeng/metrics"
 )
 
 // sql packages and driver
@@ -23,7 +23,7 @@ import "database/sql"
 import _ "github.com/go-sql-driver/mysql"
 
 type mysqlDB struct {
-	db        *sql.DB
+	db        *metrics.SqlDB
 	dsnString string
 	Logger    *log.Logger
 }
@@ -61,7 +61,11 @@ func (database *mysqlDB) queryDb(query string) ([]string, [][]string, error) {
 			}
 		}
 		database.db.Close()
-		database.db, err = sql.Open("mysql", database.dsnString)
+		var db *sql.DB
+		db, err = sql.Open("mysql", database.dsnString)
+		if err == nil {
+			database.db.SetDB(db)
+		}
 	}
 	return nil, nil, err
 }
@@ -75,6 +79,7 @@ func (database *mysqlDB) makeQuery(query string) ([]string, [][]string, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	defer rows.Close()
 
 	column_names, err := rows.Columns()
 	if err != nil {
@@ -156,7 +161,9 @@ func makeDsn(dsn map[string]string) string {
 
 // create connection to mysql database here
 // when an error is encountered, still return database so that the logger may be used
-func New(user, password, config string) (MysqlDB, error) {
+// latency, error and row counts of every query made are recorded in
+// metric context m under prefix
+func New(m *metrics.MetricContext, prefix, user, password, config string) (MysqlDB, error) {
 
 	dsn := map[string]string{"dbname": "information_schema"}
 	creds := map[string]string{"root": "/root/.my.cnf", "nrpe": "/etc/my_nrpe.cnf"}
@@ -180,13 +187,16 @@ func New(user, password, config string) (MysqlDB, error) {
 	//	}
 
 	//Parse ini file to get password
-	ini_file := creds[user]
+	ini_file, ok := creds[user]
+	if !ok {
+		ini_file = creds["root"]
+	}
 	if config != "" {
 		ini_file = config
 	}
 	_, err := os.Stat(ini_file)
 	if err != nil {
-		fmt.Println(err)
+		database.Logger.Println(err)
 		return database, errors.New("'" + ini_file + "' does not exist")
 	}
 	// read ini file to get password
@@ -203,14 +213,14 @@ func New(user, password, config string) (MysqlDB, error) {
 	if err != nil {
 		return database, err
 	}
-	database.db = db
+	database.db = metrics.NewSqlDB(m, db, prefix)
 
 	//ping db to verify connection
 	err = database.db.Ping()
 	if err != nil {
 		return database, err
 	}
-	fmt.Println("connected to " + user + " @ " + dsn["dbname"])
+	database.Logger.Println("connected to " + user + " @ " + dsn["dbname"])
 	return database, nil
 }
 
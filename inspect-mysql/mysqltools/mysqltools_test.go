@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/codahale/tmpmysqld"
+	"github.com/square/prodeng/metrics"
 )
 
 var (
@@ -44,7 +45,7 @@ func initDB(t testing.TB) mysqlDB {
 	}
 
 	test := new(mysqlDB)
-	test.db = server.DB
+	test.db = metrics.NewSqlDB(metrics.NewMetricContext("system"), server.DB, "mysqltools")
 	test.dsnString = "/inspect_mysql_test"
 	test.Logger = log.New(os.Stderr, "TESTING LOG: ", log.Lshortfile)
 
@@ -0,0 +1,265 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package cgroup discovers cgroup hierarchies (v1, the v2 unified
+// hierarchy or both on hybrid hosts), models them as trees and
+// reads their tasks and limits. Paths are read through
+// misc.HostPath so the host's cgroups can be read from a container.
+package cgroup
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/square/prodeng/inspect/misc"
+)
+
+// UNLIMITED is memory.limit_in_bytes of a v1 cgroup without a limit
+// (LONG_MAX rounded down to a page); v2 "max" limits read as this too
+const UNLIMITED = 9223372036854771712
+
+var ErrNotMounted = errors.New("no cgroup mount found")
+
+// Hierarchy is a mounted cgroup hierarchy
+type Hierarchy struct {
+	Mountpoint  string // as readable by inspect (misc.HostPath)
+	Controllers []string
+	Unified     bool // cgroup v2
+}
+
+// Hierarchies returns all mounted cgroup hierarchies. The
+// controllers of the unified hierarchy are those enabled in its
+// root's cgroup.controllers.
+func Hierarchies() ([]*Hierarchy, error) {
+	file, err := os.Open(misc.MtabPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ret []*Hierarchy
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 4 {
+			continue
+		}
+		switch f[2] {
+		case "cgroup":
+			h := &Hierarchy{Mountpoint: misc.HostPath(f[1])}
+			for _, o := range strings.Split(f[3], ",") {
+				if knownController(o) {
+					h.Controllers = append(h.Controllers, o)
+				}
+			}
+			ret = append(ret, h)
+		case "cgroup2":
+			h := &Hierarchy{Mountpoint: misc.HostPath(f[1]), Unified: true}
+			dat, err := ioutil.ReadFile(filepath.Join(h.Mountpoint, "cgroup.controllers"))
+			if err == nil {
+				h.Controllers = strings.Fields(string(dat))
+			}
+			ret = append(ret, h)
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// Find returns the hierarchy controller is attached to. A v1 mount
+// takes precedence, so on hybrid hosts controllers still attached to
+// v1 are read from there.
+func Find(controller string) (*Hierarchy, error) {
+	hs, err := Hierarchies()
+	if err != nil {
+		return nil, err
+	}
+	var unified *Hierarchy
+	for _, h := range hs {
+		if !h.Has(controller) {
+			continue
+		}
+		if !h.Unified {
+			return h, nil
+		}
+		unified = h
+	}
+	if unified == nil {
+		return nil, ErrNotMounted
+	}
+	return unified, nil
+}
+
+// Has returns whether controller is attached to the hierarchy
+func (h *Hierarchy) Has(controller string) bool {
+	for _, c := range h.Controllers {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// Tree returns the root cgroup of the hierarchy with all its
+// descendants
+func (h *Hierarchy) Tree() (*Cgroup, error) {
+	if _, err := os.Stat(h.Mountpoint); err != nil {
+		return nil, err
+	}
+	root := &Cgroup{Name: ".", Path: h.Mountpoint, Hierarchy: h}
+	nodes := map[string]*Cgroup{h.Mountpoint: root}
+
+	filepath.Walk(h.Mountpoint, func(path string, f os.FileInfo, err error) error {
+		if err != nil || !f.IsDir() || path == h.Mountpoint {
+			return nil
+		}
+		parent, ok := nodes[filepath.Dir(path)]
+		if !ok {
+			return filepath.SkipDir
+		}
+		name, _ := filepath.Rel(h.Mountpoint, path)
+		c := &Cgroup{Name: name, Path: path, Parent: parent, Hierarchy: h}
+		parent.Children = append(parent.Children, c)
+		nodes[path] = c
+		return nil
+	})
+	return root, nil
+}
+
+// Cgroups returns the cgroups below the root that have tasks
+func (h *Hierarchy) Cgroups() ([]*Cgroup, error) {
+	root, err := h.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Cgroup
+	root.Walk(func(c *Cgroup) {
+		if c != root && c.HasTasks() {
+			ret = append(ret, c)
+		}
+	})
+	return ret, nil
+}
+
+// Cgroup is a directory of a hierarchy
+type Cgroup struct {
+	Name      string // relative to the mountpoint; "." for the root
+	Path      string
+	Parent    *Cgroup // nil for the root
+	Children  []*Cgroup
+	Hierarchy *Hierarchy
+}
+
+// Walk calls fn for c and all its descendants, parents first
+func (c *Cgroup) Walk(fn func(*Cgroup)) {
+	fn(c)
+	for _, child := range c.Children {
+		child.Walk(fn)
+	}
+}
+
+// File returns the path of a control file of the cgroup
+func (c *Cgroup) File(name string) string {
+	return filepath.Join(c.Path, name)
+}
+
+// Tasks returns the pids of processes in the cgroup (not its
+// descendants)
+func (c *Cgroup) Tasks() ([]string, error) {
+	dat, err := ioutil.ReadFile(c.File(c.tasksFile()))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(dat)), nil
+}
+
+// HasTasks returns whether any process is in the cgroup
+func (c *Cgroup) HasTasks() bool {
+	dat, err := ioutil.ReadFile(c.File(c.tasksFile()))
+	return err == nil && len(dat) > 0
+}
+
+// Limits of a cgroup; NaN if not set or not readable
+type Limits struct {
+	CPUQuota      float64 // usec per period; 0 without a quota
+	CPUPeriod     float64 // usec
+	MemoryMax     float64 // bytes; UNLIMITED without a limit
+	MemorySoftMax float64 // v1 soft limit or v2 memory.high
+}
+
+// CPUs returns how many logical CPUs the quota allows; 0 without
+// a quota
+func (l *Limits) CPUs() float64 {
+	if l.CPUPeriod > 0 {
+		return l.CPUQuota / l.CPUPeriod
+	}
+	return 0
+}
+
+// Limits reads the CPU and memory limits of the cgroup from the
+// files of its version
+func (c *Cgroup) Limits() *Limits {
+	l := &Limits{CPUQuota: math.NaN(), CPUPeriod: math.NaN(),
+		MemoryMax: math.NaN(), MemorySoftMax: math.NaN()}
+
+	if c.Hierarchy.Unified {
+		// "$MAX $PERIOD"
+		if dat, err := ioutil.ReadFile(c.File("cpu.max")); err == nil {
+			if f := strings.Fields(string(dat)); len(f) == 2 {
+				l.CPUQuota = float64(misc.ParseUint(f[0])) // "max" is 0
+				l.CPUPeriod = float64(misc.ParseUint(f[1]))
+			}
+		}
+		l.MemoryMax = c.ReadLimit("memory.max")
+		l.MemorySoftMax = c.ReadLimit("memory.high")
+		return l
+	}
+
+	if c.Hierarchy.Has("cpu") {
+		// -1 without a quota, which reads as 0
+		l.CPUQuota = float64(misc.ReadUintFromFile(c.File("cpu.cfs_quota_us")))
+		l.CPUPeriod = float64(misc.ReadUintFromFile(c.File("cpu.cfs_period_us")))
+	}
+	if c.Hierarchy.Has("memory") {
+		l.MemoryMax = c.ReadLimit("memory.limit_in_bytes")
+		l.MemorySoftMax = c.ReadLimit("memory.soft_limit_in_bytes")
+	}
+	return l
+}
+
+// ReadLimit reads a limit such as memory.max; "max" is returned as
+// UNLIMITED and unreadable files as NaN
+func (c *Cgroup) ReadLimit(name string) float64 {
+	dat, err := ioutil.ReadFile(c.File(name))
+	if err != nil {
+		return math.NaN()
+	}
+	v := strings.TrimSpace(string(dat))
+	if v == "max" {
+		return UNLIMITED
+	}
+	return float64(misc.ParseUint(v))
+}
+
+// Unexported functions
+
+func (c *Cgroup) tasksFile() string {
+	if c.Hierarchy.Unified {
+		return "cgroup.procs"
+	}
+	return "tasks"
+}
+
+// knownController filters mount options of v1 hierarchies
+func knownController(o string) bool {
+	switch o {
+	case "blkio", "cpu", "cpuacct", "cpuset", "devices", "freezer",
+		"hugetlb", "memory", "net_cls", "net_prio", "perf_event",
+		"pids", "rdma", "misc":
+		return true
+	}
+	return false
+}
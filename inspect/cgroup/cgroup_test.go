@@ -0,0 +1,105 @@
+// Copyright (c) 2014 Square, Inc
+
+package cgroup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/square/prodeng/inspect/misc"
+)
+
+func TestFind(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mounts", Sys: "/host/sys"})
+
+	h, err := Find("cpuacct")
+	if err != nil || h.Mountpoint != "/host/sys/fs/cgroup/cpu,cpuacct" || h.Unified {
+		t.Errorf("Find(cpuacct) = %+v, %v", h, err)
+	}
+	if !reflect.DeepEqual(h.Controllers, []string{"cpu", "cpuacct"}) {
+		t.Errorf("unexpected controllers %v", h.Controllers)
+	}
+	if _, err := Find("blkio"); err != ErrNotMounted {
+		t.Errorf("found unmounted blkio")
+	}
+}
+
+func TestFindHybrid(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/hybrid", Sys: "testdata/sys"})
+
+	// memory stays on v1, cpu is only available on v2
+	h, err := Find("memory")
+	if err != nil || h.Unified || h.Mountpoint != "testdata/sys/fs/cgroup/memory" {
+		t.Errorf("memory: %+v %v", h, err)
+	}
+	h, err = Find("cpu")
+	if err != nil || !h.Unified || h.Mountpoint != "testdata/sys/fs/cgroup/unified" {
+		t.Errorf("cpu: %+v %v", h, err)
+	}
+	if _, err := Find("pids"); err == nil {
+		t.Errorf("found pids controller")
+	}
+}
+
+func TestTree(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/hybrid", Sys: "testdata/sys"})
+
+	h, err := Find("cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := h.Tree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	root.Walk(func(c *Cgroup) { names = append(names, c.Name) })
+	want := []string{".", "system.slice", "system.slice/nginx.service", "user.slice"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got tree %v, want %v", names, want)
+	}
+	nginx := root.Children[0].Children[0]
+	if nginx.Parent != root.Children[0] || nginx.Parent.Parent != root {
+		t.Errorf("unexpected parents")
+	}
+
+	// system.slice has no processes of its own
+	cgroups, _ := h.Cgroups()
+	if len(cgroups) != 2 || cgroups[0].Name != nginx.Name || cgroups[1].Name != "user.slice" {
+		t.Errorf("unexpected cgroups with tasks %v", cgroups)
+	}
+	if tasks, err := nginx.Tasks(); err != nil || !reflect.DeepEqual(tasks, []string{"42", "43"}) {
+		t.Errorf("Tasks() = %v, %v", tasks, err)
+	}
+
+	l := nginx.Limits()
+	if l.CPUs() != 0.5 || l.MemoryMax != 1<<30 || l.MemorySoftMax != UNLIMITED {
+		t.Errorf("unexpected limits %+v", l)
+	}
+}
+
+func TestProcessCgroups(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	m, err := ProcessCgroups("42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for controller, want := range map[string]string{
+		"cpuacct": "/web",
+		"memory":  "/web",
+		"io":      "/system.slice/nginx.service",
+	} {
+		if got := m.Path(controller); got != want {
+			t.Errorf("Path(%q) = %q, want %q", controller, got, want)
+		}
+	}
+
+	if _, err := ProcessCgroups("1"); err == nil {
+		t.Errorf("expected error for missing process")
+	}
+	var none *Membership
+	if none.Path("cpu") != "/" {
+		t.Errorf("nil membership should be in the root cgroup")
+	}
+}
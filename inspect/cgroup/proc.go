@@ -0,0 +1,62 @@
+// Copyright (c) 2014 Square, Inc
+
+package cgroup
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/square/prodeng/inspect/misc"
+)
+
+// Membership is the cgroup of a process in each hierarchy, from
+// /proc/<pid>/cgroup
+type Membership struct {
+	v1      map[string]string // by controller
+	unified string
+}
+
+// ProcessCgroups reads the cgroups of process pid
+func ProcessCgroups(pid string) (*Membership, error) {
+	file, err := os.Open(misc.ProcPath(pid, "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := &Membership{v1: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// controllers can be co-mounted, e.g. "4:cpu,cpuacct:/a";
+		// the v2 hierarchy has none: "0::/user.slice"
+		f := strings.SplitN(scanner.Text(), ":", 3)
+		if len(f) < 3 {
+			continue
+		}
+		if f[0] == "0" && f[1] == "" {
+			m.unified = f[2]
+			continue
+		}
+		for _, c := range strings.Split(f[1], ",") {
+			m.v1[c] = f[2]
+		}
+	}
+	return m, scanner.Err()
+}
+
+// Path returns the cgroup of the process in the hierarchy of
+// controller; the v2 one if controller isn't mounted as v1 and "/"
+// if there is none
+func (m *Membership) Path(controller string) string {
+	if m == nil {
+		return "/"
+	}
+	if p, ok := m.v1[controller]; ok {
+		return p
+	}
+	if m.unified != "" {
+		return m.unified
+	}
+	return "/"
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package check evaluates named checks against values gathered by
+// inspect and inspect-mysql and formats the result like a
+// Nagios/NRPE plugin:
+//
+//	INSPECT WARNING - disk.sdb.usage: 92.7% (> 75) | 'disk.sdb.usage'=92.70%;75;90
+//
+// The process is expected to exit with Result.Status.
+package check
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/square/prodeng/inspect/misc"
+)
+
+// Plugin exit codes
+const (
+	OK       = 0
+	WARNING  = 1
+	CRITICAL = 2
+	UNKNOWN  = 3
+)
+
+var statusNames = map[int]string{
+	OK:       "OK",
+	WARNING:  "WARNING",
+	CRITICAL: "CRITICAL",
+	UNKNOWN:  "UNKNOWN",
+}
+
+// severity orders statuses for picking the worst one
+var severity = map[int]int{OK: 0, UNKNOWN: 1, WARNING: 2, CRITICAL: 3}
+
+// Check alerts when values selected by a glob exceed thresholds
+type Check struct {
+	Name   string  // used on the command line
+	Select string  // glob over value names
+	Warn   float64 // warning if a value is above
+	Crit   float64 // critical if a value is above
+	Unit   string  // perfdata unit of measure: "%", "s", "B", ...
+
+	// values with a special meaning, reported with their own
+	// status instead of being compared to the thresholds
+	Sentinels []Sentinel
+}
+
+// Sentinel is a value that is not a measurement, such as -1 for
+// "not running"
+type Sentinel struct {
+	Value  float64
+	Status int
+	Text   string
+}
+
+type Result struct {
+	Status int
+	Output string // one line: status text | perfdata
+}
+
+// Lookup returns the checks named in a comma separated list
+func Lookup(available []*Check, names string) ([]*Check, error) {
+	var ret []*Check
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var found *Check
+		for _, c := range available {
+			if c.Name == name {
+				found = c
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown check %q (available: %s)",
+				name, strings.Join(Names(available), ", "))
+		}
+		ret = append(ret, found)
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no checks given")
+	}
+	return ret, nil
+}
+
+// Select looks up checks like Lookup and overrides their thresholds
+// with warn and crit unless those are empty
+func Select(available []*Check, names, warn, crit string) ([]*Check, error) {
+	checks, err := Lookup(available, names)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range checks {
+		if warn != "" {
+			if c.Warn, err = strconv.ParseFloat(warn, 64); err != nil {
+				return nil, fmt.Errorf("invalid warning threshold %q", warn)
+			}
+		}
+		if crit != "" {
+			if c.Crit, err = strconv.ParseFloat(crit, 64); err != nil {
+				return nil, fmt.Errorf("invalid critical threshold %q", crit)
+			}
+		}
+	}
+	return checks, nil
+}
+
+// Names returns names of checks
+func Names(checks []*Check) []string {
+	var ret []string
+	for _, c := range checks {
+		ret = append(ret, c.Name)
+	}
+	return ret
+}
+
+// Run evaluates checks against values. service prefixes the
+// output line, e.g. "INSPECT" or "MYSQL".
+func Run(service string, checks []*Check, values map[string]float64) *Result {
+	status := OK
+	var problems, okays, perfdata []string
+
+	for _, c := range checks {
+		re, err := misc.GlobToRegexp(c.Select)
+		if err != nil {
+			return Unknown(service, err)
+		}
+
+		var names []string
+		for name, v := range values {
+			if re.MatchString(name) && !math.IsNaN(v) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		if len(names) == 0 {
+			status = worst(status, UNKNOWN)
+			problems = append(problems, c.Name+": no data")
+			continue
+		}
+
+		for _, name := range names {
+			v := values[name]
+			if s := c.sentinel(v); s != nil {
+				status = worst(status, s.Status)
+				problems = append(problems, fmt.Sprintf("%s: %s", name, s.Text))
+				continue
+			}
+			switch {
+			case v > c.Crit:
+				status = worst(status, CRITICAL)
+				problems = append(problems,
+					fmt.Sprintf("%s: %.1f%s (> %v)", name, v, c.Unit, c.Crit))
+			case v > c.Warn:
+				status = worst(status, WARNING)
+				problems = append(problems,
+					fmt.Sprintf("%s: %.1f%s (> %v)", name, v, c.Unit, c.Warn))
+			default:
+				okays = append(okays, fmt.Sprintf("%s: %.1f%s", name, v, c.Unit))
+			}
+			perfdata = append(perfdata,
+				fmt.Sprintf("%s=%.2f%s;%v;%v", label(name), v, c.Unit, c.Warn, c.Crit))
+		}
+	}
+
+	// only list values that are not OK unless everything is
+	text := problems
+	if len(text) == 0 {
+		text = okays
+	}
+
+	out := fmt.Sprintf("%s %s - %s", service, statusNames[status], strings.Join(text, ", "))
+	if len(perfdata) > 0 {
+		out += " | " + strings.Join(perfdata, " ")
+	}
+	return &Result{Status: status, Output: out}
+}
+
+// Unknown returns an UNKNOWN result for err
+func Unknown(service string, err error) *Result {
+	return &Result{
+		Status: UNKNOWN,
+		Output: fmt.Sprintf("%s %s - %v", service, statusNames[UNKNOWN], err),
+	}
+}
+
+// Unexported functions
+
+func (c *Check) sentinel(v float64) *Sentinel {
+	for i := range c.Sentinels {
+		if c.Sentinels[i].Value == v {
+			return &c.Sentinels[i]
+		}
+	}
+	return nil
+}
+
+func worst(a, b int) int {
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
+
+// label quotes a perfdata label
+func label(name string) string {
+	return "'" + strings.Replace(name, "'", "''", -1) + "'"
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2014 Square, Inc
+
+package check
+
+import (
+	"math"
+	"testing"
+)
+
+var testChecks = []*Check{
+	{Name: "cpu", Select: "cpu.usage", Warn: 80, Crit: 90, Unit: "%"},
+	{Name: "diskio", Select: "disk.*.usage", Warn: 75, Crit: 90, Unit: "%"},
+}
+
+func TestLookup(t *testing.T) {
+	c, err := Lookup(testChecks, "cpu, diskio")
+	if err != nil || len(c) != 2 || c[1].Name != "diskio" {
+		t.Errorf("Lookup(cpu, diskio) = %v, %v", c, err)
+	}
+	if _, err := Lookup(testChecks, "foo"); err == nil {
+		t.Errorf("Lookup(foo) should fail")
+	}
+	if _, err := Lookup(testChecks, ""); err == nil {
+		t.Errorf("Lookup() should fail")
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		values map[string]float64
+		status int
+		output string
+	}{
+		{
+			map[string]float64{"cpu.usage": 12.34, "disk.sda.usage": 1},
+			OK,
+			"INSPECT OK - cpu.usage: 12.3%, disk.sda.usage: 1.0% | " +
+				"'cpu.usage'=12.34%;80;90 'disk.sda.usage'=1.00%;75;90",
+		},
+		{
+			map[string]float64{"cpu.usage": 85, "disk.sda.usage": 1, "disk.sdb.usage": 95},
+			CRITICAL,
+			"INSPECT CRITICAL - cpu.usage: 85.0% (> 80), disk.sdb.usage: 95.0% (> 90) | " +
+				"'cpu.usage'=85.00%;80;90 'disk.sda.usage'=1.00%;75;90 'disk.sdb.usage'=95.00%;75;90",
+		},
+		{
+			map[string]float64{"cpu.usage": math.NaN(), "disk.sda.usage": 80},
+			WARNING,
+			"INSPECT WARNING - cpu: no data, disk.sda.usage: 80.0% (> 75) | " +
+				"'disk.sda.usage'=80.00%;75;90",
+		},
+		{
+			map[string]float64{"cpu.usage": 1},
+			UNKNOWN,
+			"INSPECT UNKNOWN - diskio: no data | 'cpu.usage'=1.00%;80;90",
+		},
+	}
+
+	for i, tt := range tests {
+		r := Run("INSPECT", testChecks, tt.values)
+		if r.Status != tt.status || r.Output != tt.output {
+			t.Errorf("%d: Run() = %d %q\n want %d %q", i, r.Status, r.Output, tt.status, tt.output)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	available := []*Check{
+		{Name: "cpu", Select: "cpu.usage", Warn: 80, Crit: 90},
+		{Name: "mem", Select: "mem.usage_pct", Warn: 80, Crit: 90},
+	}
+	c, err := Select(available, "mem", "50", "")
+	if err != nil || len(c) != 1 || c[0].Warn != 50 || c[0].Crit != 90 {
+		t.Errorf("Select(mem, 50) = %v, %v", c, err)
+	}
+	if _, err := Select(available, "cpu", "", "x"); err == nil {
+		t.Errorf("Select with invalid threshold should fail")
+	}
+}
+
+func TestRunSentinels(t *testing.T) {
+	lag := []*Check{{Name: "replication_lag", Select: "lag", Warn: 60, Crit: 300, Unit: "s",
+		Sentinels: []Sentinel{
+			{Value: -2, Status: CRITICAL, Text: "replication stopped"},
+			{Value: -1, Status: UNKNOWN, Text: "backup running"},
+		}}}
+	tests := []struct {
+		lag    float64
+		status int
+		output string
+	}{
+		{0, OK, "MYSQL OK - lag: 0.0s | 'lag'=0.00s;60;300"},
+		{-2, CRITICAL, "MYSQL CRITICAL - lag: replication stopped"},
+		{-1, UNKNOWN, "MYSQL UNKNOWN - lag: backup running"},
+	}
+	for _, tt := range tests {
+		r := Run("MYSQL", lag, map[string]float64{"lag": tt.lag})
+		if r.Status != tt.status || r.Output != tt.output {
+			t.Errorf("lag %v: Run() = %d %q, want %d %q", tt.lag, r.Status, r.Output, tt.status, tt.output)
+		}
+	}
+}
@@ -0,0 +1,451 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package config reads the configuration file of inspect and
+// inspect-mysql (YAML or JSON). Every setting is optional:
+//
+//	step: 2s                    # default collection interval
+//	collectors:                 # per collector enablement and interval
+//	  pidstat: {interval: 10s}
+//	  fsstat: {enabled: false}
+//	devices:                    # block devices (diskstat)
+//	  exclude: ["sr*"]
+//	  exclude_majors: [1, 7, 253]   # ram, loop, device-mapper
+//	mounts:                     # mountpoints (fsstat)
+//	  exclude: ["/run/*"]
+//	  exclude_devices: [proc, sysfs, devpts, none, sunrpc]
+//	  exclude_options: [swap, bind, ignore, none]
+//	interfaces: {exclude: ["veth*"]}
+//	cgroups: {include: ["docker/*"]}
+//	processes:                  # processes tracked by pidstat
+//	  exclude: ["kworker*"]
+//	  min_cpu: 1                # % of a CPU ...
+//	  min_mem: 1                # ... or % of memory to be tracked
+//	  min_count: 5              # track everything below this many
+//	output:
+//	  top: 5                    # processes shown per list
+//	  color: true
+//	  format: json              # -once format: json or yaml
+//	plugins:                    # executables printing metrics
+//	  dir: /etc/inspect/plugins.d
+//	  interval: 60s
+//	  timeout: 10s
+//	  intervals: {check_raid: 5m}
+//	paths:                      # where kernel interfaces are read
+//	  proc: /host/proc          # from; applied at startup
+//	  sys: /host/sys
+//	  mtab: /host/proc/1/mounts
+//	  root: /host/root
+//
+// Patterns are globs ('*' and '?'). A name is included if it matches
+// an include pattern (or there are none) and no exclude pattern.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"gopkg.in/yaml.v2"
+)
+
+// Collector names
+const (
+	CPUStat        = "cpustat"
+	MemStat        = "memstat"
+	PidStat        = "pidstat"
+	DiskStat       = "diskstat"
+	FSStat         = "fsstat"
+	InterfaceStat  = "interfacestat"
+	CgroupCPUStat  = "cgroup_cpustat"
+	CgroupMemStat  = "cgroup_memstat"
+	CgroupDiskStat = "cgroup_diskstat"
+	PSIStat        = "psistat"
+	LoadStat       = "loadstat"
+	VMStat         = "vmstat"
+	NetStat        = "netstat"
+	SocketStat     = "socketstat"
+	FreqStat       = "freqstat"
+	CgroupPSIStat  = "cgroup_psistat"
+	MysqlStat      = "mysqlstat"
+	MysqlStatTable = "mysqlstattable"
+)
+
+// collectorNames are the names accepted under collectors:
+var collectorNames = map[string]bool{
+	CPUStat: true, MemStat: true, PidStat: true, DiskStat: true,
+	FSStat: true, InterfaceStat: true, CgroupCPUStat: true,
+	CgroupMemStat: true, CgroupDiskStat: true, PSIStat: true,
+	LoadStat: true, VMStat: true, NetStat: true, SocketStat: true,
+	FreqStat: true, CgroupPSIStat: true, MysqlStat: true,
+	MysqlStatTable: true,
+}
+
+type Config struct {
+	Step       string                `yaml:"step" json:"step"`
+	Collectors map[string]*Collector `yaml:"collectors" json:"collectors"`
+	Devices    DeviceFilter          `yaml:"devices" json:"devices"`
+	Mounts     MountFilter           `yaml:"mounts" json:"mounts"`
+	Interfaces Filter                `yaml:"interfaces" json:"interfaces"`
+	Cgroups    Filter                `yaml:"cgroups" json:"cgroups"`
+	Processes  ProcessFilter         `yaml:"processes" json:"processes"`
+	Output     Output                `yaml:"output" json:"output"`
+	Plugins    Plugins               `yaml:"plugins" json:"plugins"`
+	Paths      misc.FS               `yaml:"paths" json:"paths"`
+	step       time.Duration
+}
+
+type Collector struct {
+	Enabled  *bool  `yaml:"enabled" json:"enabled"` // default true
+	Interval string `yaml:"interval" json:"interval"`
+	interval time.Duration
+}
+
+// Filter selects names by include and exclude globs
+type Filter struct {
+	Include []string `yaml:"include" json:"include"`
+	Exclude []string `yaml:"exclude" json:"exclude"`
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+type DeviceFilter struct {
+	Filter        `yaml:",inline"`
+	ExcludeMajors []uint64 `yaml:"exclude_majors" json:"exclude_majors"`
+}
+
+type MountFilter struct {
+	Filter         `yaml:",inline"`
+	ExcludeDevices []string `yaml:"exclude_devices" json:"exclude_devices"`
+	ExcludeOptions []string `yaml:"exclude_options" json:"exclude_options"`
+}
+
+// ProcessFilter selects processes by command name and usage
+type ProcessFilter struct {
+	Filter   `yaml:",inline"`
+	MinCPU   float64 `yaml:"min_cpu" json:"min_cpu"`     // % of one CPU
+	MinMem   float64 `yaml:"min_mem" json:"min_mem"`     // % of memory
+	MinCount int     `yaml:"min_count" json:"min_count"` // processes tracked regardless of usage
+}
+
+type Output struct {
+	Top    int    `yaml:"top" json:"top"`
+	Color  *bool  `yaml:"color" json:"color"`
+	Format string `yaml:"format" json:"format"`
+}
+
+// Plugins configures external plugin collectors
+type Plugins struct {
+	Dir       string            `yaml:"dir" json:"dir"`
+	Interval  string            `yaml:"interval" json:"interval"`   // default for all plugins
+	Timeout   string            `yaml:"timeout" json:"timeout"`     // plugins are killed after
+	Intervals map[string]string `yaml:"intervals" json:"intervals"` // per plugin file name
+	interval  time.Duration
+	timeout   time.Duration
+	intervals map[string]time.Duration
+}
+
+// Default returns the configuration used without a config file
+func Default() *Config {
+	c, err := Parse(nil)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Load reads a configuration file
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse reads a configuration from YAML or JSON data. Settings
+// missing from data keep their defaults.
+func Parse(data []byte) (*Config, error) {
+	c := new(Config)
+	c.Step = "2s"
+	c.Devices.ExcludeMajors = []uint64{1, 7, 253}
+	c.Mounts.ExcludeDevices = []string{"proc", "sysfs", "devpts", "none", "sunrpc"}
+	c.Mounts.ExcludeOptions = []string{"swap", "bind", "ignore", "none"}
+	c.Processes.MinCPU = 1
+	c.Processes.MinMem = 1
+	c.Processes.MinCount = 5
+	c.Output.Top = 5
+	c.Output.Format = "json"
+	c.Plugins.Interval = "60s"
+	c.Plugins.Timeout = "10s"
+	c.Paths = misc.DefaultFS()
+
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if err := c.compile(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Enabled returns whether the collector name should run
+func (c *Config) Enabled(name string) bool {
+	o, ok := c.Collectors[name]
+	return !ok || o.Enabled == nil || *o.Enabled
+}
+
+// Interval returns the collection interval of the collector name
+func (c *Config) Interval(name string) time.Duration {
+	if o, ok := c.Collectors[name]; ok && o.interval > 0 {
+		return o.interval
+	}
+	return c.step
+}
+
+// StepDuration returns the default collection interval
+func (c *Config) StepDuration() time.Duration {
+	return c.step
+}
+
+// ColorEnabled returns whether output should be colored
+func (o *Output) ColorEnabled() bool {
+	return o.Color == nil || *o.Color
+}
+
+// Empty returns whether the filter has no patterns and selects
+// everything
+func (f *Filter) Empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// Match returns whether name is selected by the filter
+func (f *Filter) Match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns whether a block device should be collected
+func (f *DeviceFilter) Match(name string, major uint64) bool {
+	for _, m := range f.ExcludeMajors {
+		if m == major {
+			return false
+		}
+	}
+	return f.Filter.Match(name)
+}
+
+// Match returns whether a mount should be collected; options is
+// the comma separated mount options field of /etc/mtab
+func (f *MountFilter) Match(device, mountpoint, options string) bool {
+	for _, d := range f.ExcludeDevices {
+		if d == device {
+			return false
+		}
+	}
+	for _, o := range strings.Split(options, ",") {
+		for _, x := range f.ExcludeOptions {
+			if o == x {
+				return false
+			}
+		}
+	}
+	return f.Filter.Match(mountpoint)
+}
+
+// IntervalOf returns the interval of the plugin file name
+func (p *Plugins) IntervalOf(name string) time.Duration {
+	if d, ok := p.intervals[name]; ok {
+		return d
+	}
+	return p.interval
+}
+
+// TimeoutDuration returns how long a plugin may run
+func (p *Plugins) TimeoutDuration() time.Duration {
+	return p.timeout
+}
+
+// Loader holds the current configuration and reloads it from its
+// file on SIGHUP
+type Loader struct {
+	Path     string
+	OnReload []func(*Config) // called after every successful reload
+	current  *Config
+	mu       sync.RWMutex
+}
+
+// NewLoader loads path; an empty path gives the default
+// configuration
+func NewLoader(path string) (*Loader, error) {
+	l := new(Loader)
+	l.Path = path
+	l.current = Default()
+	if path == "" {
+		return l, nil
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Config returns the current configuration
+func (l *Loader) Config() *Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.current
+}
+
+// Reload reads the file again. The current configuration is kept if
+// it can't be read.
+func (l *Loader) Reload() error {
+	if l.Path == "" {
+		return nil
+	}
+	c, err := Load(l.Path)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.current = c
+	l.mu.Unlock()
+	for _, f := range l.OnReload {
+		f(c)
+	}
+	return nil
+}
+
+// WatchSignals reloads the configuration on every SIGHUP
+func (l *Loader) WatchSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for _ = range ch {
+			if err := l.Reload(); err != nil {
+				log.Printf("config: %v", err)
+				continue
+			}
+			log.Printf("config: reloaded %s", l.Path)
+		}
+	}()
+}
+
+// Unexported functions
+
+func (c *Config) compile() error {
+	var err error
+	if c.step, err = positiveDuration(c.Step); err != nil {
+		return fmt.Errorf("step: %v", err)
+	}
+
+	for name, o := range c.Collectors {
+		if !collectorNames[name] {
+			return fmt.Errorf("collectors: unknown collector %q", name)
+		}
+		if o == nil {
+			c.Collectors[name] = new(Collector)
+			continue
+		}
+		if o.Interval == "" {
+			continue
+		}
+		if o.interval, err = positiveDuration(o.Interval); err != nil {
+			return fmt.Errorf("collectors: %s: %v", name, err)
+		}
+	}
+
+	for name, f := range map[string]*Filter{
+		"devices":    &c.Devices.Filter,
+		"mounts":     &c.Mounts.Filter,
+		"interfaces": &c.Interfaces,
+		"cgroups":    &c.Cgroups,
+		"processes":  &c.Processes.Filter,
+	} {
+		if err := f.compile(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+
+	if err := c.Plugins.compile(); err != nil {
+		return fmt.Errorf("plugins: %v", err)
+	}
+
+	switch c.Output.Format {
+	case "json", "yaml":
+	default:
+		return fmt.Errorf("output: unknown format %q", c.Output.Format)
+	}
+	return nil
+}
+
+func (f *Filter) compile() error {
+	f.include = f.include[:0]
+	f.exclude = f.exclude[:0]
+	for _, g := range f.Include {
+		re, err := misc.GlobToRegexp(g)
+		if err != nil {
+			return err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, g := range f.Exclude {
+		re, err := misc.GlobToRegexp(g)
+		if err != nil {
+			return err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return nil
+}
+
+func (p *Plugins) compile() error {
+	var err error
+	if p.interval, err = positiveDuration(p.Interval); err != nil {
+		return fmt.Errorf("interval: %v", err)
+	}
+	if p.timeout, err = positiveDuration(p.Timeout); err != nil {
+		return fmt.Errorf("timeout: %v", err)
+	}
+	p.intervals = make(map[string]time.Duration, len(p.Intervals))
+	for name, v := range p.Intervals {
+		if p.intervals[name], err = positiveDuration(v); err != nil {
+			return fmt.Errorf("intervals: %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func positiveDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2014 Square, Inc
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	if c.StepDuration() != 2*time.Second || c.Output.Top != 5 || !c.Output.ColorEnabled() {
+		t.Errorf("unexpected defaults %+v", c)
+	}
+	if !c.Enabled(PidStat) || c.Interval(PidStat) != 2*time.Second {
+		t.Errorf("pidstat should be enabled every step")
+	}
+	if c.Devices.Match("loop0", 7) || !c.Devices.Match("sda", 8) {
+		t.Errorf("unexpected default device filter")
+	}
+	if c.Mounts.Match("proc", "/proc", "rw") || c.Mounts.Match("/dev/sda2", "/mnt", "bind") ||
+		!c.Mounts.Match("/dev/sda1", "/", "rw,relatime") {
+		t.Errorf("unexpected default mount filter")
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+step: 5s
+collectors:
+  pidstat: {interval: 10s}
+  fsstat: {enabled: false}
+devices:
+  exclude: ["sr*"]
+interfaces:
+  include: ["eth*", "bond*"]
+  exclude: ["eth9"]
+processes:
+  exclude: ["kworker*"]
+  min_count: 10
+output:
+  top: 10
+  color: false
+  format: yaml
+paths:
+  proc: /host/proc
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Interval(PidStat) != 10*time.Second || c.Interval(CPUStat) != 5*time.Second {
+		t.Errorf("unexpected intervals")
+	}
+	if c.Enabled(FSStat) || !c.Enabled(DiskStat) {
+		t.Errorf("unexpected enablement")
+	}
+	if c.Devices.Match("sr0", 11) || !c.Devices.Match("sdb", 8) || c.Devices.Match("ram0", 1) {
+		t.Errorf("unexpected device filter")
+	}
+	for name, want := range map[string]bool{"eth0": true, "bond0": true, "eth9": false, "lo": false} {
+		if got := c.Interfaces.Match(name); got != want {
+			t.Errorf("Interfaces.Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if c.Processes.Match("kworker/0:1") || c.Processes.MinCount != 10 || c.Processes.MinCPU != 1 {
+		t.Errorf("unexpected process filter %+v", c.Processes)
+	}
+	if c.Output.Top != 10 || c.Output.ColorEnabled() || c.Output.Format != "yaml" {
+		t.Errorf("unexpected output %+v", c.Output)
+	}
+	if c.Paths.Proc != "/host/proc" || c.Paths.Sys != "/sys" {
+		t.Errorf("unexpected paths %+v", c.Paths)
+	}
+
+	for _, bad := range []string{
+		"step: soon",
+		"collectors: {pidstat: {interval: -1s}}",
+		"collectors: {pidsat: {enabled: false}}",
+		"output: {format: xml}",
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "inspect.yml")
+
+	if err := ioutil.WriteFile(path, []byte("output: {top: 3}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewLoader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded *Config
+	l.OnReload = append(l.OnReload, func(c *Config) { reloaded = c })
+
+	ioutil.WriteFile(path, []byte("output: {top: 7}\n"), 0644)
+	if err := l.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if l.Config().Output.Top != 7 || reloaded != l.Config() {
+		t.Errorf("configuration not reloaded")
+	}
+
+	// a broken file keeps the current configuration
+	ioutil.WriteFile(path, []byte("output: {format: xml}\n"), 0644)
+	if err := l.Reload(); err == nil || l.Config().Output.Top != 7 {
+		t.Errorf("broken configuration replaced the current one")
+	}
+}
@@ -4,18 +4,28 @@ package cpustat
 
 import (
 	"bufio"
+	"github.com/square/prodeng/inspect/cgroup"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
+	"math"
 	"os"
-	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type CgroupStat struct {
-	Cgroups    map[string]*PerCgroupStat
+	Cgroups    map[string]*PerCgroupStat // by path
+	Hierarchy  *cgroup.Hierarchy
 	m          *metrics.MetricContext
 	Mountpoint string
+	Unified    bool // cgroup v2
+	Schedule   *misc.Schedule
+	filter     atomic.Value // *config.Filter, replaced on reload
+	mu         sync.RWMutex // guards Cgroups
 }
 
 func NewCgroupStat(m *metrics.MetricContext, Step time.Duration) *CgroupStat {
@@ -23,65 +33,93 @@ func NewCgroupStat(m *metrics.MetricContext, Step time.Duration) *CgroupStat {
 	c.m = m
 
 	c.Cgroups = make(map[string]*PerCgroupStat, 1)
+	c.filter.Store(&config.Default().Cgroups)
 
-	mountpoint, err := misc.FindCgroupMount("cpu")
+	h, err := cgroup.Find("cpu")
 	if err != nil {
 		return c
 	}
-	c.Mountpoint = mountpoint
+	c.Hierarchy = h
+	c.Mountpoint = h.Mountpoint
+	c.Unified = h.Unified
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect(mountpoint)
-		}
-	}()
+	c.Schedule = misc.NewSchedule("cgroup_cpustat", Step, c.Collect)
 
 	return c
 }
 
-func (c *CgroupStat) Collect(mountpoint string) {
+// SetFilter selects cgroups by name relative to the mountpoint;
+// cgroups no longer selected are dropped on the next collection
+func (c *CgroupStat) SetFilter(f *config.Filter) {
+	c.filter.Store(f)
+}
+
+// PerCgroup returns a copy of the cgroups by path
+func (c *CgroupStat) PerCgroup() map[string]*PerCgroupStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerCgroupStat, len(c.Cgroups))
+	for path, o := range c.Cgroups {
+		ret[path] = o
+	}
+	return ret
+}
 
-	cgroups, err := misc.FindCgroups(mountpoint)
+func (c *CgroupStat) Collect() {
+	filter := c.filter.Load().(*config.Filter)
+	if c.Hierarchy == nil {
+		return
+	}
+	cgroups, err := c.Hierarchy.Cgroups()
 	if err != nil {
+		misc.CollectorFailed("cgroup_cpustat", err)
 		return
 	}
 
-	// stop tracking cgroups which don't exist
-	// anymore or have no tasks
-	cgroupsMap := make(map[string]bool, len(cgroups))
-	for _, cgroup := range cgroups {
-		cgroupsMap[cgroup] = true
-	}
+	seen := make(map[string]bool, len(cgroups))
+	for _, cg := range cgroups {
+		if !filter.Match(cg.Name) {
+			continue
+		}
+		seen[cg.Path] = true
 
-	for cgroup, _ := range c.Cgroups {
-		_, ok := cgroupsMap[cgroup]
+		o, ok := c.Cgroups[cg.Path]
 		if !ok {
-			delete(c.Cgroups, cgroup)
+			o = NewPerCgroupStat(c.m, cg)
+			c.mu.Lock()
+			c.Cgroups[cg.Path] = o
+			c.mu.Unlock()
 		}
+		o.Metrics.Collect()
 	}
 
-	for _, cgroup := range cgroups {
-		_, ok := c.Cgroups[cgroup]
-		if !ok {
-			c.Cgroups[cgroup] = NewPerCgroupStat(c.m, cgroup, mountpoint)
+	// stop tracking cgroups which don't exist
+	// anymore, have no tasks or are excluded
+	c.mu.Lock()
+	for path, o := range c.Cgroups {
+		if !seen[path] {
+			misc.UnregisterMetrics(o.Metrics, c.m, o.Metrics.prefix)
+			delete(c.Cgroups, path)
 		}
-		c.Cgroups[cgroup].Metrics.Collect()
 	}
+	c.mu.Unlock()
+	misc.CollectorSucceeded("cgroup_cpustat")
 }
 
 // Per Cgroup functions
 
 type PerCgroupStat struct {
 	Metrics *PerCgroupStatMetrics
+	Cgroup  *cgroup.Cgroup
 	m       *metrics.MetricContext
 }
 
-func NewPerCgroupStat(m *metrics.MetricContext, path string, mp string) *PerCgroupStat {
+func NewPerCgroupStat(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStat {
 	c := new(PerCgroupStat)
 	c.m = m
+	c.Cgroup = cg
 
-	c.Metrics = NewPerCgroupStatMetrics(m, path, mp)
+	c.Metrics = NewPerCgroupStatMetrics(m, cg)
 
 	return c
 }
@@ -98,6 +136,16 @@ func (s *PerCgroupStat) Throttle() float64 {
 	return (throttled_sec / (1 * 1000 * 1000 * 1000)) * 100
 }
 
+// Usage returns % of one CPU used by the cgroup; NaN if the kernel
+// doesn't account it (v1 without cpuacct co-mounted)
+func (s *PerCgroupStat) Usage() float64 {
+	o := s.Metrics
+	if !o.accounted {
+		return math.NaN()
+	}
+	return (o.Usage.ComputeRate() / (1 * 1000 * 1000 * 1000)) * 100
+}
+
 // Quota returns how many logical CPUs can be used
 
 func (s *PerCgroupStat) Quota() float64 {
@@ -111,25 +159,39 @@ type PerCgroupStatMetrics struct {
 	Throttled_time *metrics.Counter
 	Cfs_period_us  *metrics.Gauge
 	Cfs_quota_us   *metrics.Gauge
-	path           string
+	Usage          *metrics.Counter // ns of CPU time used
+	cgroup         *cgroup.Cgroup
+	prefix         string
+	accounted      bool // Usage is collected
 }
 
-func NewPerCgroupStatMetrics(m *metrics.MetricContext, path string, mp string) *PerCgroupStatMetrics {
+func NewPerCgroupStatMetrics(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStatMetrics {
 	c := new(PerCgroupStatMetrics)
-	c.path = path
+	c.cgroup = cg
 
 	// initialize all metrics and register them
-	prefix, _ := filepath.Rel(mp, path)
-	misc.InitializeMetrics(c, m, "cpustat.cgroup."+prefix, true)
+	c.prefix = "cpustat.cgroup." + cg.Name
+	misc.InitializeMetrics(c, m, c.prefix, true)
 
 	return c
 }
 
 func (s *PerCgroupStatMetrics) Collect() {
-	file, err := os.Open(s.path + "/" + "cpu.stat")
+	cg := s.cgroup
+	l := cg.Limits()
+	s.Cfs_period_us.Set(l.CPUPeriod)
+	s.Cfs_quota_us.Set(l.CPUQuota)
+
+	if cg.Hierarchy.Unified {
+		s.collectUnified()
+		return
+	}
+
+	file, err := os.Open(cg.File("cpu.stat"))
 	if err != nil {
 		return
 	}
+	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -148,11 +210,40 @@ func (s *PerCgroupStatMetrics) Collect() {
 		}
 	}
 
-	s.Cfs_period_us.Set(
-		float64(misc.ReadUintFromFile(
-			s.path + "/" + "cpu.cfs_period_us")))
+	// only if cpuacct is mounted with cpu
+	if cg.Hierarchy.Has("cpuacct") {
+		s.Usage.Set(misc.ReadUintFromFile(cg.File("cpuacct.usage")))
+		s.accounted = true
+	}
+}
 
-	s.Cfs_quota_us.Set(
-		float64(misc.ReadUintFromFile(
-			s.path + "/" + "cpu.cfs_quota_us")))
+// Unexported functions
+
+// collectUnified reads cgroup v2 cpu.stat; times are in usec and
+// converted to the ns of v1
+func (s *PerCgroupStatMetrics) collectUnified() {
+	file, err := os.Open(s.cgroup.File("cpu.stat"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "usage_usec":
+			s.Usage.Set(misc.ParseUint(f[1]) * 1000)
+			s.accounted = true
+		case "nr_periods":
+			s.Nr_periods.Set(misc.ParseUint(f[1]))
+		case "nr_throttled":
+			s.Nr_throttled.Set(misc.ParseUint(f[1]))
+		case "throttled_usec":
+			s.Throttled_time.Set(misc.ParseUint(f[1]) * 1000)
+		}
+	}
 }
@@ -1,5 +1,6 @@
 package cpustat
 
+import "errors"
 import "unsafe"
 import "time"
 import "math"
@@ -18,8 +19,9 @@ import "github.com/square/prodeng/inspect/misc"
 import "C"
 
 type CPUStat struct {
-	All *CPUStatPerCPU
-	m   *metrics.MetricContext
+	All      *CPUStatPerCPU
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
 }
 
 type CPUStatPerCPU struct {
@@ -34,12 +36,7 @@ func New(m *metrics.MetricContext, Step time.Duration) *CPUStat {
 	c := new(CPUStat)
 	c.All = CPUStatPerCPUNew(m, "cpu")
 	c.m = m
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect()
-		}
-	}()
+	c.Schedule = misc.NewSchedule("cpustat", Step, c.Collect)
 	return c
 }
 
@@ -54,6 +51,7 @@ func (s *CPUStat) Collect() {
 		C.host_info_t(unsafe.Pointer(&cpuinfo)), &count)
 
 	if ret != C.KERN_SUCCESS {
+		misc.CollectorFailed("cpustat", errors.New("host_statistics failed"))
 		return
 	}
 
@@ -67,6 +65,7 @@ func (s *CPUStat) Collect() {
 		uint64(cpuinfo.cpu_ticks[C.CPU_STATE_NICE]) +
 		uint64(cpuinfo.cpu_ticks[C.CPU_STATE_IDLE]))
 
+	misc.CollectorSucceeded("cpustat")
 }
 
 // Usage returns current total CPU usage in percentage across all CPUs
@@ -9,50 +9,67 @@ import (
 	"math"
 	"os"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type CPUStat struct {
 	All           *CPUStatPerCPU
-	Procs_running *metrics.Counter
-	Procs_blocked *metrics.Counter
+	Procs_running *metrics.Gauge    // runnable tasks
+	Procs_blocked *metrics.Gauge    // tasks blocked on IO
+	Ctxt          *metrics.Counter  // context switches
+	Intr          *metrics.Counter  // interrupts serviced
+	Processes     *metrics.Counter  // forks
+	IRQDevices    map[string]string // chip and devices of /proc/interrupts by irq
 	cpus          map[string]*CPUStatPerCPU
+	Schedule      *misc.Schedule
 	m             *metrics.MetricContext
+	mu            sync.RWMutex // guards cpus and IRQDevices
 }
 
 type CPUStatPerCPU struct {
-	User        *metrics.Counter
-	UserLowPrio *metrics.Counter
-	System      *metrics.Counter
-	Idle        *metrics.Counter
-	Iowait      *metrics.Counter
-	Irq         *metrics.Counter
-	Softirq     *metrics.Counter
-	Steal       *metrics.Counter
-	Guest       *metrics.Counter
-	Total       *metrics.Counter // total jiffies
+	User         *metrics.Counter
+	UserLowPrio  *metrics.Counter
+	System       *metrics.Counter
+	Idle         *metrics.Counter
+	Iowait       *metrics.Counter
+	Irq          *metrics.Counter
+	Softirq      *metrics.Counter
+	Steal        *metrics.Counter
+	Guest        *metrics.Counter
+	GuestLowPrio *metrics.Counter            // guest_nice
+	Total        *metrics.Counter            // total jiffies
+	Interrupts   *metrics.Counter            // interrupts of all irqs
+	Softirqs     *metrics.Counter            // softirqs of all kinds
+	Top_irq      *metrics.Gauge              // per second of the busiest irq
+	IRQs         map[string]*metrics.Counter // interrupts by irq, unregistered
+	SoftIRQs     map[string]*metrics.Counter // softirqs by kind, unregistered
+	mu           sync.RWMutex                // guards IRQs and SoftIRQs
 }
 
 func New(m *metrics.MetricContext, Step time.Duration) *CPUStat {
 	c := new(CPUStat)
 	c.All = NewCPUStatPerCPU(m, "cpu")
 	c.m = m
+	misc.InitializeMetrics(c, m, "cpustat", true)
 	c.cpus = make(map[string]*CPUStatPerCPU, 1)
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect()
-		}
-	}()
+	c.IRQDevices = make(map[string]string)
+	c.Schedule = misc.NewSchedule("cpustat", Step, c.Collect)
 	return c
 }
 
 // XXX: break this up into two smaller functions
 func (s *CPUStat) Collect() {
-	file, err := os.Open("/proc/stat")
+	file, err := os.Open(misc.ProcPath("stat"))
 	if err != nil {
+		misc.CollectorFailed("cpustat", err)
 		return
 	}
+	defer file.Close()
+
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		f := regexp.MustCompile("\\s+").Split(scanner.Text(), -1)
@@ -62,15 +79,31 @@ func (s *CPUStat) Collect() {
 			if f[0] == "cpu" {
 				parseCPUline(s.All, f)
 			} else {
-				per_cpu, ok := s.cpus[f[0]]
-				if !ok {
-					per_cpu = NewCPUStatPerCPU(s.m, f[0])
-					s.cpus[f[0]] = per_cpu
-				}
-				parseCPUline(per_cpu, f)
+				parseCPUline(s.perCPU(f[0]), f)
 			}
+			continue
+		}
+
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "procs_running":
+			s.Procs_running.Set(float64(misc.ParseUint(f[1])))
+		case "procs_blocked":
+			s.Procs_blocked.Set(float64(misc.ParseUint(f[1])))
+		case "ctxt":
+			s.Ctxt.Set(misc.ParseUint(f[1]))
+		case "intr":
+			// total followed by counts per interrupt
+			s.Intr.Set(misc.ParseUint(f[1]))
+		case "processes":
+			s.Processes.Set(misc.ParseUint(f[1]))
 		}
 	}
+	s.collectInterrupts(misc.ProcPath("interrupts"), false)
+	s.collectInterrupts(misc.ProcPath("softirqs"), true)
+	misc.CollectorSucceeded("cpustat")
 }
 
 // Usage returns current total CPU usage in percentage across all CPUs
@@ -84,85 +117,195 @@ func (o *CPUStat) UserSpace() float64 {
 	return o.All.UserSpace()
 }
 
-// Kernel returns time spent in userspace as percentage across all
+// Kernel returns time spent in kernel as percentage across all
 // CPUs
 func (o *CPUStat) Kernel() float64 {
 	return o.All.Kernel()
 }
 
-// CPUS returns all CPUS found as a slice of strings
+// IOWait returns time spent idle waiting for IO as percentage
+// across all CPUs
+func (o *CPUStat) IOWait() float64 {
+	return o.All.IOWait()
+}
+
+// StealTime returns time the hypervisor ran other guests as
+// percentage across all CPUs
+func (o *CPUStat) StealTime() float64 {
+	return o.All.StealTime()
+}
+
+// IRQ returns time spent servicing hardware interrupts as
+// percentage across all CPUs
+func (o *CPUStat) IRQ() float64 {
+	return o.All.IRQ()
+}
+
+// SoftIRQ returns time spent in softirqs as percentage across all
+// CPUs
+func (o *CPUStat) SoftIRQ() float64 {
+	return o.All.SoftIRQ()
+}
+
+// CPUS returns the names of all CPUs found ("cpu0", "cpu1", ...)
+// in numerical order
 func (o *CPUStat) CPUS() []string {
-	ret := make([]string, 1)
-	for k, _ := range o.cpus {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	ret := make([]string, 0, len(o.cpus))
+	for k := range o.cpus {
 		ret = append(ret, k)
 	}
-
+	sort.Slice(ret, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimPrefix(ret[i], "cpu"))
+		b, _ := strconv.Atoi(strings.TrimPrefix(ret[j], "cpu"))
+		return a < b
+	})
 	return ret
 }
 
-// PerCPUStat returns per-CPU stats for argument "cpu"
+// IMBALANCE_MIN_USAGE is the average usage % the CPUs other than the
+// busiest need for an imbalance; a single busy thread on an otherwise
+// idle host isn't one
+const IMBALANCE_MIN_USAGE = 20
+
+// Imbalance returns how many percentage points the busiest CPU's
+// usage is above the average of the other CPUs; 0 with a single CPU
+// or while the others are mostly idle
+func (o *CPUStat) Imbalance() float64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	usage := make([]float64, 0, len(o.cpus))
+	for _, c := range o.cpus {
+		if u := c.Usage(); !math.IsNaN(u) {
+			usage = append(usage, u)
+		}
+	}
+	return imbalance(usage)
+}
+
+// PerCPUStat returns per-CPU stats for argument "cpu" ("cpu0"),
+// including its interrupt and softirq counts
 func (o *CPUStat) PerCPUStat(cpu string) *CPUStatPerCPU {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 	return o.cpus[cpu]
 }
 
+// IRQDevice returns the chip and devices of irq ("24"), as listed
+// in /proc/interrupts
+func (o *CPUStat) IRQDevice(irq string) string {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.IRQDevices[irq]
+}
+
 // NewCPUStatPerCPU returns a struct representing counters for
 // per CPU statistics
 func NewCPUStatPerCPU(m *metrics.MetricContext, name string) *CPUStatPerCPU {
 	o := new(CPUStatPerCPU)
+	o.IRQs = make(map[string]*metrics.Counter)
+	o.SoftIRQs = make(map[string]*metrics.Counter)
 
 	// initialize all metrics and register them
 	misc.InitializeMetrics(o, m, "cpustat."+name, true)
 	return o
 }
 
-// Usage returns total percentage of CPU used
+// Usage returns total percentage of CPU used: in userspace, the
+// kernel and servicing interrupts
 func (o *CPUStatPerCPU) Usage() float64 {
-	u := o.User.ComputeRate()
-	n := o.UserLowPrio.ComputeRate()
-	s := o.System.ComputeRate()
-	t := o.Total.ComputeRate()
-
-	if u != math.NaN() && n != math.NaN() && s != math.NaN() &&
-		t != math.NaN() && t > 0 {
-		return (u + s + n) / t * 100
-	} else {
-		return math.NaN()
-	}
+	return o.pct(o.User, o.UserLowPrio, o.System, o.Irq, o.Softirq)
 }
 
 // UserSpace returns percentage of time spent in userspace
 // on this CPU
 func (o *CPUStatPerCPU) UserSpace() float64 {
-	u := o.User.ComputeRate()
-	n := o.UserLowPrio.ComputeRate()
-	t := o.Total.ComputeRate()
-	if u != math.NaN() && t != math.NaN() && n != math.NaN() && t > 0 {
-		return (u + n) / t * 100
-	}
-	return math.NaN()
+	return o.pct(o.User, o.UserLowPrio)
 }
 
 // Kernel returns percentage of time spent in kernel
 // on this CPU
 func (o *CPUStatPerCPU) Kernel() float64 {
-	s := o.System.ComputeRate()
+	return o.pct(o.System)
+}
+
+// IOWait returns percentage of time this CPU was idle with IO
+// outstanding
+func (o *CPUStatPerCPU) IOWait() float64 {
+	return o.pct(o.Iowait)
+}
+
+// StealTime returns percentage of time the hypervisor ran
+// something else on this CPU
+func (o *CPUStatPerCPU) StealTime() float64 {
+	return o.pct(o.Steal)
+}
+
+// IRQ returns percentage of time spent servicing hardware
+// interrupts on this CPU
+func (o *CPUStatPerCPU) IRQ() float64 {
+	return o.pct(o.Irq)
+}
+
+// SoftIRQ returns percentage of time spent in softirqs (network
+// receive, timers, ...) on this CPU
+func (o *CPUStatPerCPU) SoftIRQ() float64 {
+	return o.pct(o.Softirq)
+}
+
+// Unexported functions
+
+// pct returns the rate of counters as percentage of all time
+func (o *CPUStatPerCPU) pct(counters ...*metrics.Counter) float64 {
 	t := o.Total.ComputeRate()
-	if s != math.NaN() && t != math.NaN() && t > 0 {
-		return (s / t) * 100
+	if math.IsNaN(t) || t <= 0 {
+		return math.NaN()
 	}
-	return math.NaN()
+	var sum float64
+	for _, c := range counters {
+		r := c.ComputeRate()
+		if math.IsNaN(r) {
+			return math.NaN()
+		}
+		sum += r
+	}
+	return sum / t * 100
 }
 
-// Unexported functions
+func imbalance(usage []float64) float64 {
+	if len(usage) < 2 {
+		return 0
+	}
+	var sum, max float64
+	for _, u := range usage {
+		sum += u
+		if u > max {
+			max = u
+		}
+	}
+	others := (sum - max) / float64(len(usage)-1)
+	if others < IMBALANCE_MIN_USAGE {
+		return 0
+	}
+	return max - others
+}
+
+// parseCPUline parses "cpu0 user nice system idle iowait irq softirq
+// steal guest guest_nice"; older kernels have fewer fields
 func parseCPUline(s *CPUStatPerCPU, f []string) {
-	s.User.Set(misc.ParseUint(f[1]))
-	s.UserLowPrio.Set(misc.ParseUint(f[2]))
-	s.System.Set(misc.ParseUint(f[3]))
-	s.Idle.Set(misc.ParseUint(f[4]))
-	s.Iowait.Set(misc.ParseUint(f[5]))
-	s.Irq.Set(misc.ParseUint(f[6]))
-	s.Softirq.Set(misc.ParseUint(f[7]))
-	s.Steal.Set(misc.ParseUint(f[8]))
-	s.Guest.Set(misc.ParseUint(f[9]))
-	s.Total.Set(s.User.Get() + s.UserLowPrio.Get() + s.System.Get() + s.Idle.Get())
+	counters := []*metrics.Counter{s.User, s.UserLowPrio, s.System, s.Idle,
+		s.Iowait, s.Irq, s.Softirq, s.Steal, s.Guest, s.GuestLowPrio}
+	for i, c := range counters {
+		if i+1 < len(f) {
+			c.Set(misc.ParseUint(f[i+1]))
+		}
+	}
+
+	// guest time is accounted in user and nice as well
+	var total uint64
+	for _, c := range counters[:8] {
+		total += c.Get()
+	}
+	s.Total.Set(total)
 }
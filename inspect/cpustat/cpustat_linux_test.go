@@ -0,0 +1,158 @@
+// Copyright (c) 2014 Square, Inc
+
+package cpustat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	c := New(metrics.NewMetricContext("test"), time.Hour)
+	c.Collect()
+
+	// guest time is part of user and nice and not counted twice
+	o := c.All
+	if o.User.Get() != 1000 || o.Iowait.Get() != 50 || o.Steal.Get() != 15 ||
+		o.GuestLowPrio.Get() != 2 || o.Total.Get() != 9400 {
+		t.Errorf("unexpected totals user %d iowait %d steal %d guest_nice %d total %d",
+			o.User.Get(), o.Iowait.Get(), o.Steal.Get(), o.GuestLowPrio.Get(), o.Total.Get())
+	}
+	if c.Procs_running.Get() != 3 || c.Procs_blocked.Get() != 1 ||
+		c.Ctxt.Get() != 987654 || c.Intr.Get() != 123456 || c.Processes.Get() != 4321 {
+		t.Errorf("unexpected procs running %v blocked %v ctxt %d intr %d processes %d",
+			c.Procs_running.Get(), c.Procs_blocked.Get(),
+			c.Ctxt.Get(), c.Intr.Get(), c.Processes.Get())
+	}
+	cpu1 := c.PerCPUStat("cpu1")
+	if cpu1 == nil || cpu1.System.Get() != 100 {
+		t.Errorf("cpu1 not collected")
+	}
+	if cpus := c.CPUS(); len(cpus) != 2 || cpus[0] != "cpu0" || cpus[1] != "cpu1" {
+		t.Errorf("CPUS() = %q", cpus)
+	}
+}
+
+func TestImbalance(t *testing.T) {
+	tests := []struct {
+		usage []float64
+		want  float64
+	}{
+		{nil, 0},
+		{[]float64{100}, 0},
+		{[]float64{100, 2, 3, 1}, 0}, // one busy thread on an idle host
+		{[]float64{95, 30, 25, 35}, 65},
+		{[]float64{40, 40}, 0},
+	}
+	for _, tt := range tests {
+		if got := imbalance(tt.usage); got != tt.want {
+			t.Errorf("imbalance(%v) = %v, want %v", tt.usage, got, tt.want)
+		}
+	}
+}
+
+func TestCollectInterrupts(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	m := metrics.NewMetricContext("test")
+	c := New(m, time.Hour)
+	c.Collect()
+
+	cpu0, cpu1 := c.PerCPUStat("cpu0"), c.PerCPUStat("cpu1")
+	if cpu0.Interrupts.Get() != 91247 || cpu0.Softirqs.Get() != 1145630 {
+		t.Errorf("unexpected totals %d %d", cpu0.Interrupts.Get(), cpu0.Softirqs.Get())
+	}
+	// only the totals are registered
+	for name := range m.Counters {
+		if strings.Contains(name, ".irq.") || strings.Contains(name, ".softirq.") {
+			t.Errorf("per irq counter %s registered", name)
+		}
+	}
+	if _, ok := m.Counters["cpustat.cpu1.Interrupts"]; !ok {
+		t.Errorf("cpustat.cpu1.Interrupts not registered")
+	}
+	if cpu0.IRQs["24"].Get() != 1200 || cpu1.IRQs["25"].Get() != 5100 ||
+		cpu1.IRQs["LOC"].Get() != 85000 {
+		t.Errorf("unexpected interrupts %d %d %d", cpu0.IRQs["24"].Get(),
+			cpu1.IRQs["25"].Get(), cpu1.IRQs["LOC"].Get())
+	}
+	// rows with a single count for all CPUs are skipped
+	if _, ok := cpu0.IRQs["ERR"]; ok {
+		t.Errorf("ERR counted per CPU")
+	}
+	if c.IRQDevice("24") != "PCI-MSI 524288-edge eth0-rx-0" ||
+		c.IRQDevice("NMI") != "Non-maskable interrupts" {
+		t.Errorf("unexpected devices %q %q", c.IRQDevice("24"), c.IRQDevice("NMI"))
+	}
+	// no rates after a single collection
+	if irq, _ := cpu0.TopIRQ(); irq != "" {
+		t.Errorf("TopIRQ() = %q before rates are known", irq)
+	}
+	if cpu0.SoftIRQs["NET_RX"].Get() != 950000 || cpu1.SoftIRQs["NET_RX"].Get() != 120 {
+		t.Errorf("unexpected NET_RX softirqs %d %d",
+			cpu0.SoftIRQs["NET_RX"].Get(), cpu1.SoftIRQs["NET_RX"].Get())
+	}
+}
+
+func TestCollectFreq(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Sys: "testdata/sys"})
+
+	s := NewFreqStat(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	if len(s.CPUs) != 2 || !s.Available() {
+		t.Fatalf("got cpus %v, want cpu0 and cpu1", s.CPUs)
+	}
+	cpu0, cpu1 := s.PerCPU("cpu0"), s.PerCPU("cpu1")
+	// boost is on: relative to scaling_max_freq, not the turbo clock
+	if cpu0.Cur.Get() != 1200000 || cpu0.Max.Get() != 3600000 || cpu0.FreqPct() != 50 {
+		t.Errorf("unexpected cpu0 frequency %v of %v (%v%%)",
+			cpu0.Cur.Get(), cpu0.Nominal.Get(), cpu0.FreqPct())
+	}
+	if cpu0.Core_throttles.Get() != 17 || cpu0.Package_throttles.Get() != 42 {
+		t.Errorf("unexpected throttle counts %d %d",
+			cpu0.Core_throttles.Get(), cpu0.Package_throttles.Get())
+	}
+	// cpu1 reports its base frequency and no thermal_throttle
+	if cpu1.Cur.Get() != 3400000 || cpu1.FreqPct() != 170 || cpu1.Core_throttles.Get() != 0 {
+		t.Errorf("unexpected cpu1 frequency %v (%v%%) throttles %d",
+			cpu1.Cur.Get(), cpu1.FreqPct(), cpu1.Core_throttles.Get())
+	}
+
+	zones := s.ThermalZones()
+	z0, z1 := zones["thermal_zone0"], zones["thermal_zone1"]
+	if len(zones) != 2 || z0.Type != "x86_pkg_temp" || z0.Temp.Get() != 71 ||
+		z1.Temp.Get() != -5.5 {
+		t.Errorf("unexpected thermal zones %v", zones)
+	}
+}
+
+func TestCgroupCollectUnified(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mtab", Sys: "testdata/sys"})
+
+	c := NewCgroupStat(metrics.NewMetricContext("test"), time.Hour)
+	if !c.Unified {
+		t.Fatalf("cgroup v2 not detected")
+	}
+	c.Collect()
+
+	// cgroups without processes are skipped
+	if len(c.Cgroups) != 1 {
+		t.Fatalf("got cgroups %v, want web", c.Cgroups)
+	}
+	cg := c.Cgroups[c.Mountpoint+"/web"]
+	o := cg.Metrics
+	if o.Usage.Get() != 5000000000 || o.Nr_throttled.Get() != 10 || o.Throttled_time.Get() != 200000000 {
+		t.Errorf("unexpected cpu.stat usage %d throttled %d/%d",
+			o.Usage.Get(), o.Nr_throttled.Get(), o.Throttled_time.Get())
+	}
+	if cg.Quota() != 1.5 {
+		t.Errorf("Quota() = %v, want 1.5", cg.Quota())
+	}
+}
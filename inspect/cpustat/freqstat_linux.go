@@ -0,0 +1,198 @@
+// Copyright (c) 2014 Square, Inc
+
+package cpustat
+
+import (
+	"errors"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FreqStat collects per CPU clock frequencies and thermal throttling
+// events from sysfs, and the temperature of thermal zones. Virtual
+// machines usually expose neither.
+type FreqStat struct {
+	CPUs     map[string]*FreqStatPerCPU // by cpu ("cpu0")
+	Zones    map[string]*ThermalZone    // by zone ("thermal_zone0")
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	mu       sync.RWMutex // guards CPUs and Zones
+}
+
+// frequencies are kHz; throttle counts are events since boot
+type FreqStatPerCPU struct {
+	Cur               *metrics.Gauge // scaling_cur_freq
+	Min               *metrics.Gauge // cpuinfo_min_freq
+	Max               *metrics.Gauge // cpuinfo_max_freq
+	Scaling_min       *metrics.Gauge // limits set by the governor
+	Scaling_max       *metrics.Gauge
+	Base              *metrics.Gauge   // base_frequency (intel_pstate, amd-pstate)
+	Nominal           *metrics.Gauge   // clock FreqPct is relative to
+	Core_throttles    *metrics.Counter // core above its thermal limit
+	Package_throttles *metrics.Counter // package above its thermal limit
+	freq              bool
+}
+
+type ThermalZone struct {
+	Type string         // x86_pkg_temp, acpitz, ...
+	Temp *metrics.Gauge // degrees Celsius
+}
+
+func NewFreqStat(m *metrics.MetricContext, Step time.Duration) *FreqStat {
+	s := new(FreqStat)
+	s.m = m
+	s.CPUs = make(map[string]*FreqStatPerCPU)
+	s.Zones = make(map[string]*ThermalZone)
+	s.Schedule = misc.NewSchedule("freqstat", Step, s.Collect)
+	return s
+}
+
+func (s *FreqStat) Collect() {
+	dirs, _ := filepath.Glob(misc.SysPath("devices", "system", "cpu", "cpu[0-9]*"))
+	if len(dirs) == 0 {
+		misc.CollectorFailed("freqstat", errors.New("no cpus in sysfs"))
+		return
+	}
+	// with turbo boost on, cpuinfo_max_freq is the turbo clock
+	boost, _ := readUint(misc.SysPath("devices", "system", "cpu", "cpufreq", "boost"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dir := range dirs {
+		cpu := filepath.Base(dir)
+		o, ok := s.CPUs[cpu]
+		if !ok {
+			o = new(FreqStatPerCPU)
+			misc.InitializeMetrics(o, s.m, "cpustat."+cpu+".freq", true)
+			s.CPUs[cpu] = o
+		}
+		o.collect(dir, boost == 1)
+	}
+
+	zones, _ := filepath.Glob(misc.SysPath("class", "thermal", "thermal_zone[0-9]*"))
+	for _, dir := range zones {
+		dat, err := ioutil.ReadFile(filepath.Join(dir, "temp"))
+		if err != nil {
+			continue
+		}
+		// millidegrees, may be negative
+		t, err := strconv.ParseInt(strings.TrimSpace(string(dat)), 10, 64)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(dir)
+		z, ok := s.Zones[name]
+		if !ok {
+			typ, _ := ioutil.ReadFile(filepath.Join(dir, "type"))
+			z = &ThermalZone{Type: strings.TrimSpace(string(typ)), Temp: metrics.NewGauge()}
+			s.m.Register(z.Temp, "thermal."+name+".Temp")
+			s.Zones[name] = z
+		}
+		z.Temp.Set(float64(t) / 1000)
+	}
+	misc.CollectorSucceeded("freqstat")
+}
+
+// Available returns whether cpufreq reports frequencies
+func (s *FreqStat) Available() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, o := range s.CPUs {
+		if o.freq {
+			return true
+		}
+	}
+	return false
+}
+
+// PerCPU returns the stats of cpu ("cpu0"); nil if it has none
+func (s *FreqStat) PerCPU(cpu string) *FreqStatPerCPU {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.CPUs[cpu]
+}
+
+// ThermalZones returns a copy of the thermal zones by name
+func (s *FreqStat) ThermalZones() map[string]*ThermalZone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]*ThermalZone, len(s.Zones))
+	for name, z := range s.Zones {
+		ret[name] = z
+	}
+	return ret
+}
+
+// FreqPct returns the current frequency as percentage of the base
+// frequency of the CPU, which turbo boost may exceed; NaN without
+// cpufreq
+func (o *FreqStatPerCPU) FreqPct() float64 {
+	return o.Cur.Get() / o.Nominal.Get() * 100
+}
+
+// Throttles returns thermal throttling events per second of the
+// core and its package
+func (o *FreqStatPerCPU) Throttles() float64 {
+	return sumRates(map[string]*metrics.Counter{
+		"core": o.Core_throttles, "package": o.Package_throttles,
+	})
+}
+
+// Unexported functions
+
+// collect reads cpufreq/ and thermal_throttle/ of dir, the sysfs
+// directory of a CPU
+func (o *FreqStatPerCPU) collect(dir string, boost bool) {
+	gauges := map[string]*metrics.Gauge{
+		"scaling_cur_freq": o.Cur,
+		"cpuinfo_min_freq": o.Min,
+		"cpuinfo_max_freq": o.Max,
+		"scaling_min_freq": o.Scaling_min,
+		"scaling_max_freq": o.Scaling_max,
+		"base_frequency":   o.Base,
+	}
+	for name, g := range gauges {
+		if v, ok := readUint(filepath.Join(dir, "cpufreq", name)); ok {
+			g.Set(float64(v))
+			o.freq = true
+		}
+	}
+
+	// prefer the base frequency where the driver reports it; with
+	// boost on cpuinfo_max_freq is the turbo clock, so fall back to
+	// the governor's limit
+	switch {
+	case !math.IsNaN(o.Base.Get()):
+		o.Nominal.Set(o.Base.Get())
+	case boost && !math.IsNaN(o.Scaling_max.Get()):
+		o.Nominal.Set(o.Scaling_max.Get())
+	default:
+		o.Nominal.Set(o.Max.Get())
+	}
+
+	counters := map[string]*metrics.Counter{
+		"core_throttle_count":    o.Core_throttles,
+		"package_throttle_count": o.Package_throttles,
+	}
+	for name, c := range counters {
+		if v, ok := readUint(filepath.Join(dir, "thermal_throttle", name)); ok {
+			c.Set(v)
+		}
+	}
+}
+
+func readUint(path string) (uint64, bool) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(dat)), 10, 64)
+	return v, err == nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2014 Square, Inc
+
+package cpustat
+
+import (
+	"bufio"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"math"
+	"os"
+	"strings"
+)
+
+// InterruptRate returns interrupts per second serviced by this CPU
+func (o *CPUStatPerCPU) InterruptRate() float64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return sumRates(o.IRQs)
+}
+
+// TopIRQ returns the interrupt this CPU services most often and its
+// rate per second; "" before rates are known
+func (o *CPUStatPerCPU) TopIRQ() (string, float64) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	var top string
+	var max float64
+	for irq, c := range o.IRQs {
+		if r := c.ComputeRate(); r > max {
+			top, max = irq, r
+		}
+	}
+	return top, max
+}
+
+// SoftIRQRate returns softirqs of kind name (NET_RX, TIMER, ...)
+// run per second on this CPU; all kinds if name is empty
+func (o *CPUStatPerCPU) SoftIRQRate(name string) float64 {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if name == "" {
+		return sumRates(o.SoftIRQs)
+	}
+	c, ok := o.SoftIRQs[name]
+	if !ok {
+		return math.NaN()
+	}
+	return c.ComputeRate()
+}
+
+// Unexported functions
+
+// collectInterrupts parses /proc/interrupts and /proc/softirqs into
+// per CPU counters:
+//
+//	           CPU0       CPU1
+//	 24:        123          0  IO-APIC   5-edge      ACPI:Ged
+//	NMI:          0          0   Non-maskable interrupts
+//	ERR:          0
+func (s *CPUStat) collectInterrupts(path string, softirqs bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return
+	}
+	var cpus []*CPUStatPerCPU
+	for _, name := range strings.Fields(scanner.Text()) {
+		cpus = append(cpus, s.perCPU(strings.ToLower(name)))
+	}
+
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		irq := strings.TrimSuffix(f[0], ":")
+
+		// rows such as ERR have a single count for all CPUs
+		n := 0
+		for n < len(cpus) && n+1 < len(f) && isCount(f[n+1]) {
+			n++
+		}
+		if n != len(cpus) {
+			continue
+		}
+		if !softirqs {
+			s.mu.Lock()
+			s.IRQDevices[irq] = strings.Join(f[n+1:], " ")
+			s.mu.Unlock()
+		}
+		for i, o := range cpus {
+			o.irqCounter(irq, softirqs).Set(misc.ParseUint(f[i+1]))
+		}
+	}
+	for _, o := range cpus {
+		o.setTotals(softirqs)
+	}
+}
+
+// perCPU returns the stats of cpu ("cpu0"), creating them if needed
+func (s *CPUStat) perCPU(cpu string) *CPUStatPerCPU {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.cpus[cpu]
+	if !ok {
+		o = NewCPUStatPerCPU(s.m, cpu)
+		s.cpus[cpu] = o
+	}
+	return o
+}
+
+// irqCounter returns the counter of irq on this CPU. There are
+// hundreds per CPU on large hosts so they aren't registered; only
+// their totals and the busiest irq are.
+func (o *CPUStatPerCPU) irqCounter(irq string, softirq bool) *metrics.Counter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	counters := o.IRQs
+	if softirq {
+		counters = o.SoftIRQs
+	}
+	c, ok := counters[irq]
+	if !ok {
+		c = metrics.NewCounter()
+		counters[irq] = c
+	}
+	return c
+}
+
+// setTotals sets the registered totals from the per irq counters
+func (o *CPUStatPerCPU) setTotals(softirq bool) {
+	o.mu.RLock()
+	irqs, softirqs := sumCounts(o.IRQs), sumCounts(o.SoftIRQs)
+	o.mu.RUnlock()
+	if softirq {
+		o.Softirqs.Set(softirqs)
+		return
+	}
+	o.Interrupts.Set(irqs)
+	_, rate := o.TopIRQ()
+	o.Top_irq.Set(rate)
+}
+
+func sumCounts(counters map[string]*metrics.Counter) uint64 {
+	var sum uint64
+	for _, c := range counters {
+		sum += c.Get()
+	}
+	return sum
+}
+
+func sumRates(counters map[string]*metrics.Counter) float64 {
+	var sum float64
+	for _, c := range counters {
+		// counters of interrupts seen the first time have no rate
+		if r := c.ComputeRate(); !math.IsNaN(r) {
+			sum += r
+		}
+	}
+	return sum
+}
+
+func isCount(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2014 Square, Inc
+
+package diskstat
+
+import (
+	"bufio"
+	"github.com/square/prodeng/inspect/cgroup"
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CgroupStat collects block IO per cgroup from io.stat (cgroup v2)
+// or the blkio throttle counters (v1)
+type CgroupStat struct {
+	Cgroups    map[string]*PerCgroupStat // by path
+	Hierarchy  *cgroup.Hierarchy
+	m          *metrics.MetricContext
+	Mountpoint string
+	Unified    bool // cgroup v2
+	Schedule   *misc.Schedule
+	filter     atomic.Value // *config.Filter, replaced on reload
+	mu         sync.RWMutex // guards Cgroups
+}
+
+func NewCgroupStat(m *metrics.MetricContext, Step time.Duration) *CgroupStat {
+	c := new(CgroupStat)
+	c.m = m
+	c.Cgroups = make(map[string]*PerCgroupStat, 1)
+	c.filter.Store(&config.Default().Cgroups)
+
+	h, err := cgroup.Find("io")
+	if err != nil {
+		h, err = cgroup.Find("blkio")
+	}
+	if err != nil {
+		return c
+	}
+	c.Hierarchy = h
+	c.Mountpoint = h.Mountpoint
+	c.Unified = h.Unified
+
+	c.Schedule = misc.NewSchedule("cgroup_diskstat", Step, c.Collect)
+
+	return c
+}
+
+// SetFilter selects cgroups by name relative to the mountpoint;
+// cgroups no longer selected are dropped on the next collection
+func (c *CgroupStat) SetFilter(f *config.Filter) {
+	c.filter.Store(f)
+}
+
+// PerCgroup returns a copy of the cgroups by path
+func (c *CgroupStat) PerCgroup() map[string]*PerCgroupStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerCgroupStat, len(c.Cgroups))
+	for path, o := range c.Cgroups {
+		ret[path] = o
+	}
+	return ret
+}
+
+func (c *CgroupStat) Collect() {
+	filter := c.filter.Load().(*config.Filter)
+	if c.Hierarchy == nil {
+		return
+	}
+	cgroups, err := c.Hierarchy.Cgroups()
+	if err != nil {
+		misc.CollectorFailed("cgroup_diskstat", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(cgroups))
+	for _, cg := range cgroups {
+		if !filter.Match(cg.Name) {
+			continue
+		}
+		seen[cg.Path] = true
+
+		o, ok := c.Cgroups[cg.Path]
+		if !ok {
+			o = NewPerCgroupStat(c.m, cg)
+			c.mu.Lock()
+			c.Cgroups[cg.Path] = o
+			c.mu.Unlock()
+		}
+		o.Metrics.Collect()
+	}
+
+	// stop tracking cgroups which don't exist
+	// anymore, have no tasks or are excluded
+	c.mu.Lock()
+	for path, o := range c.Cgroups {
+		if !seen[path] {
+			misc.UnregisterMetrics(o.Metrics, c.m, o.Metrics.prefix)
+			delete(c.Cgroups, path)
+		}
+	}
+	c.mu.Unlock()
+	misc.CollectorSucceeded("cgroup_diskstat")
+}
+
+// Per Cgroup functions
+
+type PerCgroupStat struct {
+	Metrics *PerCgroupStatMetrics
+	Cgroup  *cgroup.Cgroup
+	m       *metrics.MetricContext
+}
+
+func NewPerCgroupStat(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStat {
+	c := new(PerCgroupStat)
+	c.m = m
+	c.Cgroup = cg
+	c.Metrics = NewPerCgroupStatMetrics(m, cg)
+	return c
+}
+
+// ReadBandwidth returns bytes read per second by the cgroup
+func (s *PerCgroupStat) ReadBandwidth() float64 {
+	return s.Metrics.ReadBytes.ComputeRate()
+}
+
+// WriteBandwidth returns bytes written per second by the cgroup
+func (s *PerCgroupStat) WriteBandwidth() float64 {
+	return s.Metrics.WriteBytes.ComputeRate()
+}
+
+// totals across all devices
+type PerCgroupStatMetrics struct {
+	ReadBytes  *metrics.Counter
+	WriteBytes *metrics.Counter
+	ReadIOs    *metrics.Counter
+	WriteIOs   *metrics.Counter
+	cgroup     *cgroup.Cgroup
+	prefix     string
+}
+
+func NewPerCgroupStatMetrics(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStatMetrics {
+	c := new(PerCgroupStatMetrics)
+	c.cgroup = cg
+
+	// initialize all metrics and register them
+	c.prefix = "diskstat.cgroup." + cg.Name
+	misc.InitializeMetrics(c, m, c.prefix, true)
+
+	return c
+}
+
+func (s *PerCgroupStatMetrics) Collect() {
+	if s.cgroup.Hierarchy.Unified {
+		s.collectUnified()
+		return
+	}
+
+	// "8:0 Read 4096" per device and operation, then "Total 4096"
+	read := func(file string, r, w *metrics.Counter) {
+		var rsum, wsum uint64
+		forEachLine(s.cgroup.File(file), func(f []string) {
+			if len(f) != 3 {
+				return
+			}
+			switch f[1] {
+			case "Read":
+				rsum += misc.ParseUint(f[2])
+			case "Write":
+				wsum += misc.ParseUint(f[2])
+			}
+		})
+		r.Set(rsum)
+		w.Set(wsum)
+	}
+	read("blkio.throttle.io_service_bytes", s.ReadBytes, s.WriteBytes)
+	read("blkio.throttle.io_serviced", s.ReadIOs, s.WriteIOs)
+}
+
+// Unexported functions
+
+// collectUnified sums up io.stat lines like
+// "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0"
+func (s *PerCgroupStatMetrics) collectUnified() {
+	sums := make(map[string]uint64, 4)
+	forEachLine(s.cgroup.File("io.stat"), func(f []string) {
+		for _, kv := range f[1:] {
+			i := strings.Index(kv, "=")
+			if i > 0 {
+				sums[kv[:i]] += misc.ParseUint(kv[i+1:])
+			}
+		}
+	})
+	s.ReadBytes.Set(sums["rbytes"])
+	s.WriteBytes.Set(sums["wbytes"])
+	s.ReadIOs.Set(sums["rios"])
+	s.WriteIOs.Set(sums["wios"])
+}
+
+func forEachLine(path string, fn func(f []string)) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+}
@@ -5,41 +5,60 @@ package diskstat
 import (
 	"bufio"
 	"fmt"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
 	"io/ioutil"
 	"os"
 	"path"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type DiskStat struct {
-	Disks   map[string]*PerDiskStat
-	m       *metrics.MetricContext
-	blkdevs map[string]bool
+	Disks    map[string]*PerDiskStat
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	blkdevs  map[string]bool
+	filter   atomic.Value // *config.DeviceFilter, replaced on reload
+	mu       sync.RWMutex // guards Disks
 }
 
 func New(m *metrics.MetricContext, Step time.Duration) *DiskStat {
 	s := new(DiskStat)
 	s.Disks = make(map[string]*PerDiskStat, 6)
 	s.m = m
+	s.filter.Store(&config.Default().Devices)
 	s.RefreshBlkDevList() // perhaps call this once in a while
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			s.Collect()
-		}
-	}()
+	s.Schedule = misc.NewSchedule("diskstat", Step, s.Collect)
 
 	return s
 }
 
+// SetFilter selects the block devices to collect; devices no longer
+// selected are dropped on the next collection
+func (s *DiskStat) SetFilter(f *config.DeviceFilter) {
+	s.filter.Store(f)
+}
+
+// PerDisk returns a copy of the disks by name
+func (s *DiskStat) PerDisk() map[string]*PerDiskStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]*PerDiskStat, len(s.Disks))
+	for name, o := range s.Disks {
+		ret[name] = o
+	}
+	return ret
+}
+
 func (s *DiskStat) RefreshBlkDevList() {
 	var blkdevs = make(map[string]bool)
 
 	// block devices
-	o, err := ioutil.ReadDir("/sys/block")
+	o, err := ioutil.ReadDir(misc.SysPath("block"))
 	if err == nil {
 		for _, d := range o {
 			blkdevs[path.Base(d.Name())] = true
@@ -50,15 +69,18 @@ func (s *DiskStat) RefreshBlkDevList() {
 }
 
 func (s *DiskStat) Collect() {
-	file, err := os.Open("/proc/diskstats")
+	filter := s.filter.Load().(*config.DeviceFilter)
+	file, err := os.Open(misc.ProcPath("diskstats"))
 	defer file.Close()
 	if err != nil {
+		misc.CollectorFailed("diskstat", err)
 		return
 	}
 
 	var blkdev string
 	var major, minor uint64
 	var f [11]uint64
+	seen := make(map[string]bool, len(s.Disks))
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
@@ -67,8 +89,8 @@ func (s *DiskStat) Collect() {
 			&major, &minor, &blkdev, &f[0], &f[1], &f[2], &f[3],
 			&f[4], &f[5], &f[6], &f[7], &f[8], &f[9], &f[10])
 
-		// skip loop/ram/dm drives
-		if major == 1 || major == 7 || major == 253 {
+		// skip loop/ram/dm drives and excluded devices
+		if !filter.Match(blkdev, major) {
 			continue
 		}
 
@@ -81,8 +103,11 @@ func (s *DiskStat) Collect() {
 		o, ok := s.Disks[blkdev]
 		if !ok {
 			o = NewPerDiskStat(s.m, blkdev)
+			s.mu.Lock()
 			s.Disks[blkdev] = o
+			s.mu.Unlock()
 		}
+		seen[blkdev] = true
 
 		d := o.Metrics
 		d.ReadCompleted.Set(f[0])
@@ -97,6 +122,16 @@ func (s *DiskStat) Collect() {
 		d.IOSpentMsecs.Set(f[9])
 		d.WeightedIOSpentMsecs.Set(f[10])
 	}
+
+	s.mu.Lock()
+	for blkdev, o := range s.Disks {
+		if !seen[blkdev] {
+			misc.UnregisterMetrics(o.Metrics, s.m, "diskstat."+blkdev)
+			delete(s.Disks, blkdev)
+		}
+	}
+	s.mu.Unlock()
+	misc.CollectorSucceeded("diskstat")
 }
 
 type PerDiskStat struct {
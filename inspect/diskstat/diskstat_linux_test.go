@@ -0,0 +1,50 @@
+// Copyright (c) 2014 Square, Inc
+
+package diskstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc", Sys: "testdata/sys"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	// loop devices and partitions are skipped
+	if len(s.Disks) != 1 {
+		t.Fatalf("got disks %v, want sda", s.Disks)
+	}
+	d := s.Disks["sda"].Metrics
+	if d.ReadCompleted.Get() != 1000 || d.WriteSectors.Get() != 40000 ||
+		d.IOInProgress.Get() != 2 || d.WeightedIOSpentMsecs.Get() != 7000 {
+		t.Errorf("unexpected sda metrics %+v", d)
+	}
+}
+
+func TestCgroupCollectUnified(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mtab", Sys: "testdata/sys"})
+
+	c := NewCgroupStat(metrics.NewMetricContext("test"), time.Hour)
+	if !c.Unified {
+		t.Fatalf("cgroup v2 not detected")
+	}
+	c.Collect()
+
+	cg, ok := c.Cgroups[c.Mountpoint+"/web"]
+	if len(c.Cgroups) != 1 || !ok {
+		t.Fatalf("got cgroups %v, want web", c.Cgroups)
+	}
+	// summed up across devices
+	o := cg.Metrics
+	if o.ReadBytes.Get() != 5120 || o.WriteBytes.Get() != 8192 ||
+		o.ReadIOs.Get() != 2 || o.WriteIOs.Get() != 2 {
+		t.Errorf("unexpected io.stat totals %d %d %d %d",
+			o.ReadBytes.Get(), o.WriteBytes.Get(), o.ReadIOs.Get(), o.WriteIOs.Get())
+	}
+}
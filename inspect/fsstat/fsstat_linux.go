@@ -5,66 +5,96 @@ package fsstat
 
 import (
 	"bufio"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 type FSStat struct {
-	FS map[string]*PerFSStat
-	m  *metrics.MetricContext
+	FS       map[string]*PerFSStat
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	filter   atomic.Value // *config.MountFilter, replaced on reload
+	mu       sync.RWMutex // guards FS
 }
 
 func New(m *metrics.MetricContext, Step time.Duration) *FSStat {
 	s := new(FSStat)
 	s.FS = make(map[string]*PerFSStat, 0)
 	s.m = m
+	s.filter.Store(&config.Default().Mounts)
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			s.Collect()
-		}
-	}()
+	s.Schedule = misc.NewSchedule("fsstat", Step, s.Collect)
 
 	return s
 }
 
+// SetFilter selects the mounts to collect; mounts no longer selected
+// are dropped on the next collection
+func (s *FSStat) SetFilter(f *config.MountFilter) {
+	s.filter.Store(f)
+}
+
+// PerFS returns a copy of the filesystems by mountpoint
+func (s *FSStat) PerFS() map[string]*PerFSStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]*PerFSStat, len(s.FS))
+	for mp, o := range s.FS {
+		ret[mp] = o
+	}
+	return ret
+}
+
 func (s *FSStat) Collect() {
-	file, err := os.Open("/etc/mtab")
+	filter := s.filter.Load().(*config.MountFilter)
+	file, err := os.Open(misc.MtabPath())
 	defer file.Close()
 	if err != nil {
+		misc.CollectorFailed("fsstat", err)
 		return
 	}
 
+	seen := make(map[string]bool, len(s.FS))
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		f := strings.Split(scanner.Text(), " ")
-
-		//TODO: XXX: exclusions should be configurable
-
-		// ignore few device types
-		if f[0] == "proc" || f[0] == "sysfs" || f[0] == "devpts" ||
-			f[0] == "none" || f[0] == "sunrpc" {
+		if len(f) < 4 {
 			continue
 		}
 
-		// ignore few types of mounts
-		// man fstab
-		if f[3] == "swap" || f[3] == "bind" || f[3] == "ignore" || f[3] == "none" {
+		// ignore pseudo filesystems, swap/bind mounts (man fstab)
+		// and excluded mountpoints
+		if !filter.Match(f[0], f[1], f[3]) {
 			continue
 		}
+		seen[f[1]] = true
 
 		o, ok := s.FS[f[1]]
 		if !ok {
 			o = NewPerFSStat(s.m, f[1])
+			s.mu.Lock()
 			s.FS[f[1]] = o
+			s.mu.Unlock()
 		}
 		o.Collect()
 	}
+
+	s.mu.Lock()
+	for mp, o := range s.FS {
+		if !seen[mp] {
+			misc.UnregisterMetrics(o.Metrics, s.m, "fsstat."+mp)
+			delete(s.FS, mp)
+		}
+	}
+	s.mu.Unlock()
+	misc.CollectorSucceeded("fsstat")
 }
 
 type PerFSStat struct {
@@ -95,7 +125,7 @@ func (s *PerFSStat) Collect() {
 
 	// call statfs and populate metrics
 	buf := new(syscall.Statfs_t)
-	err := syscall.Statfs(s.mp, buf)
+	err := syscall.Statfs(misc.HostPath(s.mp), buf)
 	if err != nil {
 		return
 	}
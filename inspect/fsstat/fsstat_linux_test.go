@@ -0,0 +1,27 @@
+// Copyright (c) 2014 Square, Inc
+
+package fsstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mtab"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	// proc and bind mounts are skipped
+	o, ok := s.FS["/"]
+	if len(s.FS) != 1 || !ok {
+		t.Fatalf("got filesystems %v, want /", s.FS)
+	}
+	if o.Metrics.Blocks.Get() == 0 {
+		t.Errorf("statfs of / not collected")
+	}
+}
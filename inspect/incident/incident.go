@@ -0,0 +1,308 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package incident attaches evidence to problems found by the rules
+// engine: for CPU problems the processes and cgroups using most
+// CPU, for disk IO the processes doing most IO and the busiest
+// device, for memory the processes whose RSS grew most.
+//
+// Evidence is gathered from the report at the time a problem starts
+// and kept in an incident record until some time after it resolves.
+package incident
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/rules"
+)
+
+// Kinds of incidents
+const (
+	KindCPU    = "cpu"
+	KindIO     = "io"
+	KindMemory = "memory"
+	KindOther  = "other"
+)
+
+// Tracker turns problems into incidents
+type Tracker struct {
+	TopN         int // culprits listed per incident
+	Window       int // samples of process memory kept to find growers
+	MaxIncidents int // resolved incidents kept
+	incidents    []*report.Incident
+	open         map[string]*report.Incident // keyed by rule name + value name
+	history      []map[string]float64        // pid -> rss, oldest first
+	mu           sync.RWMutex
+}
+
+func NewTracker() *Tracker {
+	t := new(Tracker)
+	t.TopN = 5
+	t.Window = 30
+	t.MaxIncidents = 100
+	t.open = make(map[string]*report.Incident)
+	return t
+}
+
+// Update records process memory of r and opens incidents for new
+// problems, with evidence from r. Incidents of problems no longer
+// present are resolved.
+func (t *Tracker) Update(problems []*rules.Problem, r *report.Report, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rss := make(map[string]float64, len(r.Processes))
+	for _, p := range r.Processes {
+		rss[p.Pid] = p.Mem
+	}
+	t.history = append(t.history, rss)
+	if len(t.history) > t.Window {
+		t.history = t.history[len(t.history)-t.Window:]
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range problems {
+		key := p.Rule + "\x00" + p.Name
+		seen[key] = true
+		if i, ok := t.open[key]; ok {
+			i.Problem = p
+			continue
+		}
+		i := Attribute(p, r, t.growth(), t.TopN, now)
+		t.open[key] = i
+		t.incidents = append(t.incidents, i)
+	}
+
+	for key, i := range t.open {
+		if !seen[key] {
+			resolved := now
+			i.Resolved = &resolved
+			delete(t.open, key)
+		}
+	}
+
+	// drop the oldest resolved incidents
+	for len(t.incidents) > t.MaxIncidents+len(t.open) {
+		for n, i := range t.incidents {
+			if i.Resolved != nil {
+				t.incidents = append(t.incidents[:n], t.incidents[n+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Incidents returns open and recently resolved incidents, newest
+// first
+func (t *Tracker) Incidents() []*report.Incident {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ret := make([]*report.Incident, 0, len(t.incidents))
+	for n := len(t.incidents) - 1; n >= 0; n-- {
+		c := *t.incidents[n]
+		ret = append(ret, &c)
+	}
+	return ret
+}
+
+// Open returns incidents whose problem is still present, newest first
+func (t *Tracker) Open() []*report.Incident {
+	ret := make([]*report.Incident, 0)
+	for _, i := range t.Incidents() {
+		if i.Resolved == nil {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// Kind classifies a problem by the name of the value it is about
+func Kind(name string) string {
+	switch {
+	case strings.HasPrefix(name, "cpu."),
+		strings.HasPrefix(name, "cpustat."),
+		strings.HasPrefix(name, "psi.cpu."),
+		strings.HasPrefix(name, "load."),
+		strings.HasPrefix(name, "cgroup.") && strings.Contains(name, ".cpu_"):
+		return KindCPU
+	case strings.HasPrefix(name, "disk."),
+		strings.HasPrefix(name, "diskstat."),
+		strings.HasPrefix(name, "psi.io."),
+		strings.HasPrefix(name, "cgroup.") && strings.Contains(name, ".io_"):
+		return KindIO
+	case strings.HasPrefix(name, "mem."),
+		strings.HasPrefix(name, "memstat."),
+		strings.HasPrefix(name, "psi.memory."),
+		strings.HasPrefix(name, "vm."),
+		strings.HasPrefix(name, "vmstat."),
+		strings.HasPrefix(name, "cgroup.") && strings.Contains(name, ".mem_"):
+		return KindMemory
+	}
+	return KindOther
+}
+
+// Attribute builds an incident for p with evidence from r. growth
+// maps pids to RSS growth in bytes; without it memory incidents
+// list the largest processes instead.
+func Attribute(p *rules.Problem, r *report.Report, growth map[string]float64,
+	n int, now time.Time) *report.Incident {
+
+	i := &report.Incident{
+		Problem: p,
+		Kind:    Kind(p.Name),
+		Started: now,
+	}
+	summary := fmt.Sprintf("%s at %s.", p.Message, now.UTC().Format("15:04 MST"))
+
+	// problems about a cgroup only consider its processes
+	procs := r.Processes
+	if cgroup := cgroupName(p.Name); cgroup != "" {
+		procs = nil
+		for _, x := range r.Processes {
+			if strings.Trim(x.Cgroup, "/") == cgroup {
+				procs = append(procs, x)
+			}
+		}
+	}
+
+	e := new(report.Evidence)
+	switch i.Kind {
+	case KindCPU:
+		e.Metric = "cpu"
+		e.Processes = culprits(procs, func(x *report.Process) float64 { return x.CPU }, n)
+		e.Cgroups = cgroupCulprits(r.Cgroups, func(c *report.Cgroup) float64 { return c.CPUUsage }, n)
+		summary += " Top users: " + describe(e.Processes, func(v float64) string {
+			return fmt.Sprintf("%.1f%%", v)
+		})
+
+	case KindIO:
+		e.Metric = "io"
+		e.Processes = culprits(procs, func(x *report.Process) float64 { return x.IO }, n)
+		e.Device = busiestDisk(r.Disks, p.Name)
+		if e.Device != nil {
+			summary += fmt.Sprintf(" Device %s is %.1f%% busy.", e.Device.Name, e.Device.Usage)
+		}
+		summary += " Top IO: " + describe(e.Processes, func(v float64) string {
+			return misc.ByteSize(v).String() + "/s"
+		})
+
+	case KindMemory:
+		if len(growth) > 0 {
+			e.Metric = "rss_growth"
+			e.Processes = culprits(procs, func(x *report.Process) float64 { return growth[x.Pid] }, n)
+			summary += " Top RSS growers: " + describe(e.Processes, func(v float64) string {
+				return "+" + misc.ByteSize(v).String()
+			})
+		} else {
+			e.Metric = "rss"
+			e.Processes = culprits(procs, func(x *report.Process) float64 { return x.Mem }, n)
+			summary += " Top RSS: " + describe(e.Processes, func(v float64) string {
+				return misc.ByteSize(v).String()
+			})
+		}
+		e.Cgroups = cgroupCulprits(r.Cgroups, func(c *report.Cgroup) float64 { return c.MemUsage }, n)
+
+	default:
+		e = nil
+	}
+
+	i.Summary = summary
+	i.Evidence = e
+	return i
+}
+
+// Unexported functions
+
+// growth returns RSS growth per pid over the history window.
+// Callers must hold t.mu.
+func (t *Tracker) growth() map[string]float64 {
+	if len(t.history) < 2 {
+		return nil
+	}
+	first, last := t.history[0], t.history[len(t.history)-1]
+	ret := make(map[string]float64)
+	for pid, v := range last {
+		if o, ok := first[pid]; ok {
+			ret[pid] = v - o
+		}
+	}
+	return ret
+}
+
+// culprits returns up to n processes with the highest positive value
+func culprits(procs []*report.Process, value func(*report.Process) float64, n int) []*report.Culprit {
+	var ret []*report.Culprit
+	for _, p := range procs {
+		if v := value(p); v > 0 {
+			ret = append(ret, &report.Culprit{
+				Name:  strings.Trim(p.Comm, "()"),
+				Pid:   p.Pid,
+				User:  p.User,
+				Value: v,
+			})
+		}
+	}
+	return top(ret, n)
+}
+
+func cgroupCulprits(cgroups []*report.Cgroup, value func(*report.Cgroup) float64, n int) []*report.Culprit {
+	var ret []*report.Culprit
+	for _, c := range cgroups {
+		if v := value(c); v > 0 {
+			ret = append(ret, &report.Culprit{Name: c.Name, Value: v})
+		}
+	}
+	return top(ret, n)
+}
+
+func top(c []*report.Culprit, n int) []*report.Culprit {
+	sort.SliceStable(c, func(i, j int) bool { return c[i].Value > c[j].Value })
+	if len(c) > n {
+		c = c[:n]
+	}
+	return c
+}
+
+// describe formats culprits as "perl (65.7%), fio (14.9%)"
+func describe(c []*report.Culprit, format func(float64) string) string {
+	if len(c) == 0 {
+		return "none found"
+	}
+	var s []string
+	for _, x := range c {
+		s = append(s, fmt.Sprintf("%s (%s)", x.Name, format(x.Value)))
+	}
+	return strings.Join(s, ", ")
+}
+
+// busiestDisk returns the disk a problem is about ("disk.sdb.usage")
+// or else the busiest one
+func busiestDisk(disks []*report.Disk, name string) *report.Disk {
+	var ret *report.Disk
+	for _, d := range disks {
+		if name == "disk."+d.Name+".usage" || strings.HasPrefix(name, "diskstat."+d.Name+".") {
+			return d
+		}
+		if ret == nil || d.Usage > ret.Usage {
+			ret = d
+		}
+	}
+	return ret
+}
+
+// cgroupName returns the cgroup of "cgroup.<name>.<value>"
+func cgroupName(name string) string {
+	if !strings.HasPrefix(name, "cgroup.") {
+		return ""
+	}
+	name = strings.TrimPrefix(name, "cgroup.")
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2014 Square, Inc
+
+package incident
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/rules"
+)
+
+func TestKind(t *testing.T) {
+	for name, want := range map[string]string{
+		"cpu.usage":                 KindCPU,
+		"cgroup.web.cpu_throttle":   KindCPU,
+		"psi.cpu.some":              KindCPU,
+		"load.per_cpu":              KindCPU,
+		"disk.sdb.usage":            KindIO,
+		"psi.io.full":               KindIO,
+		"cgroup.web.io_pressure":    KindIO,
+		"mem.usage_pct":             KindMemory,
+		"psi.memory.full":           KindMemory,
+		"vm.swap_in":                KindMemory,
+		"cgroup.web.mem_usage_pct":  KindMemory,
+		"fs./var.usage":             KindOther,
+		"interfacestat.eth0.TXerrs": KindOther,
+	} {
+		if got := Kind(name); got != want {
+			t.Errorf("Kind(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func testReport() *report.Report {
+	return &report.Report{
+		Processes: []*report.Process{
+			{Pid: "1", Comm: "(perl)", CPU: 65.7, Mem: 100, IO: 10, Cgroup: "/"},
+			{Pid: "2", Comm: "(fio)", CPU: 14.9, Mem: 200, IO: 5000, Cgroup: "/batch"},
+			{Pid: "3", Comm: "(idle)", Cgroup: "/"},
+		},
+		Disks: []*report.Disk{
+			{Name: "sda", Usage: 10},
+			{Name: "sdb", Usage: 92.7},
+		},
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	now := time.Date(2014, 6, 1, 15, 4, 0, 0, time.UTC)
+	r := testReport()
+
+	p := &rules.Problem{Name: "cpu.usage", Message: "CPU usage 95%"}
+	i := Attribute(p, r, nil, 5, now)
+	if i.Kind != KindCPU || len(i.Evidence.Processes) != 2 {
+		t.Fatalf("unexpected incident %+v", i)
+	}
+	want := "CPU usage 95% at 15:04 UTC. Top users: perl (65.7%), fio (14.9%)"
+	if i.Summary != want {
+		t.Errorf("got %q, want %q", i.Summary, want)
+	}
+
+	p = &rules.Problem{Name: "disk.sda.usage", Message: "disk busy"}
+	i = Attribute(p, r, nil, 1, now)
+	if i.Evidence.Device.Name != "sda" || i.Evidence.Processes[0].Name != "fio" {
+		t.Errorf("unexpected evidence %+v", i.Evidence)
+	}
+
+	p = &rules.Problem{Name: "cgroup.batch.cpu_usage", Message: "batch busy"}
+	i = Attribute(p, r, nil, 5, now)
+	if len(i.Evidence.Processes) != 1 || i.Evidence.Processes[0].Pid != "2" {
+		t.Errorf("cgroup problem not limited to cgroup: %+v", i.Evidence.Processes)
+	}
+
+	p = &rules.Problem{Name: "fs./.usage", Message: "disk full"}
+	if i = Attribute(p, r, nil, 5, now); i.Evidence != nil {
+		t.Errorf("unexpected evidence for %s", p.Name)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	now := time.Now()
+	r := testReport()
+	p := &rules.Problem{Rule: "mem", Name: "mem.usage_pct", Message: "memory low"}
+
+	tr.Update(nil, r, now)
+	r.Processes[0].Mem = 1000
+	tr.Update([]*rules.Problem{p}, r, now.Add(time.Second))
+	tr.Update([]*rules.Problem{p}, r, now.Add(2*time.Second))
+
+	open := tr.Open()
+	if len(open) != 1 {
+		t.Fatalf("got %d open incidents, want 1", len(open))
+	}
+	e := open[0].Evidence
+	if e.Metric != "rss_growth" || e.Processes[0].Name != "perl" || e.Processes[0].Value != 900 {
+		t.Errorf("unexpected evidence %+v", e.Processes[0])
+	}
+	if !strings.Contains(open[0].Summary, "Top RSS growers: perl") {
+		t.Errorf("unexpected summary %q", open[0].Summary)
+	}
+
+	tr.Update(nil, r, now.Add(3*time.Second))
+	if len(tr.Open()) != 0 {
+		t.Errorf("incident not resolved")
+	}
+	all := tr.Incidents()
+	if len(all) != 1 || all[0].Resolved == nil {
+		t.Errorf("resolved incident not kept: %+v", all)
+	}
+}
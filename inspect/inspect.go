@@ -4,44 +4,186 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/mgutz/ansi"
+	"github.com/square/prodeng/inspect/check"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/cpustat"
+	"github.com/square/prodeng/inspect/incident"
 	"github.com/square/prodeng/inspect/memstat"
 	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/notify"
 	"github.com/square/prodeng/inspect/osmain"
 	"github.com/square/prodeng/inspect/pidstat"
+	"github.com/square/prodeng/inspect/plugin"
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/rules"
+	"github.com/square/prodeng/inspect/tui"
 	"github.com/square/prodeng/metrics"
 	"log"
 	"net/http"
+	"os"
 	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 )
 
-const DISPLAY_PID_COUNT = 5
+// a local inspect server is used instead of collecting if it
+// answers within DETECT_TIMEOUT
+const (
+	DEFAULT_SERVER = "localhost:19999"
+	DETECT_TIMEOUT = 500 * time.Millisecond
+)
+
+// collectors are unhealthy if they have not succeeded within
+// HEALTH_MAX_AGE plus two of their intervals; pidstat takes a
+// second per 1024 processes
+const HEALTH_MAX_AGE = 30 * time.Second
+
+// checks available with -check; thresholds can be overridden
+// with -warn/-crit
+var checks = []*check.Check{
+	{Name: "cpu", Select: "cpu.usage", Warn: 80, Crit: 90, Unit: "%"},
+	{Name: "mem", Select: "mem.usage_pct", Warn: 80, Crit: 90, Unit: "%"},
+	{Name: "diskio", Select: "disk.*.usage", Warn: 75, Crit: 90, Unit: "%"},
+	{Name: "fs", Select: "fs.*.usage", Warn: 80, Crit: 90, Unit: "%"},
+	{Name: "cgroup_throttle", Select: "cgroup.*.cpu_throttle", Warn: 5, Crit: 20, Unit: "%"},
+}
 
 func main() {
 	// options
-	var batchmode, servermode bool
-	var address string
-	var stepSec int
+	var batchmode, servermode, tuimode, once bool
+	var address, rulesFile, comm, format, configFile, pluginDir string
+	var checkNames, warn, crit string
+	var connect string
+	var local bool
+	var webhooks, notifyExec string
+	var notifyRate int
+	var stepSec, pid int
 
 	flag.BoolVar(&batchmode, "b", false, "Run in batch mode; suitable for parsing")
 	flag.BoolVar(&batchmode, "batchmode", false, "Run in batch mode; suitable for parsing")
 	flag.BoolVar(&servermode, "server", false,
 		"Runs continously and exposes metrics as JSON on HTTP")
+	flag.BoolVar(&tuimode, "tui", false,
+		"Run interactive top-like terminal UI")
+	flag.BoolVar(&once, "once", false,
+		"Gather two samples, print one report in -format and exit")
+	flag.StringVar(&format, "format", "",
+		"report format for -once: json or yaml (default: from config, json)")
+	flag.StringVar(&checkNames, "check", "",
+		"Run NRPE style checks (comma separated: "+
+			strings.Join(check.Names(checks), ", ")+") and exit with status")
+	flag.StringVar(&warn, "warn", "", "warning threshold for -check (default: per check)")
+	flag.StringVar(&crit, "crit", "", "critical threshold for -check (default: per check)")
+	flag.StringVar(&connect, "connect", "",
+		"host:port of an inspect server to read from instead of collecting locally")
+	flag.BoolVar(&local, "local", false,
+		"Always collect locally; don't use an inspect server on "+DEFAULT_SERVER)
+	flag.StringVar(&webhooks, "notify-webhook", "",
+		"comma separated URLs to POST problem notifications to in server mode")
+	flag.StringVar(&notifyExec, "notify-exec", "",
+		"command to run for problem notifications in server mode")
+	flag.IntVar(&notifyRate, "notify-rate", 10,
+		"maximum number of notifications sent per minute")
 	flag.StringVar(&address, "address", ":19999",
 		"address to listen on for http if running in server mode")
 	flag.IntVar(&stepSec, "step", 2,
 		"metrics are collected every step seconds")
+	flag.IntVar(&pid, "pid", 0,
+		"Show details of a single process instead of top processes")
+	flag.StringVar(&comm, "comm", "",
+		"Like -pid but looks up the process by command name")
+	flag.StringVar(&configFile, "config", "",
+		"YAML/JSON configuration file; reloaded on SIGHUP")
+	flag.StringVar(&pluginDir, "plugins", "",
+		"Directory of executable plugins (overrides plugins.dir of -config)")
+	flag.StringVar(&rulesFile, "rules", "",
+		"YAML/JSON file with rules for problem detection (default: built-in rules)")
 	flag.Parse()
 
-	if servermode {
+	loader, err := config.NewLoader(configFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cfg := loader.Config()
+
+	// read the host's proc and sys from a container; paths can't
+	// change once collectors run
+	misc.SetFS(cfg.Paths)
+
+	var selected []*check.Check
+	if checkNames != "" {
+		selected, err = check.Select(checks, checkNames, warn, crit)
+		if err != nil {
+			r := check.Unknown("INSPECT", err)
+			fmt.Println(r.Output)
+			os.Exit(r.Status)
+		}
+		// checks are on system wide values which aren't collected
+		// when following a process
+		if pid != 0 || comm != "" {
+			r := check.Unknown("INSPECT", errors.New("-check can't be combined with -pid or -comm"))
+			fmt.Println(r.Output)
+			os.Exit(r.Status)
+		}
+	}
+
+	ruleset := rules.Default()
+	if rulesFile != "" {
+		ruleset, err = rules.Load(rulesFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	engine := rules.NewEngine(ruleset)
+
+	if format == "" {
+		format = cfg.Output.Format
+	}
+	if format != report.FormatJSON && format != report.FormatYAML {
+		fmt.Println("unknown format:", format)
+		os.Exit(1)
+	}
+
+	if servermode || once || checkNames != "" {
 		batchmode = true
 	}
 
+	// Default step for collectors; -step overrides the config file
+	step := cfg.StepDuration()
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "step" {
+			step = time.Millisecond * time.Duration(stepSec) * 1000
+		}
+	})
+
+	// number of processes shown and color follow config reloads
+	top := func() int { return loader.Config().Output.Top }
+	color := func() bool { return !batchmode && loader.Config().Output.ColorEnabled() }
+
+	// render from a running server if there is one; saves
+	// rescanning /proc on every invocation
+	if connect == "" && !local && !servermode && !once &&
+		checkNames == "" && pid == 0 && comm == "" {
+		c := report.NewClient(DEFAULT_SERVER)
+		c.HTTPClient.Timeout = DETECT_TIMEOUT
+		if _, err := c.Fetch(); err == nil {
+			connect = DEFAULT_SERVER
+		}
+	}
+
+	if connect != "" {
+		runClient(connect, step, tuimode, batchmode, top, color)
+		return
+	}
+
 	if !batchmode {
 		fmt.Println("Gathering statistics......")
 	}
@@ -49,28 +191,78 @@ func main() {
 	// Initialize a metric context
 	m := metrics.NewMetricContext("system")
 
-	// Default step for collectors
-	step := time.Millisecond * time.Duration(stepSec) * 1000
-
 	// Collect cpu/memory/disk/per-pid metrics
 	cstat := cpustat.New(m, step)
 	mstat := memstat.New(m, step)
+
+	// follow a single process; no top-N overview
+	if pid != 0 || comm != "" {
+		osind := new(osmain.OsIndependentStats)
+		osind.Cstat = cstat
+		osind.Mstat = mstat
+		osmain.ConfigureOsIndependent(osind, cfg)
+		loader.OnReload = append(loader.OnReload, func(c *config.Config) {
+			osmain.ConfigureOsIndependent(osind, c)
+		})
+		loader.WatchSignals()
+
+		p := ""
+		if pid != 0 {
+			p = strconv.Itoa(pid)
+		}
+		pd := osmain.RegisterProcessDetail(m, step, osind, p, comm)
+
+		if servermode {
+			go func() {
+				http.HandleFunc("/metrics.json", m.HttpJsonHandler)
+				log.Fatal(http.ListenAndServe(address, nil))
+			}()
+		}
+
+		// like -once for the whole system: two samples, one report
+		if once {
+			time.Sleep(step*2 + time.Second)
+			osmain.PrintProcessDetail(pd, batchmode)
+			return
+		}
+
+		ticker := time.NewTicker(step * 2)
+		for _ = range ticker.C {
+			if !batchmode {
+				fmt.Printf("\033[2J") // clear screen
+				fmt.Printf("\033[H")  // move cursor top left top
+			}
+			osmain.PrintProcessDetail(pd, batchmode)
+		}
+	}
+
 	procs := pidstat.NewProcessStat(m, step)
 
-	// Filter processes which have < 1% CPU or < 1% memory
-	// and try to keep minimum of 5
+	// Filter processes by command name and those which have
+	// < min_cpu% CPU or < min_mem% memory (1% by default) and
+	// try to keep a minimum of min_count (5). The interactive UI
+	// and one-shot reports keep all processes so they can be
+	// sorted by cpu, memory and io.
 
 	procs.SetPidFilter(pidstat.PidFilterFunc(func(p *pidstat.PerProcessStat) bool {
+		f := &loader.Config().Processes
+		if !f.Empty() && !f.Match(strings.Trim(p.Comm(), "()")) {
+			return false
+		}
+
+		if tuimode || once {
+			return true
+		}
 
-		if len(procs.Processes) < DISPLAY_PID_COUNT {
+		if len(procs.PerProcess()) < f.MinCount {
 			return true
 		}
 
-		if p.CPUUsage() > 1.0 {
+		if p.CPUUsage() > f.MinCPU {
 			return true
 		}
 		memUsagePct := (p.MemUsage() / mstat.Total()) * 100.0
-		if memUsagePct > 1.0 {
+		if memUsagePct > f.MinMem {
 			return true
 		}
 		return false
@@ -89,86 +281,193 @@ func main() {
 	// platforms yet
 	d := osmain.RegisterOsDependent(m, step, osind)
 
+	// external collectors; their metrics are registered in m
+	pluginConfig := func(c *config.Config) *config.Plugins {
+		p := c.Plugins
+		if pluginDir != "" {
+			p.Dir = pluginDir
+		}
+		return &p
+	}
+	plugins := plugin.New(m, pluginConfig(cfg))
+
+	// apply the configuration now and whenever it is reloaded
+	osmain.ConfigureOsIndependent(osind, cfg)
+	osmain.ConfigureOsDependent(d, cfg)
+	loader.OnReload = append(loader.OnReload, func(c *config.Config) {
+		osmain.ConfigureOsIndependent(osind, c)
+		osmain.ConfigureOsDependent(d, c)
+		plugins.Configure(pluginConfig(c))
+	})
+	loader.WatchSignals()
+
+	values := func() map[string]float64 {
+		v := make(map[string]float64)
+		rules.MetricValues(m, v)
+		osmain.OsIndependentValues(osind, v)
+		osmain.OsDependentValues(d, v)
+		return v
+	}
+
+	evaluate := func(t time.Time) {
+		engine.Evaluate(values(), t)
+	}
+
+	// problems are recorded as incidents with their likely cause
+	tracker := incident.NewTracker()
+
+	buildReport := func() *report.Report {
+		r := new(report.Report)
+		osmain.OsIndependentReport(osind, r)
+		osmain.OsDependentReport(d, r)
+		r.Plugins = plugins.Report()
+		r.Problems = engine.Problems()
+		r.Incidents = tracker.Open()
+		return r
+	}
+
+	// gather two samples so rates can be computed, then print
+	// a single report. pidstat needs an extra second as it samples
+	// every process twice a second apart.
+	if once {
+		time.Sleep(step*2 + time.Second)
+		t := time.Now()
+		evaluate(t)
+		r := buildReport()
+		tracker.Update(r.Problems, r, t)
+		r.Incidents = tracker.Open()
+		r.SetTop(top())
+		r.Processes = nil
+		out, err := report.Marshal(r, format)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	if checkNames != "" {
+		time.Sleep(step*2 + time.Second)
+		r := check.Run("INSPECT", selected, values())
+		fmt.Println(r.Output)
+		os.Exit(r.Status)
+	}
+
+	// notify about problems starting and resolving
+	var notifier *notify.Notifier
+	if servermode && (webhooks != "" || notifyExec != "") {
+		var sinks []notify.Sink
+		for _, url := range strings.Split(webhooks, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				sinks = append(sinks, notify.NewWebhook(url))
+			}
+		}
+		if notifyExec != "" {
+			sinks = append(sinks, notify.NewCommand(notifyExec))
+		}
+		notifier = notify.New(m, step, sinks...)
+		notifier.MaxPerMinute = notifyRate
+	}
+
+	topConsumers := func() *report.Top {
+		r := buildReport()
+		r.SetTop(top())
+		return r.Top
+	}
+
+	// evaluate rules every step
+	go func() {
+		ticker := time.NewTicker(step)
+		for t := range ticker.C {
+			evaluate(t)
+			tracker.Update(engine.Problems(), buildReport(), t)
+			if notifier != nil {
+				notifier.Update(engine.Problems(), topConsumers, t)
+			}
+		}
+	}()
+
 	// run http server
 	if servermode {
 		go func() {
 			http.HandleFunc("/metrics.json", m.HttpJsonHandler)
+			http.HandleFunc("/report.json", report.HttpHandler(buildReport))
+			http.HandleFunc("/problems.json",
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(engine.Problems())
+				})
+			http.HandleFunc("/healthz", misc.HealthHandler(HEALTH_MAX_AGE))
+			http.HandleFunc("/incidents.json",
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(tracker.Incidents())
+				})
 			log.Fatal(http.ListenAndServe(address, nil))
 		}()
 	}
 
+	if tuimode {
+		err := tui.Run(buildReport, step)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// command line refresh every 2 step
 	ticker := time.NewTicker(step * 2)
 	for _ = range ticker.C {
 
-		// Problems
-		var problems []string
-
 		if !batchmode {
 			fmt.Printf("\033[2J") // clear screen
 			fmt.Printf("\033[H")  // move cursor top left top
 		}
 
-		fmt.Println("--------------------------")
-		mem_pct_usage := (mstat.Usage() / mstat.Total()) * 100
-		fmt.Printf(
-			"total: cpu: %3.1f%%, mem: %3.1f%% (%s/%s)\n",
-			cstat.Usage(), mem_pct_usage,
-			misc.ByteSize(mstat.Usage()), misc.ByteSize(mstat.Total()))
-
-		if cstat.Usage() > 80.0 {
-			problems = append(problems, "CPU usage > 80%")
-		}
-
-		if mem_pct_usage > 80.0 {
-			problems = append(problems, "Memory usage > 80%")
-		}
+		report.Print(os.Stdout, buildReport(), top(), color())
 
-		// Top processes by usage
-		procs_by_usage := procs.ByCPUUsage()
-		fmt.Println("Top processes by CPU usage:")
-		n := DISPLAY_PID_COUNT
-		if len(procs_by_usage) < n {
-			n = len(procs_by_usage)
-		}
+		// be aggressive about reclaiming memory
+		// tradeoff with CPU usage
+		runtime.GC()
+		debug.FreeOSMemory()
+	}
+}
 
-		for i := 0; i < n; i++ {
-			fmt.Printf("cpu: %3.1f%%  command: %s user: %s pid: %v\n",
-				procs_by_usage[i].CPUUsage(),
-				procs_by_usage[i].Comm(),
-				procs_by_usage[i].User(),
-				procs_by_usage[i].Pid())
-		}
+// runClient renders reports of the inspect server at address
+func runClient(address string, step time.Duration, tuimode, batchmode bool,
+	top func() int, color func() bool) {
+	c := report.NewClient(address)
 
-		fmt.Println("---")
-		procs_by_usage = procs.ByMemUsage()
-		fmt.Println("Top processes by Mem usage:")
-		n = DISPLAY_PID_COUNT
-		if len(procs_by_usage) < n {
-			n = len(procs_by_usage)
+	if tuimode {
+		err := tui.Run(func() *report.Report {
+			r, err := c.Fetch()
+			if err != nil {
+				return nil
+			}
+			return r
+		}, step)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
 		}
+		return
+	}
 
-		for i := 0; i < n; i++ {
-			fmt.Printf("mem: %s command: %s user: %s pid: %v\n",
-				misc.ByteSize(procs_by_usage[i].MemUsage()),
-				procs_by_usage[i].Comm(),
-				procs_by_usage[i].User(),
-				procs_by_usage[i].Pid())
+	ticker := time.NewTicker(step * 2)
+	for {
+		if !batchmode {
+			fmt.Printf("\033[2J") // clear screen
+			fmt.Printf("\033[H")  // move cursor top left top
 		}
 
-		osmain.PrintOsDependent(d, batchmode)
-
-		for i := range problems {
-			msg := problems[i]
-			if !batchmode {
-				msg = ansi.Color(msg, "red")
-			}
-			fmt.Println("Problem: ", msg)
+		r, err := c.Fetch()
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			report.Print(os.Stdout, r, top(), color())
 		}
-
-		// be aggressive about reclaiming memory
-		// tradeoff with CPU usage
-		runtime.GC()
-		debug.FreeOSMemory()
+		<-ticker.C
 	}
 }
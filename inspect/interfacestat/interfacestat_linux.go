@@ -5,44 +5,66 @@ package interfacestat
 import (
 	"bufio"
 	"fmt"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type InterfaceStat struct {
 	Interfaces map[string]*PerInterfaceStat
+	Schedule   *misc.Schedule
 	m          *metrics.MetricContext
+	filter     atomic.Value // *config.Filter, replaced on reload
+	mu         sync.RWMutex // guards Interfaces
 }
 
 func New(m *metrics.MetricContext, Step time.Duration) *InterfaceStat {
 	s := new(InterfaceStat)
 	s.Interfaces = make(map[string]*PerInterfaceStat, 4)
 	s.m = m
+	s.filter.Store(&config.Default().Interfaces)
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			s.Collect()
-		}
-	}()
+	s.Schedule = misc.NewSchedule("interfacestat", Step, s.Collect)
 
 	return s
 }
 
+// SetFilter selects the interfaces to collect; interfaces no longer
+// selected are dropped on the next collection
+func (s *InterfaceStat) SetFilter(f *config.Filter) {
+	s.filter.Store(f)
+}
+
+// PerInterface returns a copy of the interfaces by name
+func (s *InterfaceStat) PerInterface() map[string]*PerInterfaceStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ret := make(map[string]*PerInterfaceStat, len(s.Interfaces))
+	for name, o := range s.Interfaces {
+		ret[name] = o
+	}
+	return ret
+}
+
 // Collect() collects interface metrics
 // TODO: perhaps use sysfs
 func (s *InterfaceStat) Collect() {
-	file, err := os.Open("/proc/net/dev")
+	filter := s.filter.Load().(*config.Filter)
+	file, err := os.Open(misc.ProcPath("net", "dev"))
 	defer file.Close()
 	if err != nil {
+		misc.CollectorFailed("interfacestat", err)
 		return
 	}
 
 	var rx [8]uint64
 	var tx [8]uint64
+	seen := make(map[string]bool, len(s.Interfaces))
 
 	scanner := bufio.NewScanner(file)
 	scanner.Scan()
@@ -52,6 +74,10 @@ func (s *InterfaceStat) Collect() {
 			continue
 		}
 		dev := strings.TrimSpace(f[0])
+		if !filter.Match(dev) {
+			continue
+		}
+		seen[dev] = true
 		rest := f[1]
 		fmt.Sscanf(rest,
 			"%d %d %d %d %d %d %d %d %d %d %d %d %d %d %d %d",
@@ -61,7 +87,9 @@ func (s *InterfaceStat) Collect() {
 		o, ok := s.Interfaces[dev]
 		if !ok {
 			o = NewPerInterfaceStat(s.m, dev)
+			s.mu.Lock()
 			s.Interfaces[dev] = o
+			s.mu.Unlock()
 		}
 
 		d := o.Metrics
@@ -81,11 +109,21 @@ func (s *InterfaceStat) Collect() {
 		d.TXframe.Set(tx[5])
 		d.TXcompressed.Set(tx[6])
 		d.TXmulticast.Set(tx[7])
-		speed := misc.ReadUintFromFile("/sys/class/net/" + dev + "/speed")
+		speed := misc.ReadUintFromFile(misc.SysPath("class", "net", dev, "speed"))
 		if speed > 0 {
 			d.Speed.Set(float64(speed))
 		}
 	}
+
+	s.mu.Lock()
+	for dev, o := range s.Interfaces {
+		if !seen[dev] {
+			misc.UnregisterMetrics(o.Metrics, s.m, "interfacestat."+dev)
+			delete(s.Interfaces, dev)
+		}
+	}
+	s.mu.Unlock()
+	misc.CollectorSucceeded("interfacestat")
 }
 
 type PerInterfaceStat struct {
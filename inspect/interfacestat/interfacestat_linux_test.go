@@ -0,0 +1,27 @@
+// Copyright (c) 2014 Square, Inc
+
+package interfacestat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc", Sys: "testdata/sys"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	if len(s.Interfaces) != 2 {
+		t.Fatalf("got interfaces %v, want lo and eth0", s.Interfaces)
+	}
+	d := s.Interfaces["eth0"].Metrics
+	if d.RXbytes.Get() != 1000000 || d.RXmulticast.Get() != 7 ||
+		d.TXpackets.Get() != 1500 || d.TXdrop.Get() != 2 || d.Speed.Get() != 1000 {
+		t.Errorf("unexpected eth0 metrics %+v", d)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package loadstat collects the load averages and task counts of
+// /proc/loadavg.
+package loadstat
+
+import (
+	"errors"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type LoadStat struct {
+	Metrics  *LoadStatMetrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+}
+
+type LoadStatMetrics struct {
+	Load1    *metrics.Gauge
+	Load5    *metrics.Gauge
+	Load15   *metrics.Gauge
+	Runnable *metrics.Gauge // runnable tasks
+	Tasks    *metrics.Gauge // all tasks
+	LastPid  *metrics.Gauge // most recently assigned pid
+}
+
+func New(m *metrics.MetricContext, Step time.Duration) *LoadStat {
+	s := new(LoadStat)
+	s.m = m
+	s.Metrics = new(LoadStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, "loadstat", true)
+	s.Schedule = misc.NewSchedule("loadstat", Step, s.Collect)
+	return s
+}
+
+// Collect parses /proc/loadavg: "0.20 0.18 0.12 1/80 11206"
+func (s *LoadStat) Collect() {
+	dat, err := ioutil.ReadFile(misc.ProcPath("loadavg"))
+	if err != nil {
+		misc.CollectorFailed("loadstat", err)
+		return
+	}
+	f := strings.Fields(string(dat))
+	if len(f) != 5 {
+		misc.CollectorFailed("loadstat", errors.New("malformed loadavg"))
+		return
+	}
+
+	o := s.Metrics
+	for i, g := range []*metrics.Gauge{o.Load1, o.Load5, o.Load15} {
+		if v, err := strconv.ParseFloat(f[i], 64); err == nil {
+			g.Set(v)
+		}
+	}
+	if i := strings.Index(f[3], "/"); i > 0 {
+		o.Runnable.Set(float64(misc.ParseUint(f[3][:i])))
+		o.Tasks.Set(float64(misc.ParseUint(f[3][i+1:])))
+	}
+	o.LastPid.Set(float64(misc.ParseUint(f[4])))
+	misc.CollectorSucceeded("loadstat")
+}
+
+// Load returns the 1, 5 and 15 minute load averages
+func (s *LoadStat) Load() (float64, float64, float64) {
+	o := s.Metrics
+	return o.Load1.Get(), o.Load5.Get(), o.Load15.Get()
+}
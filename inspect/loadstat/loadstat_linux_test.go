@@ -0,0 +1,27 @@
+// Copyright (c) 2014 Square, Inc
+
+package loadstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	if l1, l5, l15 := s.Load(); l1 != 2.5 || l5 != 1.75 || l15 != 0.9 {
+		t.Errorf("Load() = %v %v %v, want 2.5 1.75 0.9", l1, l5, l15)
+	}
+	o := s.Metrics
+	if o.Runnable.Get() != 3 || o.Tasks.Get() != 412 || o.LastPid.Get() != 11206 {
+		t.Errorf("unexpected tasks %v/%v last pid %v",
+			o.Runnable.Get(), o.Tasks.Get(), o.LastPid.Get())
+	}
+}
@@ -5,87 +5,123 @@ package memstat
 import (
 	"bufio"
 	"fmt"
+	"github.com/square/prodeng/inspect/cgroup"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
 	"math"
 	"os"
-	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type CgroupStat struct {
-	Cgroups    map[string]*PerCgroupStat
+	Cgroups    map[string]*PerCgroupStat // by path
+	Hierarchy  *cgroup.Hierarchy
 	m          *metrics.MetricContext
 	Mountpoint string
+	Unified    bool // cgroup v2
+	Schedule   *misc.Schedule
+	filter     atomic.Value // *config.Filter, replaced on reload
+	mu         sync.RWMutex // guards Cgroups
 }
 
 func NewCgroupStat(m *metrics.MetricContext, Step time.Duration) *CgroupStat {
 	c := new(CgroupStat)
 	c.m = m
 	c.Cgroups = make(map[string]*PerCgroupStat, 1)
+	c.filter.Store(&config.Default().Cgroups)
 
-	mountpoint, err := misc.FindCgroupMount("memory")
+	h, err := cgroup.Find("memory")
 	if err != nil {
 		return c
 	}
-	c.Mountpoint = mountpoint
+	c.Hierarchy = h
+	c.Mountpoint = h.Mountpoint
+	c.Unified = h.Unified
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect(mountpoint)
-		}
-	}()
+	c.Schedule = misc.NewSchedule("cgroup_memstat", Step, c.Collect)
 
 	return c
 }
 
-func (c *CgroupStat) Collect(mountpoint string) {
+// SetFilter selects cgroups by name relative to the mountpoint;
+// cgroups no longer selected are dropped on the next collection
+func (c *CgroupStat) SetFilter(f *config.Filter) {
+	c.filter.Store(f)
+}
+
+// PerCgroup returns a copy of the cgroups by path
+func (c *CgroupStat) PerCgroup() map[string]*PerCgroupStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerCgroupStat, len(c.Cgroups))
+	for path, o := range c.Cgroups {
+		ret[path] = o
+	}
+	return ret
+}
 
-	cgroups, err := misc.FindCgroups(mountpoint)
+func (c *CgroupStat) Collect() {
+	filter := c.filter.Load().(*config.Filter)
+	if c.Hierarchy == nil {
+		return
+	}
+	cgroups, err := c.Hierarchy.Cgroups()
 	if err != nil {
+		misc.CollectorFailed("cgroup_memstat", err)
 		return
 	}
 
-	// stop tracking cgroups which don't exist
-	// anymore or have no tasks
-	cgroupsMap := make(map[string]bool, len(cgroups))
-	for _, cgroup := range cgroups {
-		cgroupsMap[cgroup] = true
-	}
+	seen := make(map[string]bool, len(cgroups))
+	for _, cg := range cgroups {
+		if !filter.Match(cg.Name) {
+			continue
+		}
+		seen[cg.Path] = true
 
-	for cgroup, _ := range c.Cgroups {
-		_, ok := cgroupsMap[cgroup]
+		o, ok := c.Cgroups[cg.Path]
 		if !ok {
-			delete(c.Cgroups, cgroup)
+			o = NewPerCgroupStat(c.m, cg)
+			c.mu.Lock()
+			c.Cgroups[cg.Path] = o
+			c.mu.Unlock()
 		}
+		o.Metrics.Collect()
 	}
 
-	for _, cgroup := range cgroups {
-		_, ok := c.Cgroups[cgroup]
-		if !ok {
-			c.Cgroups[cgroup] = NewPerCgroupStat(c.m, cgroup, mountpoint)
+	// stop tracking cgroups which don't exist
+	// anymore, have no tasks or are excluded
+	c.mu.Lock()
+	for path, o := range c.Cgroups {
+		if !seen[path] {
+			misc.UnregisterMetrics(o.Metrics, c.m, o.Metrics.prefix)
+			delete(c.Cgroups, path)
 		}
-		c.Cgroups[cgroup].Metrics.Collect()
 	}
+	c.mu.Unlock()
 
+	misc.CollectorSucceeded("cgroup_memstat")
 }
 
 // Per Cgroup functions
 
 type PerCgroupStat struct {
 	Metrics *PerCgroupStatMetrics
+	Cgroup  *cgroup.Cgroup
 	m       *metrics.MetricContext
 }
 
-func NewPerCgroupStat(m *metrics.MetricContext, path string, mp string) *PerCgroupStat {
+func NewPerCgroupStat(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStat {
 	c := new(PerCgroupStat)
 	c.m = m
+	c.Cgroup = cg
 
-	c.Metrics = NewPerCgroupStatMetrics(m, path, mp)
+	c.Metrics = NewPerCgroupStatMetrics(m, cg)
 
 	return c
 }
@@ -105,9 +141,16 @@ func (s *PerCgroupStat) Usage() float64 {
 	return o.Rss.Get() + o.Mapped_file.Get()
 }
 
-// SoftLimit returns soft-limit for the cgroup
+// SoftLimit returns soft-limit for the cgroup. On cgroup v2 that is
+// memory.high, or memory.max if there is no high limit.
 func (s *PerCgroupStat) SoftLimit() float64 {
 	o := s.Metrics
+	if s.Cgroup.Hierarchy.Unified {
+		if o.High.Get() < cgroup.UNLIMITED {
+			return o.High.Get()
+		}
+		return o.Max.Get()
+	}
 	return o.Soft_Limit_In_Bytes.Get()
 }
 
@@ -139,28 +182,43 @@ type PerCgroupStatMetrics struct {
 	Total_unevictable         *metrics.Gauge
 	// memory.soft_limit_in_bytes
 	Soft_Limit_In_Bytes *metrics.Gauge
-	path                string
+	// memory.current, memory.max and memory.high (v2) or
+	// memory.usage_in_bytes and memory.limit_in_bytes (v1)
+	Current *metrics.Gauge
+	Max     *metrics.Gauge
+	High    *metrics.Gauge
+	cgroup  *cgroup.Cgroup
+	prefix  string
 }
 
 func NewPerCgroupStatMetrics(m *metrics.MetricContext,
-	path string, mp string) *PerCgroupStatMetrics {
+	cg *cgroup.Cgroup) *PerCgroupStatMetrics {
 
 	c := new(PerCgroupStatMetrics)
-	c.path = path
+	c.cgroup = cg
 
-	prefix, _ := filepath.Rel(mp, path)
 	// initialize all metrics and register them
-	misc.InitializeMetrics(c, m, "memstat.cgroup."+prefix, true)
+	c.prefix = "memstat.cgroup." + cg.Name
+	misc.InitializeMetrics(c, m, c.prefix, true)
 
 	return c
 }
 
 func (s *PerCgroupStatMetrics) Collect() {
-	file, err := os.Open(s.path + "/" + "memory.stat")
+	cg := s.cgroup
+	file, err := os.Open(cg.File("memory.stat"))
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	defer file.Close()
+
+	// v2 names the v1 rss, cache and mapped_file counters
+	// anon, file and file_mapped
+	aliases := map[string]string{}
+	if cg.Hierarchy.Unified {
+		aliases = map[string]string{"anon": "rss", "file": "cache", "file_mapped": "mapped_file"}
+	}
 
 	d := map[string]*metrics.Gauge{}
 	// Get all fields we care about
@@ -177,15 +235,25 @@ func (s *PerCgroupStatMetrics) Collect() {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		f := regexp.MustCompile("[\\s]+").Split(scanner.Text(), 2)
+		if name, ok := aliases[f[0]]; ok {
+			f[0] = name
+		}
 		g, ok := d[strings.ToLower(f[0])]
 		if ok {
 			parseCgroupMemLine(g, f)
 		}
 	}
 
-	s.Soft_Limit_In_Bytes.Set(
-		float64(misc.ReadUintFromFile(
-			s.path + "/" + "memory.soft_limit_in_bytes")))
+	l := cg.Limits()
+	s.Max.Set(l.MemoryMax)
+	if cg.Hierarchy.Unified {
+		s.Current.Set(float64(misc.ReadUintFromFile(cg.File("memory.current"))))
+		s.High.Set(l.MemorySoftMax)
+		return
+	}
+
+	s.Current.Set(float64(misc.ReadUintFromFile(cg.File("memory.usage_in_bytes"))))
+	s.Soft_Limit_In_Bytes.Set(l.MemorySoftMax)
 }
 
 // Unexported functions
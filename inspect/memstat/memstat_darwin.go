@@ -3,6 +3,7 @@
 package memstat
 
 import (
+	"errors"
 	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/metrics"
 	"time"
@@ -72,6 +73,7 @@ type MemStatMetrics struct {
 	Purgeable *metrics.Gauge
 	Total     *metrics.Gauge
 	Pagesize  C.vm_size_t
+	Schedule  *misc.Schedule
 }
 
 func MemStatMetricsNew(m *metrics.MetricContext, Step time.Duration) *MemStatMetrics {
@@ -84,12 +86,7 @@ func MemStatMetricsNew(m *metrics.MetricContext, Step time.Duration) *MemStatMet
 	C.host_page_size(C.host_t(host), &c.Pagesize)
 
 	// collect metrics every Step
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect()
-		}
-	}()
+	c.Schedule = misc.NewSchedule("memstat", Step, c.Collect)
 
 	return c
 }
@@ -104,6 +101,7 @@ func (s *MemStatMetrics) Collect() {
 		C.host_info_t(unsafe.Pointer(&meminfo)), &count)
 
 	if ret != C.KERN_SUCCESS {
+		misc.CollectorFailed("memstat", errors.New("host_statistics64 failed"))
 		return
 	}
 
@@ -114,4 +112,5 @@ func (s *MemStatMetrics) Collect() {
 	s.Purgeable.Set(float64(meminfo.purgeable_count) * float64(s.Pagesize))
 	s.Total.Set(float64(C.get_phys_memory()))
 
+	misc.CollectorSucceeded("memstat")
 }
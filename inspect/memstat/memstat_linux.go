@@ -87,6 +87,7 @@ type MemStatMetrics struct {
 	Hugepagesize      *metrics.Gauge
 	DirectMap4k       *metrics.Gauge
 	DirectMap2M       *metrics.Gauge
+	Schedule          *misc.Schedule
 }
 
 func MemStatMetricsNew(m *metrics.MetricContext, Step time.Duration) *MemStatMetrics {
@@ -99,19 +100,15 @@ func MemStatMetricsNew(m *metrics.MetricContext, Step time.Duration) *MemStatMet
 	c.Collect()
 
 	// collect metrics every Step
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect()
-		}
-	}()
+	c.Schedule = misc.NewSchedule("memstat", Step, c.Collect)
 
 	return c
 }
 
 func (s *MemStatMetrics) Collect() {
-	file, err := os.Open("/proc/meminfo")
+	file, err := os.Open(misc.ProcPath("meminfo"))
 	if err != nil {
+		misc.CollectorFailed("memstat", err)
 		return
 	}
 
@@ -134,6 +131,7 @@ func (s *MemStatMetrics) Collect() {
 			parseMemLine(g, f)
 		}
 	}
+	misc.CollectorSucceeded("memstat")
 }
 
 // Unexported functions
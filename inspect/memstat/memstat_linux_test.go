@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Square, Inc
+
+package memstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/cgroup"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+
+	if s.Total() != 8000000*1024 {
+		t.Errorf("Total() = %v", s.Total())
+	}
+	// free includes buffers, cache and reclaimable slab
+	if s.Free() != 4000000*1024 || s.Usage() != 4000000*1024 {
+		t.Errorf("Free() = %v, Usage() = %v", s.Free(), s.Usage())
+	}
+}
+
+func TestVMStatCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := NewVMStat(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	o := s.Metrics
+	if o.Pswpin.Get() != 100 || o.Pswpout.Get() != 250 ||
+		o.Pgmajfault.Get() != 321 || o.Oom_kill.Get() != 2 {
+		t.Errorf("unexpected swap %d/%d majfault %d oom_kill %d",
+			o.Pswpin.Get(), o.Pswpout.Get(), o.Pgmajfault.Get(), o.Oom_kill.Get())
+	}
+	// per zone counters are summed up, pgscan_direct_throttle isn't
+	// a zone
+	if o.Pgscan_kswapd.Get() != 200 || o.Pgsteal_kswapd.Get() != 100 ||
+		o.Pgscan_direct.Get() != 60 || o.Allocstall.Get() != 7 {
+		t.Errorf("unexpected reclaim pgscan %d/%d pgsteal %d allocstall %d",
+			o.Pgscan_kswapd.Get(), o.Pgscan_direct.Get(),
+			o.Pgsteal_kswapd.Get(), o.Allocstall.Get())
+	}
+}
+
+func TestCgroupCollectUnified(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mtab", Sys: "testdata/sys"})
+
+	c := NewCgroupStat(metrics.NewMetricContext("test"), time.Hour)
+	if !c.Unified {
+		t.Fatalf("cgroup v2 not detected")
+	}
+	c.Collect()
+
+	cg, ok := c.Cgroups[c.Mountpoint+"/web"]
+	if len(c.Cgroups) != 1 || !ok {
+		t.Fatalf("got cgroups %v, want web", c.Cgroups)
+	}
+	// anon and file_mapped count like v1 rss and mapped_file
+	if cg.Usage() != 115343360 || cg.Metrics.Current.Get() != 157286400 {
+		t.Errorf("Usage() = %v, current %v", cg.Usage(), cg.Metrics.Current.Get())
+	}
+	if cg.Metrics.Max.Get() != cgroup.UNLIMITED || cg.SoftLimit() != 524288000 {
+		t.Errorf("unexpected limits max %v soft %v", cg.Metrics.Max.Get(), cg.SoftLimit())
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2014 Square, Inc
+
+package memstat
+
+import (
+	"bufio"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// VMStat collects paging, reclaim and OOM event counters from
+// /proc/vmstat
+type VMStat struct {
+	Metrics  *VMStatMetrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+}
+
+// counters are named after their /proc/vmstat key; those kept per
+// zone by older kernels (pgscan_kswapd_normal, ...) are summed up
+type VMStatMetrics struct {
+	Pgpgin             *metrics.Counter // KiB paged in
+	Pgpgout            *metrics.Counter // KiB paged out
+	Pswpin             *metrics.Counter // pages swapped in
+	Pswpout            *metrics.Counter // pages swapped out
+	Pgfault            *metrics.Counter
+	Pgmajfault         *metrics.Counter
+	Pgscan_kswapd      *metrics.Counter
+	Pgscan_direct      *metrics.Counter
+	Pgsteal_kswapd     *metrics.Counter
+	Pgsteal_direct     *metrics.Counter
+	Allocstall         *metrics.Counter // allocations entering direct reclaim
+	Compact_stall      *metrics.Counter
+	Compact_fail       *metrics.Counter
+	Compact_success    *metrics.Counter
+	Thp_fault_alloc    *metrics.Counter
+	Thp_fault_fallback *metrics.Counter
+	Thp_collapse_alloc *metrics.Counter
+	Thp_split_page     *metrics.Counter
+	Oom_kill           *metrics.Counter // linux 4.13+
+}
+
+func NewVMStat(m *metrics.MetricContext, Step time.Duration) *VMStat {
+	s := new(VMStat)
+	s.m = m
+	s.Metrics = new(VMStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, "vmstat", true)
+	s.Schedule = misc.NewSchedule("vmstat", Step, s.Collect)
+	return s
+}
+
+func (s *VMStat) Collect() {
+	file, err := os.Open(misc.ProcPath("vmstat"))
+	if err != nil {
+		misc.CollectorFailed("vmstat", err)
+		return
+	}
+	defer file.Close()
+
+	d := map[string]*metrics.Counter{}
+	r := reflect.ValueOf(s.Metrics).Elem()
+	typeOfT := r.Type()
+	for i := 0; i < r.NumField(); i++ {
+		d[strings.ToLower(typeOfT.Field(i).Name)] = r.Field(i).Interface().(*metrics.Counter)
+	}
+
+	sums := make(map[string]uint64, len(d))
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) != 2 {
+			continue
+		}
+		key := f[0]
+		if _, ok := d[key]; !ok {
+			key = trimZone(key)
+		}
+		if _, ok := d[key]; ok {
+			sums[key] += misc.ParseUint(f[1])
+		}
+	}
+	for key, v := range sums {
+		d[key].Set(v)
+	}
+	misc.CollectorSucceeded("vmstat")
+}
+
+// SwapIn returns pages swapped in per second
+func (s *VMStat) SwapIn() float64 {
+	return s.Metrics.Pswpin.ComputeRate()
+}
+
+// SwapOut returns pages swapped out per second
+func (s *VMStat) SwapOut() float64 {
+	return s.Metrics.Pswpout.ComputeRate()
+}
+
+// MajorFaults returns page faults needing IO per second
+func (s *VMStat) MajorFaults() float64 {
+	return s.Metrics.Pgmajfault.ComputeRate()
+}
+
+// DirectReclaim returns pages scanned per second by allocating
+// tasks because kswapd couldn't keep up
+func (s *VMStat) DirectReclaim() float64 {
+	return s.Metrics.Pgscan_direct.ComputeRate()
+}
+
+// AllocStalls returns allocations per second that stalled in direct
+// reclaim
+func (s *VMStat) AllocStalls() float64 {
+	return s.Metrics.Allocstall.ComputeRate()
+}
+
+// CompactStalls returns allocations per second that stalled in
+// memory compaction
+func (s *VMStat) CompactStalls() float64 {
+	return s.Metrics.Compact_stall.ComputeRate()
+}
+
+// OOMKills returns processes killed by the OOM killer per second
+func (s *VMStat) OOMKills() float64 {
+	return s.Metrics.Oom_kill.ComputeRate()
+}
+
+// Unexported functions
+
+// trimZone strips the zone of per zone keys: pgsteal_direct_dma32
+// is counted as pgsteal_direct
+func trimZone(key string) string {
+	i := strings.LastIndex(key, "_")
+	if i < 0 {
+		return key
+	}
+	switch key[i+1:] {
+	case "dma", "dma32", "normal", "high", "movable", "device":
+		return key[:i]
+	}
+	return key
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2014 Square, Inc
+
+package misc
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// FS locates the kernel interfaces read by collectors. Moving it
+// lets inspect run in a container with the host's proc and sys
+// mounted elsewhere, and tests read captured fixture trees.
+type FS struct {
+	Proc string `yaml:"proc" json:"proc"` // procfs
+	Sys  string `yaml:"sys" json:"sys"`   // sysfs
+	Mtab string `yaml:"mtab" json:"mtab"` // mounted filesystems and cgroups
+	Root string `yaml:"root" json:"root"` // host's / for other mountpoints
+}
+
+// DefaultFS returns the paths of the local kernel
+func DefaultFS() FS {
+	return FS{Proc: "/proc", Sys: "/sys", Mtab: "/etc/mtab", Root: "/"}
+}
+
+var fs = struct {
+	sync.RWMutex
+	FS
+}{FS: DefaultFS()}
+
+// SetFS changes where collectors read from. Empty fields keep their
+// default. It should be called before collectors are created.
+func SetFS(f FS) {
+	d := DefaultFS()
+	if f.Proc == "" {
+		f.Proc = d.Proc
+	}
+	if f.Sys == "" {
+		f.Sys = d.Sys
+	}
+	if f.Mtab == "" {
+		f.Mtab = d.Mtab
+	}
+	if f.Root == "" {
+		f.Root = d.Root
+	}
+	fs.Lock()
+	fs.FS = f
+	fs.Unlock()
+}
+
+// SetTestFS points collectors at fixture trees until the test t
+// ends. The paths are global, so tests using it can't run in
+// parallel.
+func SetTestFS(t testing.TB, f FS) {
+	prev := CurrentFS()
+	SetFS(f)
+	t.Cleanup(func() { SetFS(prev) })
+}
+
+// CurrentFS returns the paths collectors read from
+func CurrentFS() FS {
+	fs.RLock()
+	defer fs.RUnlock()
+	return fs.FS
+}
+
+// ProcPath joins elem to the proc root: ProcPath("net", "dev")
+func ProcPath(elem ...string) string {
+	return filepath.Join(append([]string{CurrentFS().Proc}, elem...)...)
+}
+
+// SysPath joins elem to the sys root
+func SysPath(elem ...string) string {
+	return filepath.Join(append([]string{CurrentFS().Sys}, elem...)...)
+}
+
+// MtabPath returns the file listing mounted filesystems
+func MtabPath() string {
+	return CurrentFS().Mtab
+}
+
+// HostPath translates an absolute path as seen by the host, such as
+// a mountpoint, to where it can be read: paths below /proc and /sys
+// move with their roots, anything else is placed below Root.
+func HostPath(path string) string {
+	f := CurrentFS()
+	for _, m := range []struct{ dir, root string }{{"/proc", f.Proc}, {"/sys", f.Sys}} {
+		if path == m.dir || strings.HasPrefix(path, m.dir+"/") {
+			return filepath.Join(m.root, strings.TrimPrefix(path, m.dir))
+		}
+	}
+	return filepath.Join(f.Root, path)
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2014 Square, Inc
+
+package misc
+
+import (
+	"testing"
+)
+
+func TestFS(t *testing.T) {
+	SetTestFS(t, FS{Proc: "/host/proc", Root: "/host/root"})
+
+	if f := CurrentFS(); f.Sys != "/sys" || f.Mtab != "/etc/mtab" {
+		t.Errorf("empty paths should keep their default: %+v", f)
+	}
+	if p := ProcPath("net", "dev"); p != "/host/proc/net/dev" {
+		t.Errorf("ProcPath = %q", p)
+	}
+	for in, want := range map[string]string{
+		"/proc/1/mounts":        "/host/proc/1/mounts",
+		"/sys/fs/cgroup/memory": "/sys/fs/cgroup/memory",
+		"/processes":            "/host/root/processes",
+		"/":                     "/host/root",
+		"/var/lib":              "/host/root/var/lib",
+	} {
+		if got := HostPath(in); got != want {
+			t.Errorf("HostPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2014 Square, Inc
+
+package misc
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CollectorHealth is the outcome of the latest collections of
+// a collector
+type CollectorHealth struct {
+	LastSuccess time.Time     `json:"last_success"`
+	LastError   string        `json:"last_error,omitempty"`
+	LastFailure *time.Time    `json:"last_failure,omitempty"`
+	Disabled    bool          `json:"disabled,omitempty"`
+	Interval    time.Duration `json:"-"` // as scheduled; 0 if unknown
+}
+
+var health = struct {
+	sync.Mutex
+	collectors map[string]*CollectorHealth
+	intervals  map[string]time.Duration
+}{
+	collectors: make(map[string]*CollectorHealth),
+	intervals:  make(map[string]time.Duration),
+}
+
+// CollectorSucceeded records a successful collection by collector name
+func CollectorSucceeded(name string) {
+	health.Lock()
+	defer health.Unlock()
+	collectorHealth(name).LastSuccess = time.Now()
+}
+
+// CollectorFailed records a failed collection by collector name
+func CollectorFailed(name string, err error) {
+	health.Lock()
+	defer health.Unlock()
+	h := collectorHealth(name)
+	now := time.Now()
+	h.LastFailure = &now
+	h.LastError = err.Error()
+}
+
+// CollectorDisabled records whether a collector has been disabled;
+// disabled collectors count as healthy
+func CollectorDisabled(name string, disabled bool) {
+	health.Lock()
+	defer health.Unlock()
+	if _, ok := health.collectors[name]; !ok && !disabled {
+		return
+	}
+	collectorHealth(name).Disabled = disabled
+}
+
+// CollectorRemoved forgets a collector that no longer runs
+func CollectorRemoved(name string) {
+	health.Lock()
+	defer health.Unlock()
+	delete(health.collectors, name)
+	delete(health.intervals, name)
+}
+
+// Health returns a copy of the health of all collectors that have
+// run at least once
+func Health() map[string]CollectorHealth {
+	health.Lock()
+	defer health.Unlock()
+	ret := make(map[string]CollectorHealth, len(health.collectors))
+	for name, h := range health.collectors {
+		c := *h
+		c.Interval = health.intervals[name]
+		ret[name] = c
+	}
+	return ret
+}
+
+// HealthHandler serves the health of all collectors as JSON. The
+// status is 503 if a collector has not succeeded within maxAge plus
+// two of its intervals.
+func HealthHandler(maxAge time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type collector struct {
+			CollectorHealth
+			Age     float64 `json:"age"` // seconds since last success
+			Healthy bool    `json:"healthy"`
+		}
+		resp := struct {
+			Status     string                `json:"status"`
+			Collectors map[string]*collector `json:"collectors"`
+		}{Status: "ok", Collectors: make(map[string]*collector)}
+
+		now := time.Now()
+		for name, h := range Health() {
+			c := &collector{CollectorHealth: h}
+			c.Healthy = h.Disabled ||
+				!h.LastSuccess.IsZero() && now.Sub(h.LastSuccess) <= maxAge+2*h.Interval
+			if !h.LastSuccess.IsZero() {
+				c.Age = now.Sub(h.LastSuccess).Seconds()
+			}
+			if !c.Healthy {
+				resp.Status = "unhealthy"
+			}
+			resp.Collectors[name] = c
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Unexported functions
+
+func collectorInterval(name string, interval time.Duration) {
+	health.Lock()
+	defer health.Unlock()
+	health.intervals[name] = interval
+}
+
+// callers must hold health's lock
+func collectorHealth(name string) *CollectorHealth {
+	h, ok := health.collectors[name]
+	if !ok {
+		h = new(CollectorHealth)
+		health.collectors[name] = h
+	}
+	return h
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2014 Square, Inc
+
+package misc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	CollectorSucceeded("test_ok")
+	CollectorFailed("test_failing", errors.New("no such file"))
+
+	w := httptest.NewRecorder()
+	HealthHandler(time.Minute)(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	var resp struct {
+		Status     string
+		Collectors map[string]struct {
+			LastError string `json:"last_error"`
+			Healthy   bool
+		}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "unhealthy" || !resp.Collectors["test_ok"].Healthy {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if c := resp.Collectors["test_failing"]; c.Healthy || c.LastError != "no such file" {
+		t.Errorf("unexpected failing collector %+v", c)
+	}
+}
@@ -4,16 +4,14 @@ package misc
 
 import (
 	"bufio"
-	"errors"
+	"bytes"
 	"fmt"
 	"github.com/square/prodeng/metrics"
-	"io/ioutil"
+	"math"
 	"os"
-	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
-	"strings"
 )
 
 type Interface interface{}
@@ -26,6 +24,15 @@ func ParseUint(in string) uint64 {
 	return out
 }
 
+// Finite returns 0 for NaN/Inf so reports can always be serialized
+// and printed
+func Finite(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
 func ReadUintFromFile(path string) uint64 {
 	f, err := os.Open(path)
 	defer f.Close()
@@ -68,47 +75,42 @@ func InitializeMetrics(c Interface, m *metrics.MetricContext, prefix string, reg
 	return
 }
 
-// move these to cgroup library
-// discover where memory subsystem is mounted
-
-func FindCgroupMount(subsystem string) (string, error) {
-
-	file, err := os.Open("/proc/mounts")
-	if err != nil {
-		return "", err
-	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		f := regexp.MustCompile("[\\s]+").Split(scanner.Text(), 6)
-		if f[2] == "cgroup" {
-			for _, o := range strings.Split(f[3], ",") {
-				if o == subsystem {
-					return f[1], nil
-				}
-			}
+// UnregisterMetrics unregisters the metrics registered by
+// InitializeMetrics with the same prefix
+func UnregisterMetrics(c Interface, m *metrics.MetricContext, prefix string) {
+	s := reflect.ValueOf(c).Elem()
+	typeOfT := s.Type()
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		if f.Kind().String() != "ptr" {
+			continue
+		}
+		if f.Type().Elem() == reflect.TypeOf(metrics.Gauge{}) ||
+			f.Type().Elem() == reflect.TypeOf(metrics.Counter{}) {
+			m.Unregister(f.Interface(), prefix+"."+typeOfT.Field(i).Name)
 		}
 	}
-
-	return "", errors.New("no cgroup mount found")
 }
 
-func FindCgroups(mountpoint string) ([]string, error) {
-	cgroups := make([]string, 0, 128)
-
-	_ = filepath.Walk(
-		mountpoint,
-		func(path string, f os.FileInfo, _ error) error {
-			if f.IsDir() && path != mountpoint {
-				// skip cgroups with no tasks
-				dat, err := ioutil.ReadFile(path + "/" + "tasks")
-				if err == nil && len(dat) > 0 {
-					cgroups = append(cgroups, path)
-				}
-			}
-			return nil
-		})
-
-	return cgroups, nil
+// GlobToRegexp converts a metric name glob into an anchored regular
+// expression. '*' matches any run of characters (including '.' and
+// '/'), '?' matches a single character. Each wildcard is a capture
+// group so callers can recover the matched parts of a name.
+func GlobToRegexp(glob string) (*regexp.Regexp, error) {
+	var re bytes.Buffer
+	re.WriteString("^")
+	for _, c := range glob {
+		switch c {
+		case '*':
+			re.WriteString("(.*)")
+		case '?':
+			re.WriteString("(.)")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
 }
 
 type ByteSize float64
@@ -182,15 +184,3 @@ func (b BitSize) String() string {
 	}
 	return fmt.Sprintf("%.2fb", b)
 }
-
-const (
-	CGROUP_BLKIO uint8 = iota
-	CGROUP_CPU
-	CGROUP_CPUACCT
-	CGROUP_CPUSET
-	CGROUP_DEVICES
-	CGROUP_FREEZER
-	CGROUP_MEMORY
-	CGROUP_NET_CLS
-	CGROUP_NS
-)
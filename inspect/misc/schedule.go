@@ -0,0 +1,80 @@
+// Copyright (c) 2014 Square, Inc
+
+package misc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Schedule calls a collector's collect function every interval.
+// The interval can be changed and collection paused while it runs.
+// Methods of a nil Schedule (a collector that found nothing to
+// collect) do nothing.
+type Schedule struct {
+	Name   string // collector name used for health
+	ticker *time.Ticker
+	paused int32
+	done   chan bool
+}
+
+func NewSchedule(name string, interval time.Duration, collect func()) *Schedule {
+	s := new(Schedule)
+	s.Name = name
+	s.ticker = time.NewTicker(interval)
+	s.done = make(chan bool)
+	collectorInterval(name, interval)
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				if !s.Paused() {
+					collect()
+				}
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// SetInterval changes the interval; the next collection is one
+// interval from now
+func (s *Schedule) SetInterval(interval time.Duration) {
+	if s == nil {
+		return
+	}
+	s.ticker.Reset(interval)
+	collectorInterval(s.Name, interval)
+}
+
+// SetEnabled pauses or resumes collection
+func (s *Schedule) SetEnabled(enabled bool) {
+	if s == nil {
+		return
+	}
+	var paused int32
+	if !enabled {
+		paused = 1
+	}
+	atomic.StoreInt32(&s.paused, paused)
+	CollectorDisabled(s.Name, !enabled)
+}
+
+func (s *Schedule) Paused() bool {
+	if s == nil {
+		return false
+	}
+	return atomic.LoadInt32(&s.paused) != 0
+}
+
+// Stop ends collection for good
+func (s *Schedule) Stop() {
+	if s == nil {
+		return
+	}
+	s.ticker.Stop()
+	close(s.done)
+	CollectorRemoved(s.Name)
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package netstat collects TCP/IP stack statistics: protocol
+// counters of /proc/net/snmp and /proc/net/netstat, socket counts
+// and memory of /proc/net/sockstat(6) and the TCP limits they are
+// checked against.
+package netstat
+
+import (
+	"bufio"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type NetStat struct {
+	Metrics  *NetStatMetrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	counters map[string]*metrics.Counter
+	gauges   map[string]*metrics.Gauge
+}
+
+// metrics are named "<section>_<key>" after their source
+type NetStatMetrics struct {
+	// /proc/net/snmp
+	Tcp_ActiveOpens  *metrics.Counter
+	Tcp_PassiveOpens *metrics.Counter
+	Tcp_AttemptFails *metrics.Counter
+	Tcp_EstabResets  *metrics.Counter
+	Tcp_CurrEstab    *metrics.Gauge
+	Tcp_InSegs       *metrics.Counter
+	Tcp_OutSegs      *metrics.Counter
+	Tcp_RetransSegs  *metrics.Counter
+	Tcp_InErrs       *metrics.Counter
+	Tcp_OutRsts      *metrics.Counter
+	Udp_InDatagrams  *metrics.Counter
+	Udp_OutDatagrams *metrics.Counter
+	Udp_NoPorts      *metrics.Counter
+	Udp_InErrors     *metrics.Counter
+	Udp_RcvbufErrors *metrics.Counter
+	Udp_SndbufErrors *metrics.Counter
+
+	// /proc/net/netstat
+	TcpExt_ListenOverflows    *metrics.Counter // accept queue full
+	TcpExt_ListenDrops        *metrics.Counter
+	TcpExt_TCPAbortOnMemory   *metrics.Counter // connections reset for lack of memory
+	TcpExt_TCPMemoryPressures *metrics.Counter
+	TcpExt_TCPTimeouts        *metrics.Counter
+	TcpExt_TCPBacklogDrop     *metrics.Counter
+	TcpExt_PruneCalled        *metrics.Counter
+	TcpExt_SyncookiesSent     *metrics.Counter
+	TcpExt_TW                 *metrics.Counter // sockets leaving TIME_WAIT
+
+	// /proc/net/sockstat and sockstat6
+	TCP_inuse  *metrics.Gauge
+	TCP_orphan *metrics.Gauge
+	TCP_tw     *metrics.Gauge
+	TCP_alloc  *metrics.Gauge
+	TCP_mem    *metrics.Gauge // pages
+	UDP_inuse  *metrics.Gauge
+	UDP_mem    *metrics.Gauge // pages
+	TCP6_inuse *metrics.Gauge
+	UDP6_inuse *metrics.Gauge
+
+	// /proc/sys/net/ipv4
+	Sysctl_tcp_mem_min        *metrics.Gauge // pages
+	Sysctl_tcp_mem_pressure   *metrics.Gauge // pages
+	Sysctl_tcp_mem_max        *metrics.Gauge // pages
+	Sysctl_tcp_max_orphans    *metrics.Gauge
+	Sysctl_tcp_max_tw_buckets *metrics.Gauge
+}
+
+func New(m *metrics.MetricContext, Step time.Duration) *NetStat {
+	s := new(NetStat)
+	s.m = m
+	s.Metrics = new(NetStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, "netstat", true)
+
+	// metrics by name for parsing
+	s.counters = make(map[string]*metrics.Counter)
+	s.gauges = make(map[string]*metrics.Gauge)
+	r := reflect.ValueOf(s.Metrics).Elem()
+	typeOfT := r.Type()
+	for i := 0; i < r.NumField(); i++ {
+		switch v := r.Field(i).Interface().(type) {
+		case *metrics.Counter:
+			s.counters[typeOfT.Field(i).Name] = v
+		case *metrics.Gauge:
+			s.gauges[typeOfT.Field(i).Name] = v
+		}
+	}
+
+	s.Schedule = misc.NewSchedule("netstat", Step, s.Collect)
+	return s
+}
+
+func (s *NetStat) Collect() {
+	for _, file := range []string{"snmp", "netstat"} {
+		if err := s.collectSNMP(misc.ProcPath("net", file)); err != nil {
+			misc.CollectorFailed("netstat", err)
+			return
+		}
+	}
+	s.collectSockstat(misc.ProcPath("net", "sockstat"))
+	s.collectSockstat(misc.ProcPath("net", "sockstat6"))
+
+	// "$MIN $PRESSURE $MAX"
+	o := s.Metrics
+	dat, err := ioutil.ReadFile(misc.ProcPath("sys", "net", "ipv4", "tcp_mem"))
+	if err == nil {
+		if f := strings.Fields(string(dat)); len(f) == 3 {
+			o.Sysctl_tcp_mem_min.Set(float64(misc.ParseUint(f[0])))
+			o.Sysctl_tcp_mem_pressure.Set(float64(misc.ParseUint(f[1])))
+			o.Sysctl_tcp_mem_max.Set(float64(misc.ParseUint(f[2])))
+		}
+	}
+	o.Sysctl_tcp_max_orphans.Set(float64(misc.ReadUintFromFile(
+		misc.ProcPath("sys", "net", "ipv4", "tcp_max_orphans"))))
+	o.Sysctl_tcp_max_tw_buckets.Set(float64(misc.ReadUintFromFile(
+		misc.ProcPath("sys", "net", "ipv4", "tcp_max_tw_buckets"))))
+
+	misc.CollectorSucceeded("netstat")
+}
+
+// Retransmits returns retransmitted TCP segments as percentage of
+// segments sent
+func (s *NetStat) Retransmits() float64 {
+	o := s.Metrics
+	return (o.Tcp_RetransSegs.ComputeRate() / o.Tcp_OutSegs.ComputeRate()) * 100
+}
+
+// ListenOverflows returns connections per second dropped because
+// the accept queue of a listening socket was full
+func (s *NetStat) ListenOverflows() float64 {
+	return s.Metrics.TcpExt_ListenOverflows.ComputeRate()
+}
+
+// ListenDrops returns connections per second dropped by listening
+// sockets for any reason, including overflows
+func (s *NetStat) ListenDrops() float64 {
+	return s.Metrics.TcpExt_ListenDrops.ComputeRate()
+}
+
+// MemoryAborts returns TCP connections per second reset because the
+// stack ran out of memory
+func (s *NetStat) MemoryAborts() float64 {
+	return s.Metrics.TcpExt_TCPAbortOnMemory.ComputeRate()
+}
+
+// TCPMem returns bytes used by TCP sockets
+func (s *NetStat) TCPMem() float64 {
+	return s.Metrics.TCP_mem.Get() * float64(os.Getpagesize())
+}
+
+// TCPMemLimit returns the tcp_mem maximum in bytes
+func (s *NetStat) TCPMemLimit() float64 {
+	return s.Metrics.Sysctl_tcp_mem_max.Get() * float64(os.Getpagesize())
+}
+
+// TCPMemUsage returns memory used by TCP sockets as percentage of
+// the tcp_mem maximum; beyond it new sockets can't allocate buffers
+func (s *NetStat) TCPMemUsage() float64 {
+	o := s.Metrics
+	return (o.TCP_mem.Get() / o.Sysctl_tcp_mem_max.Get()) * 100
+}
+
+// TCPMemPressure returns whether TCP memory is beyond the pressure
+// threshold of tcp_mem, where the kernel starts shrinking buffers
+func (s *NetStat) TCPMemPressure() bool {
+	o := s.Metrics
+	return o.TCP_mem.Get() > o.Sysctl_tcp_mem_pressure.Get()
+}
+
+// Orphans returns TCP sockets not attached to a file descriptor
+func (s *NetStat) Orphans() float64 {
+	return s.Metrics.TCP_orphan.Get()
+}
+
+// OrphanUsage returns orphaned sockets as percentage of
+// tcp_max_orphans
+func (s *NetStat) OrphanUsage() float64 {
+	o := s.Metrics
+	return (o.TCP_orphan.Get() / o.Sysctl_tcp_max_orphans.Get()) * 100
+}
+
+// TimeWait returns TCP sockets in TIME_WAIT
+func (s *NetStat) TimeWait() float64 {
+	return s.Metrics.TCP_tw.Get()
+}
+
+// TimeWaitUsage returns sockets in TIME_WAIT as percentage of
+// tcp_max_tw_buckets
+func (s *NetStat) TimeWaitUsage() float64 {
+	o := s.Metrics
+	return (o.TCP_tw.Get() / o.Sysctl_tcp_max_tw_buckets.Get()) * 100
+}
+
+// Established returns TCP connections in ESTABLISHED or CLOSE_WAIT
+func (s *NetStat) Established() float64 {
+	return s.Metrics.Tcp_CurrEstab.Get()
+}
+
+// Unexported functions
+
+// collectSNMP parses pairs of header and value lines:
+//
+//	Tcp: RtoAlgorithm RtoMin RtoMax MaxConn ActiveOpens ...
+//	Tcp: 1 200 120000 -1 4113 ...
+func (s *NetStat) collectSNMP(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		keys := strings.Fields(scanner.Text())
+		if !scanner.Scan() {
+			break
+		}
+		values := strings.Fields(scanner.Text())
+		if len(keys) != len(values) || len(keys) == 0 || keys[0] != values[0] {
+			continue
+		}
+		section := strings.TrimSuffix(keys[0], ":")
+		for i := 1; i < len(keys); i++ {
+			s.set(section+"_"+keys[i], values[i])
+		}
+	}
+	return scanner.Err()
+}
+
+// collectSockstat parses lines of key value pairs:
+//
+//	TCP: inuse 4 orphan 0 tw 1 alloc 4 mem 0
+func (s *NetStat) collectSockstat(path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 3 {
+			continue
+		}
+		proto := strings.TrimSuffix(f[0], ":")
+		for i := 1; i+1 < len(f); i += 2 {
+			s.set(proto+"_"+f[i], f[i+1])
+		}
+	}
+}
+
+func (s *NetStat) set(name string, v string) {
+	if c, ok := s.counters[name]; ok {
+		c.Set(misc.ParseUint(v))
+	} else if g, ok := s.gauges[name]; ok {
+		g.Set(float64(misc.ParseUint(v)))
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2014 Square, Inc
+
+package netstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	o := s.Metrics
+	if o.Tcp_RetransSegs.Get() != 2500 || o.Tcp_OutSegs.Get() != 1000000 ||
+		s.Established() != 57 || o.Udp_RcvbufErrors.Get() != 5 {
+		t.Errorf("unexpected snmp retrans %d/%d estab %v rcvbuf errors %d",
+			o.Tcp_RetransSegs.Get(), o.Tcp_OutSegs.Get(), s.Established(),
+			o.Udp_RcvbufErrors.Get())
+	}
+	if o.TcpExt_ListenOverflows.Get() != 310 || o.TcpExt_ListenDrops.Get() != 320 ||
+		o.TcpExt_TCPAbortOnMemory.Get() != 9 {
+		t.Errorf("unexpected netstat overflows %d drops %d abort on memory %d",
+			o.TcpExt_ListenOverflows.Get(), o.TcpExt_ListenDrops.Get(),
+			o.TcpExt_TCPAbortOnMemory.Get())
+	}
+
+	// sockstat against the limits
+	if s.TCPMemUsage() != 90 || !s.TCPMemPressure() {
+		t.Errorf("TCPMemUsage() = %v, TCPMemPressure() = %v",
+			s.TCPMemUsage(), s.TCPMemPressure())
+	}
+	if s.Orphans() != 64 || s.OrphanUsage() != 0.78125 {
+		t.Errorf("Orphans() = %v, OrphanUsage() = %v", s.Orphans(), s.OrphanUsage())
+	}
+	if s.TimeWait() != 30000 || o.TCP6_inuse.Get() != 40 {
+		t.Errorf("TimeWait() = %v, TCP6 inuse %v", s.TimeWait(), o.TCP6_inuse.Get())
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package notify sends notifications when problems found by the
+// rules engine start and resolve.
+//
+// A notification is sent once per transition of a problem
+// (deduplication), at most MaxPerMinute notifications go out per
+// minute (rate limiting) and failed deliveries are retried with
+// exponential backoff. Every event carries the top consumers of
+// cpu, memory and io at the time it was detected.
+package notify
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/rules"
+	"github.com/square/prodeng/metrics"
+)
+
+const (
+	EventStart   = "start"
+	EventResolve = "resolve"
+)
+
+// Event is sent to every sink, as JSON for webhooks and on stdin
+// for commands
+type Event struct {
+	Type     string         `json:"type"`
+	Time     time.Time      `json:"time"`
+	Hostname string         `json:"hostname"`
+	Problem  *rules.Problem `json:"problem"`
+	Top      *report.Top    `json:"top,omitempty"`
+}
+
+// Sink delivers events
+type Sink interface {
+	Name() string
+	Notify(e *Event) error
+}
+
+type Notifier struct {
+	Sinks        []Sink
+	MaxPerMinute int           // notifications sent per minute; 0 = unlimited
+	MaxRetries   int           // attempts after the first failed one
+	MaxQueue     int           // pending deliveries; oldest are dropped
+	Backoff      time.Duration // first retry delay, doubled every attempt
+	MaxBackoff   time.Duration
+	Metrics      *NotifierMetrics
+	active       map[string]*rules.Problem // keyed by rule name + value name
+	queue        []*delivery
+	sent         []time.Time // send times within the last minute
+	hostname     string
+	mu           sync.Mutex
+}
+
+type NotifierMetrics struct {
+	Started     *metrics.Counter // problems started
+	Resolved    *metrics.Counter // problems resolved
+	Sent        *metrics.Counter // successful deliveries
+	Failed      *metrics.Counter // failed delivery attempts
+	Dropped     *metrics.Counter // deliveries given up or evicted
+	RateLimited *metrics.Counter // deliveries delayed by the rate limit
+	Queued      *metrics.Gauge   // deliveries waiting to be sent
+}
+
+type delivery struct {
+	sink     Sink
+	event    *Event
+	attempts int
+	next     time.Time
+}
+
+// New returns a notifier delivering to sinks. Pending deliveries
+// are sent every Step.
+func New(m *metrics.MetricContext, Step time.Duration, sinks ...Sink) *Notifier {
+	n := new(Notifier)
+	n.Sinks = sinks
+	n.MaxPerMinute = 10
+	n.MaxRetries = 5
+	n.MaxQueue = 100
+	n.Backoff = 10 * time.Second
+	n.MaxBackoff = 10 * time.Minute
+	n.active = make(map[string]*rules.Problem)
+	n.hostname, _ = os.Hostname()
+
+	n.Metrics = new(NotifierMetrics)
+	misc.InitializeMetrics(n.Metrics, m, "notify", true)
+
+	ticker := time.NewTicker(Step)
+	go func() {
+		for t := range ticker.C {
+			n.Flush(t)
+		}
+	}()
+
+	return n
+}
+
+// Update compares problems with those seen before and queues start
+// events for new problems and resolve events for problems that are
+// gone. top is only called if there is something to send. Queued
+// events are sent by the next Flush.
+func (n *Notifier) Update(problems []*rules.Problem, top func() *report.Top, now time.Time) {
+	n.mu.Lock()
+	var events []*Event
+	seen := make(map[string]bool)
+	for _, p := range problems {
+		key := p.Rule + "\x00" + p.Name
+		seen[key] = true
+		if _, ok := n.active[key]; !ok {
+			events = append(events, &Event{Type: EventStart, Problem: p})
+			n.Metrics.Started.Add(1)
+		}
+		n.active[key] = p
+	}
+	for key, p := range n.active {
+		if !seen[key] {
+			events = append(events, &Event{Type: EventResolve, Problem: p})
+			delete(n.active, key)
+			n.Metrics.Resolved.Add(1)
+		}
+	}
+	n.mu.Unlock()
+
+	if len(events) == 0 {
+		return
+	}
+
+	// sorted so that deliveries are in a predictable order
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Problem.Message < events[j].Problem.Message
+	})
+
+	var t *report.Top
+	if top != nil {
+		t = top()
+	}
+
+	n.mu.Lock()
+	for _, e := range events {
+		e.Time = now
+		e.Hostname = n.hostname
+		e.Top = t
+		for _, s := range n.Sinks {
+			n.enqueue(&delivery{sink: s, event: e, next: now})
+		}
+	}
+	n.mu.Unlock()
+}
+
+// Flush sends deliveries that are due, respecting the rate limit.
+// Sinks are called without holding the lock so a slow sink doesn't
+// block Update.
+func (n *Notifier) Flush(now time.Time) {
+	n.mu.Lock()
+	// forget sends older than a minute
+	i := 0
+	for i < len(n.sent) && now.Sub(n.sent[i]) >= time.Minute {
+		i++
+	}
+	n.sent = n.sent[i:]
+
+	var due, pending []*delivery
+	for _, d := range n.queue {
+		if d.next.After(now) {
+			pending = append(pending, d)
+			continue
+		}
+		if n.MaxPerMinute > 0 && len(n.sent) >= n.MaxPerMinute {
+			n.Metrics.RateLimited.Add(1)
+			pending = append(pending, d)
+			continue
+		}
+		n.sent = append(n.sent, now)
+		due = append(due, d)
+	}
+	n.queue = pending
+	n.Metrics.Queued.Set(float64(len(n.queue)))
+	n.mu.Unlock()
+
+	var retry []*delivery
+	for _, d := range due {
+		err := d.sink.Notify(d.event)
+		if err == nil {
+			n.Metrics.Sent.Add(1)
+			continue
+		}
+
+		n.Metrics.Failed.Add(1)
+		log.Printf("notify: %s: %v", d.sink.Name(), err)
+		if d.attempts >= n.MaxRetries {
+			n.Metrics.Dropped.Add(1)
+			continue
+		}
+		d.next = now.Add(n.backoff(d.attempts))
+		d.attempts++
+		retry = append(retry, d)
+	}
+	if len(retry) == 0 {
+		return
+	}
+
+	// retries are older than anything queued meanwhile
+	n.mu.Lock()
+	n.queue = append(retry, n.queue...)
+	if n.MaxQueue > 0 && len(n.queue) > n.MaxQueue {
+		n.Metrics.Dropped.Add(uint64(len(n.queue) - n.MaxQueue))
+		n.queue = n.queue[len(n.queue)-n.MaxQueue:]
+	}
+	n.Metrics.Queued.Set(float64(len(n.queue)))
+	n.mu.Unlock()
+}
+
+// Unexported functions
+
+// enqueue adds a delivery, evicting the oldest one if the queue
+// is full. Callers must hold n.mu.
+func (n *Notifier) enqueue(d *delivery) {
+	if n.MaxQueue > 0 && len(n.queue) >= n.MaxQueue {
+		n.queue = n.queue[1:]
+		n.Metrics.Dropped.Add(1)
+	}
+	n.queue = append(n.queue, d)
+	n.Metrics.Queued.Set(float64(len(n.queue)))
+}
+
+func (n *Notifier) backoff(attempts int) time.Duration {
+	b := n.Backoff
+	for i := 0; i < attempts && b < n.MaxBackoff; i++ {
+		b *= 2
+	}
+	if b > n.MaxBackoff {
+		b = n.MaxBackoff
+	}
+	return b
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2014 Square, Inc
+
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/rules"
+	"github.com/square/prodeng/metrics"
+)
+
+type fakeSink struct {
+	events []*Event
+	fail   int // fail this many times
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Notify(e *Event) error {
+	if s.fail > 0 {
+		s.fail--
+		return errors.New("unavailable")
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *fakeSink) types() string {
+	var t []string
+	for _, e := range s.events {
+		t = append(t, e.Type+":"+e.Problem.Name)
+	}
+	return strings.Join(t, ",")
+}
+
+func newNotifier(sinks ...Sink) *Notifier {
+	return New(metrics.NewMetricContext("test"), time.Hour, sinks...)
+}
+
+func problem(name string) *rules.Problem {
+	return &rules.Problem{Rule: "disk_io", Name: name, Message: name + " busy"}
+}
+
+func TestDedupAndResolve(t *testing.T) {
+	s := new(fakeSink)
+	n := newNotifier(s)
+	now := time.Now()
+	calls := 0
+	top := func() *report.Top {
+		calls++
+		return &report.Top{CPU: []*report.Process{{Pid: "1"}}}
+	}
+
+	n.Update([]*rules.Problem{problem("sda")}, top, now)
+	n.Update([]*rules.Problem{problem("sda")}, top, now.Add(time.Second))
+	n.Update([]*rules.Problem{problem("sda"), problem("sdb")}, top, now.Add(2*time.Second))
+	n.Update(nil, top, now.Add(3*time.Second))
+	n.Flush(now.Add(3 * time.Second))
+
+	if got := s.types(); got != "start:sda,start:sdb,resolve:sda,resolve:sdb" {
+		t.Errorf("events = %s", got)
+	}
+	if calls != 3 {
+		t.Errorf("top called %d times, want 3", calls)
+	}
+	if s.events[0].Top == nil || s.events[0].Top.CPU[0].Pid != "1" {
+		t.Errorf("event without top consumers: %+v", s.events[0])
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	s := &fakeSink{fail: 2}
+	n := newNotifier(s)
+	n.Backoff = 10 * time.Second
+	now := time.Now()
+
+	n.Update([]*rules.Problem{problem("sda")}, nil, now)
+	n.Flush(now) // fails, retry in 10s
+	n.Flush(now.Add(5 * time.Second))
+	if len(s.events) != 0 || n.Metrics.Failed.Get() != 1 {
+		t.Fatalf("retried too early: %d failures", n.Metrics.Failed.Get())
+	}
+	n.Flush(now.Add(10 * time.Second)) // fails, retry in 20s
+	n.Flush(now.Add(25 * time.Second))
+	if len(s.events) != 0 {
+		t.Fatalf("retried too early")
+	}
+	n.Flush(now.Add(30 * time.Second))
+	if len(s.events) != 1 || n.Metrics.Sent.Get() != 1 || n.Metrics.Queued.Get() != 0 {
+		t.Errorf("not delivered after backoff: %d sent", n.Metrics.Sent.Get())
+	}
+
+	// give up after MaxRetries
+	s.fail = 100
+	n.MaxRetries = 1
+	n.Update(nil, nil, now)
+	n.Flush(now.Add(time.Hour))
+	n.Flush(now.Add(2 * time.Hour))
+	if n.Metrics.Dropped.Get() != 1 || n.Metrics.Queued.Get() != 0 {
+		t.Errorf("dropped = %d, queued = %v", n.Metrics.Dropped.Get(), n.Metrics.Queued.Get())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := new(fakeSink)
+	n := newNotifier(s)
+	n.MaxPerMinute = 2
+	now := time.Now()
+
+	n.Update([]*rules.Problem{problem("a"), problem("b"), problem("c")}, nil, now)
+	n.Flush(now)
+	if len(s.events) != 2 || n.Metrics.RateLimited.Get() != 1 {
+		t.Fatalf("sent %d events, want 2", len(s.events))
+	}
+	n.Flush(now.Add(30 * time.Second))
+	if len(s.events) != 2 {
+		t.Fatalf("rate limit not applied")
+	}
+	n.Flush(now.Add(time.Minute))
+	if len(s.events) != 3 {
+		t.Errorf("delayed event not sent")
+	}
+}
+
+// blockingSink blocks in Notify until released
+type blockingSink struct {
+	entered chan bool
+	release chan bool
+}
+
+func (s *blockingSink) Name() string { return "blocking" }
+
+func (s *blockingSink) Notify(e *Event) error {
+	s.entered <- true
+	<-s.release
+	return nil
+}
+
+func TestSlowSinkDoesNotBlockUpdate(t *testing.T) {
+	s := &blockingSink{make(chan bool), make(chan bool)}
+	n := newNotifier(s)
+	now := time.Now()
+
+	n.Update([]*rules.Problem{problem("a")}, nil, now)
+	go n.Flush(now)
+	<-s.entered
+
+	updated := make(chan bool)
+	go func() {
+		n.Update([]*rules.Problem{problem("a"), problem("b")}, nil, now)
+		updated <- true
+	}()
+	select {
+	case <-updated:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Update blocked by a sink in progress")
+	}
+	close(s.release)
+}
+
+func TestWebhook(t *testing.T) {
+	var got Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	e := &Event{Type: EventStart, Hostname: "host1", Problem: problem("sda")}
+	if err := NewWebhook(server.URL).Notify(e); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != EventStart || got.Problem.Name != "sda" {
+		t.Errorf("webhook received %+v", got)
+	}
+
+	server.Config.Handler = http.NotFoundHandler()
+	if err := NewWebhook(server.URL).Notify(e); err == nil {
+		t.Errorf("expected error for 404")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	c := NewCommand("/bin/sh -c")
+	c.Args = append(c.Args, `(echo "$INSPECT_EVENT $INSPECT_NAME"; cat) > `+out)
+	e := &Event{Type: EventResolve, Problem: problem("sdb")}
+	if err := c.Notify(e); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(out)
+	if !strings.HasPrefix(string(data), "resolve sdb\n{") {
+		t.Errorf("command got %q", data)
+	}
+
+	c = NewCommand("/bin/sh -c")
+	c.Args = append(c.Args, "echo oops >&2; exit 3")
+	if err := c.Notify(e); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected error with output, got %v", err)
+	}
+
+	c = NewCommand("/bin/sleep 5")
+	c.Timeout = 100 * time.Millisecond
+	if err := c.Notify(e); err == nil {
+		t.Errorf("expected timeout")
+	}
+}
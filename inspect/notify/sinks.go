@@ -0,0 +1,121 @@
+// Copyright (c) 2014 Square, Inc
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Webhook POSTs events as JSON to a URL
+type Webhook struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	w := new(Webhook)
+	w.URL = url
+	w.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	return w
+}
+
+func (w *Webhook) Name() string {
+	return "webhook " + w.URL
+}
+
+func (w *Webhook) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := w.HTTPClient.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// Command runs a local command for every event. The event is
+// passed as JSON on stdin and in environment variables:
+//
+//	INSPECT_EVENT     start or resolve
+//	INSPECT_RULE      rule name
+//	INSPECT_NAME      value name the rule matched
+//	INSPECT_VALUE     value
+//	INSPECT_SEVERITY  warning or critical
+//	INSPECT_MESSAGE   problem message
+//	INSPECT_HOSTNAME  host the problem was found on
+type Command struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+}
+
+// NewCommand returns a command sink for a command line; arguments
+// are separated by whitespace
+func NewCommand(cmdline string) *Command {
+	f := strings.Fields(cmdline)
+	c := new(Command)
+	if len(f) > 0 {
+		c.Path = f[0]
+		c.Args = f[1:]
+	}
+	c.Timeout = DefaultTimeout
+	return c
+}
+
+func (c *Command) Name() string {
+	return "command " + c.Path
+}
+
+func (c *Command) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(c.Path, c.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"INSPECT_EVENT="+e.Type,
+		"INSPECT_RULE="+e.Problem.Rule,
+		"INSPECT_NAME="+e.Problem.Name,
+		fmt.Sprintf("INSPECT_VALUE=%v", e.Problem.Value),
+		"INSPECT_SEVERITY="+e.Problem.Severity,
+		"INSPECT_MESSAGE="+e.Problem.Message,
+		"INSPECT_HOSTNAME="+e.Hostname)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-time.After(c.Timeout):
+		cmd.Process.Kill()
+		<-done
+		err = fmt.Errorf("timed out after %v", c.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", c.Path, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -1,9 +1,17 @@
+// Copyright (c) 2014 Square, Inc
+
 package osmain
 
 import (
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/cpustat"
 	"github.com/square/prodeng/inspect/memstat"
+	"github.com/square/prodeng/inspect/misc"
 	"github.com/square/prodeng/inspect/pidstat"
+	"github.com/square/prodeng/inspect/report"
+	"os"
+	"runtime"
+	"time"
 )
 
 // these are implemented by all supported platforms
@@ -12,3 +20,54 @@ type OsIndependentStats struct {
 	Mstat *memstat.MemStat
 	Procs *pidstat.ProcessStat
 }
+
+// OsIndependentValues adds values computed from OS independent stats
+// to v. These are the names rules select on.
+func OsIndependentValues(s *OsIndependentStats, v map[string]float64) {
+	v["cpu.usage"] = s.Cstat.Usage()
+	v["cpu.user"] = s.Cstat.UserSpace()
+	v["cpu.kernel"] = s.Cstat.Kernel()
+	v["mem.usage_pct"] = (s.Mstat.Usage() / s.Mstat.Total()) * 100
+}
+
+// OsIndependentReport fills r with totals and per process stats
+// available on all platforms
+func OsIndependentReport(s *OsIndependentStats, r *report.Report) {
+	r.Time = time.Now()
+	r.Hostname, _ = os.Hostname()
+	r.OS = runtime.GOOS
+
+	r.CPU.Usage = misc.Finite(s.Cstat.Usage())
+	r.CPU.User = misc.Finite(s.Cstat.UserSpace())
+	r.CPU.Kernel = misc.Finite(s.Cstat.Kernel())
+
+	r.Mem.Usage = misc.Finite(s.Mstat.Usage())
+	r.Mem.Total = misc.Finite(s.Mstat.Total())
+	r.Mem.UsagePct = misc.Finite((s.Mstat.Usage() / s.Mstat.Total()) * 100)
+
+	r.Processes = r.Processes[:0]
+	for _, p := range s.Procs.PerProcess() {
+		r.Processes = append(r.Processes, &report.Process{
+			Pid:  p.Pid(),
+			Comm: p.Comm(),
+			User: p.User(),
+			CPU:  misc.Finite(p.CPUUsage()),
+			Mem:  misc.Finite(p.MemUsage()),
+		})
+	}
+}
+
+// ConfigureOsIndependent applies collector enablement and intervals
+// of c; it is called again when the configuration is reloaded
+func ConfigureOsIndependent(s *OsIndependentStats, c *config.Config) {
+	configure(s.Cstat.Schedule, c, config.CPUStat)
+	configure(s.Mstat.Metrics.Schedule, c, config.MemStat)
+	if s.Procs != nil {
+		configure(s.Procs.Schedule, c, config.PidStat)
+	}
+}
+
+func configure(s *misc.Schedule, c *config.Config, name string) {
+	s.SetInterval(c.Interval(name))
+	s.SetEnabled(c.Enabled(name))
+}
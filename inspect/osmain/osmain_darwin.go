@@ -3,6 +3,9 @@
 package osmain
 
 import (
+	"fmt"
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/report"
 	"github.com/square/prodeng/metrics"
 	"time"
 )
@@ -18,5 +21,24 @@ func RegisterOsDependent(
 	return x
 }
 
-func PrintOsDependent(d *DarwinStats, batchmode bool) {
+func OsDependentValues(d *DarwinStats, v map[string]float64) {
+}
+
+func OsDependentReport(d *DarwinStats, r *report.Report) {
+}
+
+func ConfigureOsDependent(d *DarwinStats, c *config.Config) {
+}
+
+type ProcessDetailStats struct {
+}
+
+func RegisterProcessDetail(m *metrics.MetricContext, step time.Duration,
+	d *OsIndependentStats, pid string, comm string) *ProcessDetailStats {
+
+	return new(ProcessDetailStats)
+}
+
+func PrintProcessDetail(s *ProcessDetailStats, batchmode bool) {
+	fmt.Println("per process detail is not supported on darwin yet")
 }
@@ -5,23 +5,41 @@ package osmain
 
 import (
 	"fmt"
-	"github.com/mgutz/ansi"
+	"github.com/square/prodeng/inspect/config"
 	"github.com/square/prodeng/inspect/cpustat"
 	"github.com/square/prodeng/inspect/diskstat"
+	"github.com/square/prodeng/inspect/fsstat"
 	"github.com/square/prodeng/inspect/interfacestat"
+	"github.com/square/prodeng/inspect/loadstat"
 	"github.com/square/prodeng/inspect/memstat"
 	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/netstat"
 	"github.com/square/prodeng/inspect/pidstat"
+	"github.com/square/prodeng/inspect/psistat"
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/inspect/socketstat"
 	"github.com/square/prodeng/metrics"
+	"math"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
 type LinuxStats struct {
 	dstat  *diskstat.DiskStat
+	fsstat *fsstat.FSStat
 	ifstat *interfacestat.InterfaceStat
 	cg_mem *memstat.CgroupStat
 	cg_cpu *cpustat.CgroupStat
+	cg_io  *diskstat.CgroupStat
+	cg_psi *psistat.CgroupStat
+	psi    *psistat.PSIStat
+	load   *loadstat.LoadStat
+	vmstat *memstat.VMStat
+	nstat  *netstat.NetStat
+	socks  *socketstat.SocketStat
+	freq   *cpustat.FreqStat
 	procs  *pidstat.ProcessStat
 	cstat  *cpustat.CPUStat
 }
@@ -31,74 +49,409 @@ func RegisterOsDependent(m *metrics.MetricContext, step time.Duration,
 
 	s := new(LinuxStats)
 	s.dstat = diskstat.New(m, step)
+	s.fsstat = fsstat.New(m, step)
 	s.ifstat = interfacestat.New(m, step)
 	s.procs = d.Procs // grab it because we need to for per cgroup cpu usage
 	s.cstat = d.Cstat
 	s.cg_mem = memstat.NewCgroupStat(m, step)
 	s.cg_cpu = cpustat.NewCgroupStat(m, step)
+	s.cg_io = diskstat.NewCgroupStat(m, step)
+	s.psi = psistat.New(m, step)
+	s.load = loadstat.New(m, step)
+	s.vmstat = memstat.NewVMStat(m, step)
+	s.nstat = netstat.New(m, step)
+	s.socks = socketstat.New(m, step)
+	s.freq = cpustat.NewFreqStat(m, step)
+	s.procs.SetSocketStat(s.socks)
+	s.cg_psi = psistat.NewCgroupStat(m, step)
 
 	return s
 }
 
-func PrintOsDependent(s *LinuxStats, batchmode bool) {
+// ConfigureOsDependent applies collector enablement, intervals and
+// device, mount, interface and cgroup filters of c; it is called
+// again when the configuration is reloaded
+func ConfigureOsDependent(s *LinuxStats, c *config.Config) {
+	configure(s.dstat.Schedule, c, config.DiskStat)
+	configure(s.fsstat.Schedule, c, config.FSStat)
+	configure(s.ifstat.Schedule, c, config.InterfaceStat)
+	configure(s.cg_cpu.Schedule, c, config.CgroupCPUStat)
+	configure(s.cg_mem.Schedule, c, config.CgroupMemStat)
+	configure(s.cg_io.Schedule, c, config.CgroupDiskStat)
+	configure(s.psi.Schedule, c, config.PSIStat)
+	configure(s.load.Schedule, c, config.LoadStat)
+	configure(s.vmstat.Schedule, c, config.VMStat)
+	configure(s.nstat.Schedule, c, config.NetStat)
+	configure(s.socks.Schedule, c, config.SocketStat)
+	configure(s.freq.Schedule, c, config.FreqStat)
+	configure(s.cg_psi.Schedule, c, config.CgroupPSIStat)
 
-	var problems []string
+	s.dstat.SetFilter(&c.Devices)
+	s.fsstat.SetFilter(&c.Mounts)
+	s.ifstat.SetFilter(&c.Interfaces)
+	s.cg_cpu.SetFilter(&c.Cgroups)
+	s.cg_mem.SetFilter(&c.Cgroups)
+	s.cg_io.SetFilter(&c.Cgroups)
+	s.cg_psi.SetFilter(&c.Cgroups)
+}
 
-	fmt.Println("---")
-	procs_by_usage := s.procs.ByIOUsage()
-	fmt.Println("Top processes by IO usage:")
-	n := 5
-	if len(procs_by_usage) < n {
-		n = len(procs_by_usage)
+// TOP_PORTS is how many local ports and remote addresses with most
+// sockets are reported
+const TOP_PORTS = 10
+
+// BUSY_CPU is the usage % above which a CPU is expected to run near
+// its base frequency
+const BUSY_CPU = 50
+
+type cg_stat struct {
+	cpu *cpustat.PerCgroupStat
+	mem *memstat.PerCgroupStat
+	io  *diskstat.PerCgroupStat
+	psi *psistat.PerCgroupStat
+}
+
+// OsDependentValues adds values computed from linux specific stats
+// to v. These are the names rules select on.
+func OsDependentValues(s *LinuxStats, v map[string]float64) {
+	for d, o := range s.dstat.PerDisk() {
+		v["disk."+d+".usage"] = o.Usage()
 	}
 
-	for i := 0; i < n; i++ {
-		fmt.Printf("io: %s/s command: %s user: %s pid: %v\n",
-			misc.ByteSize(procs_by_usage[i].IOUsage()),
-			procs_by_usage[i].Comm(),
-			procs_by_usage[i].User(),
-			procs_by_usage[i].Pid())
+	for mp, o := range s.fsstat.PerFS() {
+		v["fs."+mp+".usage"] = o.Usage()
+		v["fs."+mp+".file_usage"] = o.FileUsage()
 	}
-	type cg_stat struct {
-		cpu *cpustat.PerCgroupStat
-		mem *memstat.PerCgroupStat
+
+	for iface, o := range s.ifstat.PerInterface() {
+		v["iface."+iface+".tx_usage"] = o.TXBandwidthUsage()
+		v["iface."+iface+".rx_usage"] = o.RXBandwidthUsage()
 	}
 
-	fmt.Println("---")
-	for d, o := range s.dstat.Disks {
-		fmt.Printf("diskio: %s usage: %3.1f%%\n", d, o.Usage())
-		if o.Usage() > 75.0 {
-			problems = append(problems,
-				fmt.Sprintf("Disk IO usage on (%v): %3.1f%%", d, o.Usage()))
+	v["net.tcp.retransmits"] = s.nstat.Retransmits()
+	v["net.tcp.listen_overflows"] = s.nstat.ListenOverflows()
+	v["net.tcp.listen_drops"] = s.nstat.ListenDrops()
+	v["net.tcp.mem_usage"] = s.nstat.TCPMemUsage()
+	v["net.tcp.mem_aborts"] = s.nstat.MemoryAborts()
+	v["net.tcp.orphan_usage"] = s.nstat.OrphanUsage()
+	v["net.tcp.timewait"] = s.nstat.TimeWait()
+	v["net.tcp.timewait_usage"] = s.nstat.TimeWaitUsage()
+
+	v["cpu.iowait"] = s.cstat.IOWait()
+	v["cpu.steal"] = s.cstat.StealTime()
+	v["cpu.irq"] = s.cstat.IRQ()
+	v["cpu.softirq"] = s.cstat.SoftIRQ()
+	v["cpu.imbalance"] = s.cstat.Imbalance()
+	for _, cpu := range s.cstat.CPUS() {
+		o := s.cstat.PerCPUStat(cpu)
+		if o == nil {
+			continue
+		}
+		n := strings.TrimPrefix(cpu, "cpu")
+		v["cpu."+n+".usage"] = o.Usage()
+		v["cpu."+n+".softirq"] = o.SoftIRQ()
+		v["cpu."+n+".interrupts"] = o.InterruptRate()
+		v["cpu."+n+".softirqs"] = o.SoftIRQRate("")
+
+		f := s.freq.PerCPU(cpu)
+		if f == nil {
+			continue
+		}
+		v["cpu."+n+".throttles"] = f.Throttles()
+		if s.freq.Available() {
+			v["cpu."+n+".freq_pct"] = f.FreqPct()
+			if o.Usage() > BUSY_CPU {
+				v["cpu."+n+".busy_freq_pct"] = f.FreqPct()
+			}
 		}
 	}
+	for name, z := range s.freq.ThermalZones() {
+		v["thermal."+name+".temp"] = z.Temp.Get()
+	}
 
-	fmt.Println("---")
-	for iface, o := range s.ifstat.Interfaces {
-		fmt.Printf("iface: %s TX: %3.1f%% (%s/s), RX: %3.1f%% (%s/s)\n",
-			iface,
-			o.TXBandwidthUsage(),
-			misc.BitSize(o.TXBandwidth()),
-			o.RXBandwidthUsage(),
-			misc.BitSize(o.RXBandwidth()))
+	l1, l5, l15 := s.load.Load()
+	v["load.1"] = l1
+	v["load.5"] = l5
+	v["load.15"] = l15
+	v["load.per_cpu"] = l1 / float64(len(s.cstat.CPUS()))
+
+	v["vm.swap_in"] = s.vmstat.SwapIn()
+	v["vm.swap_out"] = s.vmstat.SwapOut()
+	v["vm.major_faults"] = s.vmstat.MajorFaults()
+	v["vm.direct_reclaim"] = s.vmstat.DirectReclaim()
+	v["vm.alloc_stalls"] = s.vmstat.AllocStalls()
+	v["vm.compact_stalls"] = s.vmstat.CompactStalls()
+	v["vm.oom_kills"] = s.vmstat.OOMKills()
+
+	if s.psi.Available() {
+		for res, o := range s.psi.Resources {
+			v["psi."+res+".some"] = o.Some()
+			v["psi."+res+".full"] = o.Full()
+		}
+	}
+
+	for name, c := range cgroupStats(s) {
+		if c.cpu != nil {
+			v["cgroup."+name+".cpu_usage"] = cgroupCPUUsage(s, name, c.cpu)
+			v["cgroup."+name+".cpu_throttle"] = c.cpu.Throttle()
+		}
+		if c.mem != nil {
+			v["cgroup."+name+".mem_usage_pct"] =
+				(c.mem.Usage() / c.mem.SoftLimit()) * 100
+		}
+		if c.io != nil {
+			v["cgroup."+name+".io_read"] = c.io.ReadBandwidth()
+			v["cgroup."+name+".io_write"] = c.io.WriteBandwidth()
+		}
+		if c.psi != nil {
+			v["cgroup."+name+".cpu_pressure"] = c.psi.Resources["cpu"].Some()
+			v["cgroup."+name+".mem_pressure"] = c.psi.Resources["memory"].Full()
+			v["cgroup."+name+".io_pressure"] = c.psi.Resources["io"].Full()
+		}
+	}
+}
+
+// OsDependentReport adds linux specific stats to r: per process IO,
+// cmdline and cgroup, disks, filesystems, interfaces and cgroups
+func OsDependentReport(s *LinuxStats, r *report.Report) {
+	r.CPU.Count = len(s.cstat.CPUS())
+	r.CPU.IOWait = misc.Finite(s.cstat.IOWait())
+	r.CPU.Steal = misc.Finite(s.cstat.StealTime())
+	r.CPU.IRQ = misc.Finite(s.cstat.IRQ())
+	r.CPU.SoftIRQ = misc.Finite(s.cstat.SoftIRQ())
+	r.CPU.Imbalance = misc.Finite(s.cstat.Imbalance())
+	r.CPU.PerCPU = r.CPU.PerCPU[:0]
+	for _, cpu := range s.cstat.CPUS() {
+		o := s.cstat.PerCPUStat(cpu)
+		if o == nil {
+			continue
+		}
+		c := &report.CPUCore{
+			Name:    cpu,
+			Usage:   misc.Finite(o.Usage()),
+			User:    misc.Finite(o.UserSpace()),
+			Kernel:  misc.Finite(o.Kernel()),
+			IOWait:  misc.Finite(o.IOWait()),
+			Steal:   misc.Finite(o.StealTime()),
+			IRQ:     misc.Finite(o.IRQ()),
+			SoftIRQ: misc.Finite(o.SoftIRQ()),
+
+			Interrupts: misc.Finite(o.InterruptRate()),
+			SoftIRQs:   misc.Finite(o.SoftIRQRate("")),
+		}
+		if irq, rate := o.TopIRQ(); irq != "" {
+			c.TopIRQ = fmt.Sprintf("%s %.0f/s (%s)", irq, rate, s.cstat.IRQDevice(irq))
+		}
+		if f := s.freq.PerCPU(cpu); f != nil {
+			c.Freq = misc.Finite(f.Cur.Get()) / 1000
+			c.MaxFreq = misc.Finite(f.Max.Get()) / 1000
+			c.Throttles = misc.Finite(f.Throttles())
+		}
+		r.CPU.PerCPU = append(r.CPU.PerCPU, c)
+	}
+	r.Thermal = r.Thermal[:0]
+	for name, z := range s.freq.ThermalZones() {
+		r.Thermal = append(r.Thermal, &report.ThermalZone{
+			Name: name, Type: z.Type, Temp: misc.Finite(z.Temp.Get()),
+		})
+	}
+	sort.Slice(r.Thermal, func(i, j int) bool {
+		return r.Thermal[i].Name < r.Thermal[j].Name
+	})
+
+	l1, l5, l15 := s.load.Load()
+	r.Load = &report.Load{
+		Load1:   misc.Finite(l1),
+		Load5:   misc.Finite(l5),
+		Load15:  misc.Finite(l15),
+		Running: int(misc.Finite(s.cstat.Procs_running.Get())),
+		Blocked: int(misc.Finite(s.cstat.Procs_blocked.Get())),
+	}
+
+	r.Mem.SwapIn = misc.Finite(s.vmstat.SwapIn())
+	r.Mem.SwapOut = misc.Finite(s.vmstat.SwapOut())
+	r.Mem.MajorFaults = misc.Finite(s.vmstat.MajorFaults())
+	r.Mem.DirectReclaim = misc.Finite(s.vmstat.DirectReclaim())
+
+	r.Pressure = nil
+	if s.psi.Available() {
+		r.Pressure = pressure(s.psi.Resources)
+	}
+
+	procs := s.procs.PerProcess()
+	for _, p := range r.Processes {
+		o, ok := procs[p.Pid]
+		if !ok {
+			continue
+		}
+		p.IO = misc.Finite(o.IOUsage())
+		p.Cmdline = o.Cmdline()
+		p.Cgroup = o.Cgroup("cpu")
+		p.Connections = o.Connections()
+		p.Listening = p.Listening[:0]
+		for _, sock := range o.Listening() {
+			p.Listening = append(p.Listening, sock.String())
+		}
+	}
+
+	r.Disks = r.Disks[:0]
+	for d, o := range s.dstat.PerDisk() {
+		r.Disks = append(r.Disks, &report.Disk{Name: d, Usage: misc.Finite(o.Usage())})
+	}
+
+	r.Filesystems = r.Filesystems[:0]
+	for mp, o := range s.fsstat.PerFS() {
+		r.Filesystems = append(r.Filesystems, &report.Filesystem{
+			Name:      mp,
+			Usage:     misc.Finite(o.Usage()),
+			FileUsage: misc.Finite(o.FileUsage()),
+		})
+	}
+
+	r.Interfaces = r.Interfaces[:0]
+	for iface, o := range s.ifstat.PerInterface() {
+		r.Interfaces = append(r.Interfaces, &report.Interface{
+			Name:        iface,
+			TXUsage:     misc.Finite(o.TXBandwidthUsage()),
+			RXUsage:     misc.Finite(o.RXBandwidthUsage()),
+			TXBandwidth: misc.Finite(o.TXBandwidth()),
+			RXBandwidth: misc.Finite(o.RXBandwidth()),
+		})
+	}
 
-		if o.TXBandwidthUsage() > 75.0 {
-			problems = append(problems,
-				fmt.Sprintf("TX bandwidth usage on (%v): %3.1f%%",
-					iface, o.TXBandwidthUsage()))
+	r.Net = &report.Net{
+		Established:     misc.Finite(s.nstat.Established()),
+		TimeWait:        misc.Finite(s.nstat.TimeWait()),
+		Orphans:         misc.Finite(s.nstat.Orphans()),
+		TCPMem:          misc.Finite(s.nstat.TCPMem()),
+		TCPMemLimit:     misc.Finite(s.nstat.TCPMemLimit()),
+		Retransmits:     misc.Finite(s.nstat.Retransmits()),
+		ListenOverflows: misc.Finite(s.nstat.ListenOverflows()),
+	}
+
+	r.Ports = r.Ports[:0]
+	for _, o := range s.socks.Ports(TOP_PORTS) {
+		p := &report.Port{
+			Proto:       o.Proto,
+			Port:        o.Port,
+			Sockets:     o.Sockets,
+			Established: o.States["ESTABLISHED"],
+			TimeWait:    o.States["TIME_WAIT"],
 		}
+		if len(o.Pids) > 0 {
+			p.Pid = o.Pids[0]
+			p.Comm = pidstat.Comm(p.Pid)
+		}
+		r.Ports = append(r.Ports, p)
+	}
 
-		if o.RXBandwidthUsage() > 75.0 {
-			problems = append(problems,
-				fmt.Sprintf("RX bandwidth usage on (%v): %3.1f%%",
-					iface, o.RXBandwidthUsage()))
+	r.Remotes = r.Remotes[:0]
+	for _, o := range s.socks.Remotes(TOP_PORTS) {
+		r.Remotes = append(r.Remotes, &report.Remote{
+			Proto:       o.Proto,
+			Address:     o.Addr(),
+			Sockets:     o.Sockets,
+			Established: o.States["ESTABLISHED"],
+			TimeWait:    o.States["TIME_WAIT"],
+		})
+	}
+
+	r.Cgroups = r.Cgroups[:0]
+	for name, c := range cgroupStats(s) {
+		cg := &report.Cgroup{Name: name}
+		if c.cpu != nil {
+			cg.CPUUsage = misc.Finite(cgroupCPUUsage(s, name, c.cpu))
+			cg.CPUThrottle = misc.Finite(c.cpu.Throttle())
+			cg.CPUQuota = misc.Finite(c.cpu.Quota())
+		}
+		if c.mem != nil {
+			cg.MemUsage = misc.Finite(c.mem.Usage())
+			cg.MemLimit = misc.Finite(c.mem.SoftLimit())
 		}
+		if c.io != nil {
+			cg.IORead = misc.Finite(c.io.ReadBandwidth())
+			cg.IOWrite = misc.Finite(c.io.WriteBandwidth())
+		}
+		if c.psi != nil {
+			cg.Pressure = pressure(c.psi.Resources)
+		}
+		r.Cgroups = append(r.Cgroups, cg)
+	}
+	r.Sort()
+}
+
+// ProcessDetailStats follows a single process (inspect -pid/-comm)
+type ProcessDetailStats struct {
+	detail *pidstat.ProcessDetail
+	cg_mem *memstat.CgroupStat
+	cg_cpu *cpustat.CgroupStat
+	cstat  *cpustat.CPUStat
+}
+
+func RegisterProcessDetail(m *metrics.MetricContext, step time.Duration,
+	d *OsIndependentStats, pid string, comm string) *ProcessDetailStats {
+
+	s := new(ProcessDetailStats)
+	s.detail = pidstat.NewProcessDetail(m, step, pid, comm)
+	s.cstat = d.Cstat
+	s.cg_mem = memstat.NewCgroupStat(m, step)
+	s.cg_cpu = cpustat.NewCgroupStat(m, step)
+	return s
+}
+
+func PrintProcessDetail(s *ProcessDetailStats, batchmode bool) {
+	p := s.detail
+	o := p.Proc
+
+	fmt.Println("--------------------------")
+	if !p.Alive() {
+		fmt.Println("process not running")
+		return
 	}
 
+	fmt.Printf("pid: %s command: %s user: %s\n", p.Pid(), o.Comm(), o.User())
+	fmt.Printf("cmdline: %s\n", o.Cmdline())
+	fmt.Printf("cpu: %3.1f%% (user: %3.1f%%, sys: %3.1f%%)\n",
+		o.CPUUsage(), o.UserSpace(), o.Kernel())
+	fmt.Printf("mem: rss: %s pss: %s swap: %s\n",
+		misc.ByteSize(o.MemUsage()), misc.ByteSize(p.Pss()),
+		misc.ByteSize(p.Swap()))
+	fmt.Printf("io: read: %s/s write: %s/s\n",
+		misc.ByteSize(o.Metrics.IOReadBytes.ComputeRate()),
+		misc.ByteSize(o.Metrics.IOWriteBytes.ComputeRate()))
+	fmt.Printf("threads: %.0f fds: %.0f\n", p.Threads(), p.Fds())
+	fmt.Printf("ctx switches: voluntary: %.1f/s nonvoluntary: %.1f/s\n",
+		p.VoluntaryCtxtSwitches(), p.NonvoluntaryCtxtSwitches())
+
 	fmt.Println("---")
+	cgroup := o.Cgroup("cpu")
+	out := fmt.Sprintf("cgroup: cpu: %s ", cgroup)
+	if cpu, ok := s.cg_cpu.PerCgroup()[filepath.Join(s.cg_cpu.Mountpoint, cgroup)]; ok {
+		out += fmt.Sprintf("cpu_throttling: %3.1f%% (%.1f/%d)",
+			cpu.Throttle(), cpu.Quota(), len(s.cstat.CPUS()))
+	}
+	fmt.Println(out)
+
+	cgroup = o.Cgroup("memory")
+	out = fmt.Sprintf("cgroup: memory: %s ", cgroup)
+	if mem, ok := s.cg_mem.PerCgroup()[filepath.Join(s.cg_mem.Mountpoint, cgroup)]; ok {
+		out += fmt.Sprintf("mem: %3.1f%% (%s/%s)",
+			(mem.Usage()/mem.SoftLimit())*100,
+			misc.ByteSize(mem.Usage()), misc.ByteSize(mem.SoftLimit()))
+	}
+	fmt.Println(out)
+
+	fmt.Println("---")
+	for _, sock := range p.Listening() {
+		fmt.Printf("listening: %s\n", sock)
+	}
+}
+
+// cgroupStats merges cpu, memory, io and pressure stats of cgroups
+// by name relative to their mountpoint
+func cgroupStats(s *LinuxStats) map[string]*cg_stat {
 	// so much for printing cpu/mem stats for cgroup together
 	cg_stats := make(map[string]*cg_stat)
-	for name, mem := range s.cg_mem.Cgroups {
+	for name, mem := range s.cg_mem.PerCgroup() {
 		name, _ = filepath.Rel(s.cg_mem.Mountpoint, name)
 		_, ok := cg_stats[name]
 		if !ok {
@@ -107,7 +460,7 @@ func PrintOsDependent(s *LinuxStats, batchmode bool) {
 		cg_stats[name].mem = mem
 	}
 
-	for name, cpu := range s.cg_cpu.Cgroups {
+	for name, cpu := range s.cg_cpu.PerCgroup() {
 		name, _ = filepath.Rel(s.cg_cpu.Mountpoint, name)
 		_, ok := cg_stats[name]
 		if !ok {
@@ -116,45 +469,44 @@ func PrintOsDependent(s *LinuxStats, batchmode bool) {
 		cg_stats[name].cpu = cpu
 	}
 
-	for name, v := range cg_stats {
-		var out string
-
-		out = fmt.Sprintf("cgroup:%s ", name)
-		if v.cpu != nil {
-			// get CPU usage per cgroup from pidstat
-			// unfortunately this is not exposed at cgroup level
-			cpu_usage := s.procs.CPUUsagePerCgroup(name)
-			cpu_throttle := v.cpu.Throttle()
-			out += fmt.Sprintf("cpu: %3.1f%% ", cpu_usage)
-			out += fmt.Sprintf(
-				"cpu_throttling: %3.1f%% (%.1f/%d) ",
-				cpu_throttle, v.cpu.Quota(),
-				(len(s.cstat.CPUS()) - 1))
-			if cpu_throttle > 0.5 {
-				problems =
-					append(problems,
-						fmt.Sprintf(
-							"CPU throttling on cgroup(%s): %3.1f%%",
-							name, cpu_throttle))
-			}
-
+	for name, io := range s.cg_io.PerCgroup() {
+		name, _ = filepath.Rel(s.cg_io.Mountpoint, name)
+		_, ok := cg_stats[name]
+		if !ok {
+			cg_stats[name] = new(cg_stat)
 		}
+		cg_stats[name].io = io
+	}
 
-		if v.mem != nil {
-			out += fmt.Sprintf(
-				"mem: %3.1f%% (%s/%s) ",
-				(v.mem.Usage()/v.mem.SoftLimit())*100,
-				misc.ByteSize(v.mem.Usage()), misc.ByteSize(v.mem.SoftLimit()))
+	for name, psi := range s.cg_psi.PerCgroup() {
+		name, _ = filepath.Rel(s.cg_psi.Mountpoint, name)
+		_, ok := cg_stats[name]
+		if !ok {
+			cg_stats[name] = new(cg_stat)
 		}
-		fmt.Println(out)
+		cg_stats[name].psi = psi
+	}
+	return cg_stats
+}
+
+// cgroupCPUUsage prefers the kernel's accounting of the cgroup over
+// summing up the processes pidstat tracks
+func cgroupCPUUsage(s *LinuxStats, name string, cpu *cpustat.PerCgroupStat) float64 {
+	if u := cpu.Usage(); !math.IsNaN(u) {
+		return u
 	}
+	return s.procs.CPUUsagePerCgroup(name)
+}
 
-	fmt.Println("---")
-	for i := range problems {
-		msg := problems[i]
-		if !batchmode {
-			msg = ansi.Color(msg, "red")
-		}
-		fmt.Println("Problem: ", msg)
+// pressure converts per resource pressure stats for a report
+func pressure(res map[string]*psistat.PerResourceStat) *report.Pressure {
+	stall := func(name string) report.Stall {
+		o := res[name]
+		return report.Stall{Some: misc.Finite(o.Some()), Full: misc.Finite(o.Full())}
+	}
+	return &report.Pressure{
+		CPU:    stall("cpu"),
+		Memory: stall("memory"),
+		IO:     stall("io"),
 	}
 }
@@ -39,7 +39,7 @@ func (a ByCPUUsage) Less(i, j int) bool { return a[i].CPUUsage() > a[j].CPUUsage
 // by CPU usage
 func (c *ProcessStat) ByCPUUsage() []*PerProcessStat {
 	v := make([]*PerProcessStat, 0)
-	for _, o := range c.Processes {
+	for _, o := range c.PerProcess() {
 		if !math.IsNaN(o.CPUUsage()) {
 			v = append(v, o)
 		}
@@ -58,7 +58,7 @@ func (a ByMemUsage) Less(i, j int) bool { return a[i].MemUsage() > a[j].MemUsage
 // by Memory usage
 func (c *ProcessStat) ByMemUsage() []*PerProcessStat {
 	v := make([]*PerProcessStat, 0)
-	for _, o := range c.Processes {
+	for _, o := range c.PerProcess() {
 		if !math.IsNaN(o.MemUsage()) {
 			v = append(v, o)
 		}
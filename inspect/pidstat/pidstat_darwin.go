@@ -8,6 +8,7 @@ import (
 	"github.com/square/prodeng/metrics"
 	"os/user"
 	"reflect"
+	"sync"
 	"time"
 	"unsafe"
 )
@@ -46,8 +47,10 @@ const NS = 1 * 1000 * 1000 * 1000
 
 type ProcessStat struct {
 	Processes map[string]*PerProcessStat
+	Schedule  *misc.Schedule
 	m         *metrics.MetricContext
 	hport     C.host_t
+	mu        sync.RWMutex // guards Processes
 }
 
 // NewProcessStat allocates a new ProcessStat object
@@ -69,21 +72,18 @@ func NewProcessStat(m *metrics.MetricContext, Step time.Duration) *ProcessStat {
 	c.hport = C.host_t(C.mach_host_self())
 
 	var n int
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			p := int(len(c.Processes) / 1024)
-			if n == 0 {
-				c.Collect(true)
-			}
-			// always collect all metrics for first two samples
-			// and if number of processes < 1024
-			if p < 1 || n%p == 0 {
-				c.Collect(false)
-			}
-			n++
+	c.Schedule = misc.NewSchedule("pidstat", Step, func() {
+		p := int(len(c.Processes) / 1024)
+		if n == 0 {
+			c.Collect(true)
 		}
-	}()
+		// always collect all metrics for first two samples
+		// and if number of processes < 1024
+		if p < 1 || n%p == 0 {
+			c.Collect(false)
+		}
+		n++
+	})
 
 	return c
 }
@@ -93,6 +93,17 @@ func (s *ProcessStat) SetPidFilter(filter PidFilterFunc) {
 	return
 }
 
+// PerProcess returns a copy of the processes by pid
+func (c *ProcessStat) PerProcess() map[string]*PerProcessStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerProcessStat, len(c.Processes))
+	for pid, o := range c.Processes {
+		ret[pid] = o
+	}
+	return ret
+}
+
 // reference /usr/include/mach/task_info.h
 // works on MacOSX 10.9.2; YMMV might vary
 
@@ -160,7 +171,9 @@ func (c *ProcessStat) Collect(collectAttributes bool) {
 		pidstat, ok := h[spid]
 		if !ok {
 			pidstat = NewPerProcessStat(c.m, spid)
+			c.mu.Lock()
 			h[spid] = pidstat
+			c.mu.Unlock()
 		}
 
 		if collectAttributes || !ok {
@@ -186,11 +199,13 @@ func (c *ProcessStat) Collect(collectAttributes bool) {
 	}
 
 	// remove dead processes
+	c.mu.Lock()
 	for k, v := range h {
 		if v.dead {
 			delete(h, k)
 		}
 	}
+	c.mu.Unlock()
 
 }
 
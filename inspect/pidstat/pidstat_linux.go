@@ -6,7 +6,9 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"github.com/square/prodeng/inspect/cgroup"
 	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/socketstat"
 	"github.com/square/prodeng/metrics"
 	"io/ioutil"
 	"math"
@@ -16,6 +18,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,9 +39,12 @@ var _ = fmt.Println
 
 type ProcessStat struct {
 	Processes map[string]*PerProcessStat
+	Schedule  *misc.Schedule
 	m         *metrics.MetricContext
 	x         []*PerProcessStat
 	filter    PidFilterFunc
+	sockets   *socketstat.SocketStat
+	mu        sync.RWMutex // guards Processes
 }
 
 // Collects metrics every Step seconds
@@ -64,12 +70,7 @@ func NewProcessStat(m *metrics.MetricContext, Step time.Duration) *ProcessStat {
 	// Assign a default filter for pids
 	c.filter = PidFilterFunc(defaultPidFilter)
 
-	ticker := time.NewTicker(Step)
-	go func() {
-		for _ = range ticker.C {
-			c.Collect()
-		}
-	}()
+	c.Schedule = misc.NewSchedule("pidstat", Step, c.Collect)
 
 	return c
 }
@@ -79,6 +80,23 @@ func (s *ProcessStat) SetPidFilter(filter PidFilterFunc) {
 	return
 }
 
+// SetSocketStat sets the socket table connections and listening
+// ports of processes are looked up in
+func (s *ProcessStat) SetSocketStat(t *socketstat.SocketStat) {
+	s.sockets = t
+}
+
+// PerProcess returns a copy of the processes by pid
+func (c *ProcessStat) PerProcess() map[string]*PerProcessStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerProcessStat, len(c.Processes))
+	for pid, o := range c.Processes {
+		ret[pid] = o
+	}
+	return ret
+}
+
 // Return list of processes sorted by IO
 type ByIOUsage []*PerProcessStat
 
@@ -90,7 +108,7 @@ func (a ByIOUsage) Less(i, j int) bool { return a[i].IOUsage() > a[j].IOUsage()
 // by Memory usage
 func (c *ProcessStat) ByIOUsage() []*PerProcessStat {
 	v := make([]*PerProcessStat, 0)
-	for _, o := range c.Processes {
+	for _, o := range c.PerProcess() {
 		if !math.IsNaN(o.IOUsage()) {
 			v = append(v, o)
 		}
@@ -106,7 +124,7 @@ func (c *ProcessStat) CPUUsagePerCgroup(cgroup string) float64 {
 		cgroup = "/" + cgroup
 	}
 
-	for _, o := range c.Processes {
+	for _, o := range c.PerProcess() {
 		if (o.Cgroup("cpu") == cgroup) && !math.IsNaN(o.CPUUsage()) {
 			ret += o.CPUUsage()
 		}
@@ -120,7 +138,7 @@ func (c *ProcessStat) MemUsagePerCgroup(cgroup string) float64 {
 	if !path.IsAbs(cgroup) {
 		cgroup = "/" + cgroup
 	}
-	for _, o := range c.Processes {
+	for _, o := range c.PerProcess() {
 		if (o.Cgroup("memory") == cgroup) && !math.IsNaN(o.MemUsage()) {
 			ret += o.MemUsage()
 		}
@@ -137,8 +155,9 @@ func (c *ProcessStat) Collect() {
 		v.Metrics.dead = true
 	}
 
-	pids, err := ioutil.ReadDir("/proc")
+	pids, err := ioutil.ReadDir(misc.ProcPath())
 	if err != nil {
+		misc.CollectorFailed("pidstat", err)
 		return
 	}
 
@@ -161,7 +180,10 @@ func (c *ProcessStat) Collect() {
 
 		for i, pidstat := range c.x {
 			if c.filter(pidstat) {
+				c.mu.Lock()
 				h[pidstat.Pid()] = pidstat
+				c.mu.Unlock()
+				pidstat.sockets = c.sockets
 				pidstat.Metrics.Register() // forces registration with new name
 				c.x[i] = NewPerProcessStat(c.m, "")
 				pidstat.Metrics.dead = false
@@ -170,12 +192,15 @@ func (c *ProcessStat) Collect() {
 	}
 
 	// remove dead processes
+	c.mu.Lock()
 	for k, v := range h {
 		if v.Metrics.dead {
 			v.Metrics.Unregister()
 			delete(h, k)
 		}
 	}
+	c.mu.Unlock()
+	misc.CollectorSucceeded("pidstat")
 }
 
 // unexported
@@ -195,8 +220,13 @@ func (c *ProcessStat) scanProc(pids *[]os.FileInfo, start_idx int, end_idx int)
 
 // Per Process functions
 type PerProcessStat struct {
-	Metrics *PerProcessStatMetrics
-	m       *metrics.MetricContext
+	Metrics     *PerProcessStatMetrics
+	m           *metrics.MetricContext
+	cgroups     *cgroup.Membership
+	cgroupsPid  string // pid cgroups were read for
+	cgroupsRead time.Time
+	mu          sync.Mutex
+	sockets     *socketstat.SocketStat
 }
 
 func NewPerProcessStat(m *metrics.MetricContext, p string) *PerProcessStat {
@@ -217,6 +247,18 @@ func (s *PerProcessStat) CPUUsage() float64 {
 	return pct_use
 }
 
+// UserSpace returns % of one CPU spent in userspace
+func (s *PerProcessStat) UserSpace() float64 {
+	o := s.Metrics
+	return (o.Utime.ComputeRate() * 100) / float64(LINUX_TICKS_IN_SEC)
+}
+
+// Kernel returns % of one CPU spent in the kernel
+func (s *PerProcessStat) Kernel() float64 {
+	o := s.Metrics
+	return (o.Stime.ComputeRate() * 100) / float64(LINUX_TICKS_IN_SEC)
+}
+
 func (s *PerProcessStat) MemUsage() float64 {
 	o := s.Metrics
 	return o.Rss.Get() * float64(PAGESIZE)
@@ -232,7 +274,12 @@ func (s *PerProcessStat) Pid() string {
 }
 
 func (s *PerProcessStat) Comm() string {
-	file, err := os.Open("/proc/" + s.Metrics.Pid + "/stat")
+	return Comm(s.Metrics.Pid)
+}
+
+// Comm returns the command name of pid; "" if it doesn't exist
+func Comm(pid string) string {
+	file, err := os.Open(misc.ProcPath(pid, "stat"))
 	defer file.Close()
 
 	if err != nil {
@@ -249,7 +296,7 @@ func (s *PerProcessStat) Comm() string {
 }
 
 func (s *PerProcessStat) Euid() (string, error) {
-	file, err := os.Open("/proc/" + s.Metrics.Pid + "/status")
+	file, err := os.Open(misc.ProcPath(s.Metrics.Pid, "status"))
 	defer file.Close()
 
 	if err != nil {
@@ -269,7 +316,7 @@ func (s *PerProcessStat) Euid() (string, error) {
 }
 
 func (s *PerProcessStat) Egid() (string, error) {
-	file, err := os.Open("/proc/" + s.Metrics.Pid + "/status")
+	file, err := os.Open(misc.ProcPath(s.Metrics.Pid, "status"))
 	defer file.Close()
 
 	if err != nil {
@@ -304,31 +351,59 @@ func (s *PerProcessStat) User() string {
 }
 
 func (s *PerProcessStat) Cmdline() string {
-	content, err := ioutil.ReadFile("/proc/" + s.Metrics.Pid + "/cmdline")
+	content, err := ioutil.ReadFile(misc.ProcPath(s.Metrics.Pid, "cmdline"))
 	if err != nil {
-		return string(content)
+		return ""
 	}
 
-	return ""
+	// arguments are separated by NUL
+	return strings.TrimSpace(strings.Replace(string(content), "\x00", " ", -1))
 }
 
+// Cgroup returns the cgroup of the process in the hierarchy of
+// subsys; the cgroup v2 one if subsys isn't mounted as v1. The
+// process' cgroups are read again after CGROUP_REFRESH.
 func (s *PerProcessStat) Cgroup(subsys string) string {
-	file, err := os.Open("/proc/" + s.Metrics.Pid + "/cgroup")
-	defer file.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pid := s.Metrics.Pid
+	if s.cgroupsPid != pid || time.Since(s.cgroupsRead) > CGROUP_REFRESH {
+		s.cgroups, _ = cgroup.ProcessCgroups(pid)
+		s.cgroupsPid = pid
+		s.cgroupsRead = time.Now()
+	}
+	return s.cgroups.Path(subsys)
+}
 
-	if err == nil {
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			f := strings.Split(scanner.Text(), ":")
-			if f[1] == subsys {
-				return f[2]
-			}
-		}
+// Connections returns the number of tcp connections of the process,
+// listening sockets excluded; 0 without a socket table
+func (s *PerProcessStat) Connections() int {
+	if s.sockets == nil {
+		return 0
+	}
+	if ps := s.sockets.Process(s.Metrics.Pid); ps != nil {
+		return ps.Total()
 	}
+	return 0
+}
 
-	return "/"
+// Listening returns the listening tcp and bound udp sockets of the
+// process; nil without a socket table
+func (s *PerProcessStat) Listening() []*socketstat.Socket {
+	if s.sockets == nil {
+		return nil
+	}
+	if ps := s.sockets.Process(s.Metrics.Pid); ps != nil {
+		return ps.Listening
+	}
+	return nil
 }
 
+// CGROUP_REFRESH is how long the cgroups of a process are cached;
+// processes rarely move between cgroups
+const CGROUP_REFRESH = time.Minute
+
 type PerProcessStatMetrics struct {
 	Pid          string
 	Utime        *metrics.Counter
@@ -385,7 +460,7 @@ func (s *PerProcessStatMetrics) Reset(pid string) {
 // Collect() collects per process CPU/Memory/IO metrics
 func (s *PerProcessStatMetrics) Collect() {
 
-	file, err := os.Open("/proc/" + s.Pid + "/stat")
+	file, err := os.Open(misc.ProcPath(s.Pid, "stat"))
 	defer file.Close()
 
 	if err != nil {
@@ -402,7 +477,7 @@ func (s *PerProcessStatMetrics) Collect() {
 
 	// collect IO metrics
 	// only works if we are superuser on Linux
-	file, err = os.Open("/proc/" + s.Pid + "/io")
+	file, err = os.Open(misc.ProcPath(s.Pid, "io"))
 	defer file.Close()
 
 	if err != nil {
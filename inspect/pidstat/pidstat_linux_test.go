@@ -0,0 +1,39 @@
+// Copyright (c) 2014 Square, Inc
+
+package pidstat
+
+import (
+	"testing"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestPerProcessCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	p := NewPerProcessStat(metrics.NewMetricContext("test"), "42")
+	p.Metrics.Collect()
+
+	o := p.Metrics
+	if o.Utime.Get() != 150 || o.Stime.Get() != 30 || o.Rss.Get() != 2560 {
+		t.Errorf("unexpected stat: utime %d stime %d rss %v",
+			o.Utime.Get(), o.Stime.Get(), o.Rss.Get())
+	}
+	if o.IOReadBytes.Get() != 4096 || o.IOWriteBytes.Get() != 8192 {
+		t.Errorf("unexpected io: read %d write %d",
+			o.IOReadBytes.Get(), o.IOWriteBytes.Get())
+	}
+	if p.Comm() != "(nginx)" || p.Cmdline() != "nginx: worker process -g daemon off;" {
+		t.Errorf("unexpected comm %q cmdline %q", p.Comm(), p.Cmdline())
+	}
+	if euid, err := p.Euid(); err != nil || euid != "33" {
+		t.Errorf("Euid() = %q, %v", euid, err)
+	}
+	if p.Cgroup("cpuacct") != "/web" || p.Cgroup("blkio") != "/web.slice" {
+		t.Errorf("unexpected cgroups %q %q", p.Cgroup("cpuacct"), p.Cgroup("blkio"))
+	}
+	if pids := PidsByComm("nginx"); len(pids) != 1 || pids[0] != "42" {
+		t.Errorf("PidsByComm(nginx) = %v", pids)
+	}
+}
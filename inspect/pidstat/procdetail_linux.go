@@ -0,0 +1,288 @@
+// Copyright (c) 2014 Square, Inc
+
+package pidstat
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/socketstat"
+	"github.com/square/prodeng/metrics"
+)
+
+// ProcessDetail tracks a single process more closely than
+// ProcessStat does: besides CPU/memory/IO it collects thread and
+// fd counts, PSS/swap, context switches and listening sockets.
+// It is not subject to a pid filter.
+type ProcessDetail struct {
+	Proc    *PerProcessStat
+	Metrics *ProcessDetailMetrics
+	comm    string
+	m       *metrics.MetricContext
+}
+
+// NewProcessDetail collects metrics for pid every Step. If comm is
+// not empty the process is looked up by command name instead and
+// followed across restarts.
+func NewProcessDetail(m *metrics.MetricContext, Step time.Duration,
+	pid string, comm string) *ProcessDetail {
+
+	s := new(ProcessDetail)
+	s.m = m
+	s.comm = comm
+	s.Proc = NewPerProcessStat(m, "")
+	s.Metrics = NewProcessDetailMetrics(m, "")
+	s.setPid(pid)
+
+	ticker := time.NewTicker(Step)
+	go func() {
+		for _ = range ticker.C {
+			s.Collect()
+		}
+	}()
+
+	return s
+}
+
+// Collect updates metrics of the tracked process
+func (s *ProcessDetail) Collect() {
+	if s.comm != "" && !s.Alive() {
+		pids := PidsByComm(s.comm)
+		if len(pids) > 0 {
+			s.setPid(pids[0])
+		}
+	}
+	if !s.Alive() {
+		return
+	}
+	s.Proc.Metrics.Collect()
+	s.Metrics.Collect()
+}
+
+// Alive returns true if the tracked process is running
+func (s *ProcessDetail) Alive() bool {
+	if s.Pid() == "" {
+		return false
+	}
+	_, err := os.Stat(misc.ProcPath(s.Pid()))
+	return err == nil
+}
+
+func (s *ProcessDetail) Pid() string {
+	return s.Proc.Pid()
+}
+
+// Threads returns the number of threads
+func (s *ProcessDetail) Threads() float64 {
+	return s.Metrics.Threads.Get()
+}
+
+// Fds returns the number of open file descriptors
+func (s *ProcessDetail) Fds() float64 {
+	return s.Metrics.Fds.Get()
+}
+
+// Pss returns proportional set size in bytes
+func (s *ProcessDetail) Pss() float64 {
+	return s.Metrics.Pss.Get()
+}
+
+// Swap returns swapped out memory in bytes
+func (s *ProcessDetail) Swap() float64 {
+	return s.Metrics.Swap.Get()
+}
+
+// VoluntaryCtxtSwitches returns voluntary context switches per second
+func (s *ProcessDetail) VoluntaryCtxtSwitches() float64 {
+	return s.Metrics.VoluntaryCtxtSwitches.ComputeRate()
+}
+
+// NonvoluntaryCtxtSwitches returns involuntary context switches
+// per second
+func (s *ProcessDetail) NonvoluntaryCtxtSwitches() float64 {
+	return s.Metrics.NonvoluntaryCtxtSwitches.ComputeRate()
+}
+
+// Listening returns sockets the process listens on
+func (s *ProcessDetail) Listening() []*socketstat.Socket {
+	return s.Metrics.listening
+}
+
+// setPid switches to a new process and re-registers metrics
+// under its pid
+func (s *ProcessDetail) setPid(pid string) {
+	if s.Pid() != "" {
+		s.Proc.Metrics.Unregister()
+		s.Metrics.Unregister()
+	}
+	s.Proc.Reset(pid)
+	s.Metrics.Reset(pid)
+	if pid != "" {
+		s.Proc.Metrics.Register()
+		s.Metrics.Register()
+	}
+}
+
+type ProcessDetailMetrics struct {
+	Pid                      string
+	Threads                  *metrics.Gauge
+	Fds                      *metrics.Gauge
+	Pss                      *metrics.Gauge // bytes
+	Swap                     *metrics.Gauge // bytes
+	VoluntaryCtxtSwitches    *metrics.Counter
+	NonvoluntaryCtxtSwitches *metrics.Counter
+	listening                []*socketstat.Socket
+	m                        *metrics.MetricContext
+}
+
+func NewProcessDetailMetrics(m *metrics.MetricContext, pid string) *ProcessDetailMetrics {
+	s := new(ProcessDetailMetrics)
+	s.Pid = pid
+	s.m = m
+
+	// registration happens with the pid in the name
+	misc.InitializeMetrics(s, m, "IGNORE", false)
+
+	return s
+}
+
+// Register metrics with metric context
+func (s *ProcessDetailMetrics) Register() {
+	prefix := "pidstat.pid" + s.Pid
+	s.m.Register(s.Threads, prefix+"."+"Threads")
+	s.m.Register(s.Fds, prefix+"."+"Fds")
+	s.m.Register(s.Pss, prefix+"."+"Pss")
+	s.m.Register(s.Swap, prefix+"."+"Swap")
+	s.m.Register(s.VoluntaryCtxtSwitches, prefix+"."+"VoluntaryCtxtSwitches")
+	s.m.Register(s.NonvoluntaryCtxtSwitches, prefix+"."+"NonvoluntaryCtxtSwitches")
+}
+
+// Unregister metrics with metric context
+func (s *ProcessDetailMetrics) Unregister() {
+	prefix := "pidstat.pid" + s.Pid
+	s.m.Unregister(s.Threads, prefix+"."+"Threads")
+	s.m.Unregister(s.Fds, prefix+"."+"Fds")
+	s.m.Unregister(s.Pss, prefix+"."+"Pss")
+	s.m.Unregister(s.Swap, prefix+"."+"Swap")
+	s.m.Unregister(s.VoluntaryCtxtSwitches, prefix+"."+"VoluntaryCtxtSwitches")
+	s.m.Unregister(s.NonvoluntaryCtxtSwitches, prefix+"."+"NonvoluntaryCtxtSwitches")
+}
+
+func (s *ProcessDetailMetrics) Reset(pid string) {
+	s.Pid = pid
+	s.Threads.Reset()
+	s.Fds.Reset()
+	s.Pss.Reset()
+	s.Swap.Reset()
+	s.VoluntaryCtxtSwitches.Reset()
+	s.NonvoluntaryCtxtSwitches.Reset()
+	s.listening = nil
+}
+
+// Collect() reads /proc/<pid>/status, smaps and fds
+func (s *ProcessDetailMetrics) Collect() {
+	dir := misc.ProcPath(s.Pid)
+
+	file, err := os.Open(dir + "/status")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	splitre := regexp.MustCompile("\\s+")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := splitre.Split(scanner.Text(), -1)
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "Threads:":
+			s.Threads.Set(float64(misc.ParseUint(f[1])))
+		case "VmSwap:":
+			s.Swap.Set(float64(misc.ParseUint(f[1]) * 1024))
+		case "voluntary_ctxt_switches:":
+			s.VoluntaryCtxtSwitches.Set(misc.ParseUint(f[1]))
+		case "nonvoluntary_ctxt_switches:":
+			s.NonvoluntaryCtxtSwitches.Set(misc.ParseUint(f[1]))
+		}
+	}
+
+	s.Pss.Set(readPss(dir))
+
+	fds, err := ioutil.ReadDir(dir + "/fd")
+	if err == nil {
+		s.Fds.Set(float64(len(fds)))
+	}
+
+	inodes := socketstat.SocketInodes(dir)
+	s.listening = nil
+	for _, proto := range socketstat.Protocols {
+		// use the network namespace of the process
+		socks, _ := socketstat.ReadSockets(dir+"/net/"+proto, proto)
+		for _, sock := range socks {
+			if sock.Listening() && inodes[sock.Inode] {
+				s.listening = append(s.listening, sock)
+			}
+		}
+	}
+}
+
+// PidsByComm returns pids of processes with command name comm,
+// lowest pid first
+func PidsByComm(comm string) []string {
+	var ret []int
+	pids, err := ioutil.ReadDir(misc.ProcPath())
+	if err != nil {
+		return nil
+	}
+	for _, f := range pids {
+		pid, err := strconv.Atoi(f.Name())
+		if err != nil || !f.IsDir() {
+			continue
+		}
+		p := &PerProcessStat{Metrics: &PerProcessStatMetrics{Pid: f.Name()}}
+		if strings.Trim(p.Comm(), "()") == comm {
+			ret = append(ret, pid)
+		}
+	}
+	sort.Ints(ret)
+
+	s := make([]string, len(ret))
+	for i, pid := range ret {
+		s[i] = strconv.Itoa(pid)
+	}
+	return s
+}
+
+// Unexported functions
+
+// readPss returns PSS in bytes from smaps_rollup, falling back to
+// summing up smaps on older kernels
+func readPss(dir string) float64 {
+	file, err := os.Open(dir + "/smaps_rollup")
+	if err != nil {
+		file, err = os.Open(dir + "/smaps")
+		if err != nil {
+			return 0
+		}
+	}
+	defer file.Close()
+
+	var pss uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) >= 2 && f[0] == "Pss:" {
+			pss += misc.ParseUint(f[1])
+		}
+	}
+	return float64(pss * 1024)
+}
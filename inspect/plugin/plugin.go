@@ -0,0 +1,462 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package plugin runs external collectors: executables in a plugin
+// directory that print metrics on stdout, one per line:
+//
+//	<name> <type> <value> [<label>=<value> ...]
+//
+// type is gauge or counter; counters are non-negative integers that
+// only go up (rates are computed like for native counters). Blank
+// lines and lines starting with '#' are ignored. For example
+//
+//	raid.degraded gauge 1 array=md0
+//	queue.processed counter 84213
+//
+// printed by a plugin named check_raid is registered as the metrics
+// plugin.check_raid.raid.degraded{array=md0} and
+// plugin.check_raid.queue.processed. Plugins that fail, time out or
+// print malformed lines are counted in plugin.<name>.Failures,
+// Timeouts and ParseErrors; their stderr is logged and reported as
+// the collector's health.
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/report"
+	"github.com/square/prodeng/metrics"
+)
+
+const (
+	TypeGauge   = "gauge"
+	TypeCounter = "counter"
+)
+
+// MAX_STDERR bytes of a plugin's stderr are kept
+const MAX_STDERR = 4096
+
+// Metric is a parsed line of plugin output
+type Metric struct {
+	Name   string
+	Type   string
+	Value  float64
+	Labels map[string]string
+}
+
+// Key returns the metric name with its labels sorted by label name:
+// name{a=1,b=2}
+func (m *Metric) Key() string {
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	var labels []string
+	for k, v := range m.Labels {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return m.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// ParseLine parses one line of plugin output. It returns nil
+// without error for blank lines and comments.
+func ParseLine(line string) (*Metric, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+	f := strings.Fields(line)
+	if len(f) < 3 {
+		return nil, fmt.Errorf("expected name, type and value: %q", line)
+	}
+
+	m := &Metric{Name: f[0], Type: f[1]}
+	if strings.ContainsAny(m.Name, "{}=") {
+		return nil, fmt.Errorf("invalid metric name %q", m.Name)
+	}
+
+	var err error
+	switch m.Type {
+	case TypeGauge:
+		m.Value, err = strconv.ParseFloat(f[2], 64)
+	case TypeCounter:
+		var v uint64
+		v, err = strconv.ParseUint(f[2], 10, 64)
+		m.Value = float64(v)
+	default:
+		return nil, fmt.Errorf("unknown type %q", m.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", m.Type, f[2])
+	}
+
+	for _, l := range f[3:] {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || strings.ContainsAny(l, "{},") {
+			return nil, fmt.Errorf("invalid label %q", l)
+		}
+		if m.Labels == nil {
+			m.Labels = make(map[string]string)
+		}
+		m.Labels[kv[0]] = kv[1]
+	}
+	return m, nil
+}
+
+// Plugin runs one executable on its own schedule
+type Plugin struct {
+	Name     string // file name
+	Path     string
+	Timeout  time.Duration
+	Metrics  *PluginMetrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	gauges   map[string]*metrics.Gauge
+	counters map[string]*metrics.Counter
+	types    map[string]string // metric key -> type
+	lastErr  string
+	stopped  bool // a run still in flight must not report
+	mu       sync.Mutex
+}
+
+type PluginMetrics struct {
+	Runs        *metrics.Counter
+	Failures    *metrics.Counter // non-zero exit, failed to start or timed out
+	Timeouts    *metrics.Counter
+	ParseErrors *metrics.Counter // malformed output lines
+	Duration    *metrics.Gauge   // seconds the last run took
+}
+
+// NewPlugin runs the executable at path every interval, killing it
+// after timeout
+func NewPlugin(m *metrics.MetricContext, path string, interval, timeout time.Duration) *Plugin {
+	p := new(Plugin)
+	p.Name = filepath.Base(path)
+	p.Path = path
+	p.Timeout = timeout
+	p.m = m
+	p.gauges = make(map[string]*metrics.Gauge)
+	p.counters = make(map[string]*metrics.Counter)
+	p.types = make(map[string]string)
+
+	p.Metrics = new(PluginMetrics)
+	misc.InitializeMetrics(p.Metrics, m, p.prefix(), true)
+
+	go p.Run()
+	p.Schedule = misc.NewSchedule(p.prefix(), interval, p.Run)
+	return p
+}
+
+// Run executes the plugin once and updates its metrics
+func (p *Plugin) Run() {
+	p.mu.Lock()
+	timeout := p.Timeout
+	p.mu.Unlock()
+
+	start := time.Now()
+	stdout, err := p.exec(timeout)
+	p.Metrics.Duration.Set(time.Since(start).Seconds())
+	p.Metrics.Runs.Add(1)
+
+	if err != nil {
+		p.Metrics.Failures.Add(1)
+		p.fail(err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	var parseErr error
+	scanner := bufio.NewScanner(bytes.NewReader(stdout))
+	for scanner.Scan() {
+		m, err := ParseLine(scanner.Text())
+		if err != nil {
+			p.Metrics.ParseErrors.Add(1)
+			parseErr = err
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		seen[m.Key()] = true
+		p.update(m)
+	}
+	p.unregisterExcept(seen)
+
+	if parseErr != nil {
+		p.fail(parseErr)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.lastErr = ""
+	misc.CollectorSucceeded(p.prefix())
+}
+
+// Stop stops running the plugin and unregisters its metrics. A run
+// in flight finishes without registering metrics or reporting health.
+func (p *Plugin) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	p.Schedule.Stop()
+	for key := range p.types {
+		p.unregister(key)
+	}
+	misc.UnregisterMetrics(p.Metrics, p.m, p.prefix())
+}
+
+// Report returns the plugin's metrics and last error
+func (p *Plugin) Report() *report.Plugin {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r := &report.Plugin{Name: p.Name, Error: p.lastErr}
+	for key, g := range p.gauges {
+		r.Metrics = append(r.Metrics, &report.PluginMetric{Name: key, Type: TypeGauge, Value: g.Get()})
+	}
+	for key, c := range p.counters {
+		r.Metrics = append(r.Metrics, &report.PluginMetric{Name: key, Type: TypeCounter, Value: c.ComputeRate()})
+	}
+	sort.Slice(r.Metrics, func(i, j int) bool { return r.Metrics[i].Name < r.Metrics[j].Name })
+	return r
+}
+
+// Plugins runs every executable in a directory. The directory is
+// rescanned every RESCAN_INTERVAL and when reconfigured.
+type Plugins struct {
+	Plugins map[string]*Plugin // by file name
+	conf    config.Plugins
+	m       *metrics.MetricContext
+	mu      sync.Mutex
+}
+
+const RESCAN_INTERVAL = time.Minute
+
+func New(m *metrics.MetricContext, c *config.Plugins) *Plugins {
+	s := new(Plugins)
+	s.Plugins = make(map[string]*Plugin)
+	s.m = m
+	s.Configure(c)
+	misc.NewSchedule("plugins", RESCAN_INTERVAL, s.Scan)
+	return s
+}
+
+// Configure applies the plugin directory, intervals and timeout of
+// c and rescans the directory
+func (s *Plugins) Configure(c *config.Plugins) {
+	s.mu.Lock()
+	s.conf = *c
+	for name, p := range s.Plugins {
+		p.Schedule.SetInterval(c.IntervalOf(name))
+		p.mu.Lock()
+		p.Timeout = c.TimeoutDuration()
+		p.mu.Unlock()
+	}
+	s.mu.Unlock()
+	s.Scan()
+}
+
+// Scan starts plugins for new executables in the directory and
+// stops those of removed ones
+func (s *Plugins) Scan() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// plugins whose directory changed are started again
+	for name, p := range s.Plugins {
+		if p.Path != filepath.Join(s.conf.Dir, name) {
+			p.Stop()
+			delete(s.Plugins, name)
+		}
+	}
+
+	found := make(map[string]bool)
+	if s.conf.Dir != "" {
+		files, err := ioutil.ReadDir(s.conf.Dir)
+		if err != nil {
+			misc.CollectorFailed("plugins", err)
+		} else {
+			for _, f := range files {
+				if executable(f) {
+					found[f.Name()] = true
+				}
+			}
+			misc.CollectorSucceeded("plugins")
+		}
+	}
+
+	for name := range found {
+		if _, ok := s.Plugins[name]; !ok {
+			s.Plugins[name] = NewPlugin(s.m, filepath.Join(s.conf.Dir, name),
+				s.conf.IntervalOf(name), s.conf.TimeoutDuration())
+		}
+	}
+	for name, p := range s.Plugins {
+		if !found[name] {
+			p.Stop()
+			delete(s.Plugins, name)
+		}
+	}
+}
+
+// Report returns all plugins sorted by name
+func (s *Plugins) Report() []*report.Plugin {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ret []*report.Plugin
+	for _, p := range s.Plugins {
+		ret = append(ret, p.Report())
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// Unexported functions
+
+func (p *Plugin) prefix() string {
+	return "plugin." + p.Name
+}
+
+// exec runs the plugin and returns its stdout. Errors include the
+// plugin's stderr.
+func (p *Plugin) exec(timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	stderr := &limitedBuffer{max: MAX_STDERR}
+	cmd := exec.CommandContext(ctx, p.Path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	// children of a killed plugin may keep its output open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		p.Metrics.Timeouts.Add(1)
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *Plugin) fail(err error) {
+	log.Printf("plugin %s: %v", p.Name, err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	p.lastErr = err.Error()
+	misc.CollectorFailed(p.prefix(), err)
+}
+
+// update registers metrics seen for the first time and sets values
+func (p *Plugin) update(m *Metric) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+
+	key := m.Key()
+	name := p.prefix() + "." + key
+
+	// a metric changing type is registered again
+	if t, ok := p.types[key]; ok && t != m.Type {
+		p.unregister(key)
+	}
+	p.types[key] = m.Type
+
+	switch m.Type {
+	case TypeGauge:
+		g, ok := p.gauges[key]
+		if !ok {
+			g = metrics.NewGauge()
+			p.m.Register(g, name)
+			p.gauges[key] = g
+		}
+		g.Set(m.Value)
+	case TypeCounter:
+		c, ok := p.counters[key]
+		if !ok {
+			c = metrics.NewCounter()
+			p.m.Register(c, name)
+			p.counters[key] = c
+		}
+		c.Set(uint64(m.Value))
+	}
+}
+
+// unregisterExcept unregisters metrics not printed by the last run
+func (p *Plugin) unregisterExcept(keep map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.types {
+		if !keep[key] {
+			p.unregister(key)
+		}
+	}
+}
+
+// callers must hold p.mu
+func (p *Plugin) unregister(key string) {
+	name := p.prefix() + "." + key
+	if g, ok := p.gauges[key]; ok {
+		p.m.Unregister(g, name)
+		delete(p.gauges, key)
+	}
+	if c, ok := p.counters[key]; ok {
+		p.m.Unregister(c, name)
+		delete(p.counters, key)
+	}
+	delete(p.types, key)
+}
+
+// executable returns whether f is an executable regular file that is
+// not hidden or an editor backup
+func executable(f os.FileInfo) bool {
+	name := f.Name()
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+		return false
+	}
+	return f.Mode().IsRegular() && f.Mode()&0111 != 0
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if n := b.max - b.Len(); n > 0 {
+		if len(p) > n {
+			b.Buffer.Write(p[:n])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2014 Square, Inc
+
+package plugin
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestParseLine(t *testing.T) {
+	m, err := ParseLine("raid.degraded gauge 1 array=md0 level=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != TypeGauge || m.Value != 1 || m.Key() != "raid.degraded{array=md0,level=5}" {
+		t.Errorf("unexpected metric %+v", m)
+	}
+
+	m, err = ParseLine("  queue.processed counter 84213")
+	if err != nil || m.Key() != "queue.processed" || m.Value != 84213 {
+		t.Errorf("unexpected metric %+v: %v", m, err)
+	}
+
+	for _, line := range []string{"", "   ", "# comment"} {
+		if m, err := ParseLine(line); m != nil || err != nil {
+			t.Errorf("ParseLine(%q) = %v, %v", line, m, err)
+		}
+	}
+
+	for _, line := range []string{
+		"name gauge",
+		"name histogram 1",
+		"name gauge one",
+		"name counter -1",
+		"name counter 1.5",
+		"name{a=b} gauge 1",
+		"name gauge 1 label",
+		"name gauge 1 =v",
+	} {
+		if _, err := ParseLine(line); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func writeScript(t *testing.T, dir, name, body string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPluginRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := metrics.NewMetricContext("test")
+	path := writeScript(t, dir, "check", `
+echo "# header"
+echo "temp gauge 42.5 sensor=a"
+echo "jobs counter 7"
+echo "bad line"
+`)
+	p := &Plugin{Name: "check", Path: path, Timeout: time.Second, m: m,
+		gauges: make(map[string]*metrics.Gauge), counters: make(map[string]*metrics.Counter),
+		types: make(map[string]string), Metrics: new(PluginMetrics)}
+	misc.InitializeMetrics(p.Metrics, m, p.prefix(), true)
+
+	p.Run()
+	if g := p.gauges["temp{sensor=a}"]; g == nil || g.Get() != 42.5 {
+		t.Errorf("gauge not set: %v", p.gauges)
+	}
+	if c := p.counters["jobs"]; c == nil || c.Get() != 7 {
+		t.Errorf("counter not set: %v", p.counters)
+	}
+	if p.Metrics.ParseErrors.Get() != 1 || p.Report().Error == "" {
+		t.Errorf("parse error not reported")
+	}
+
+	// metrics missing from the output are removed
+	writeScript(t, dir, "check", "echo 'jobs counter 9'\n")
+	p.Run()
+	if len(p.gauges) != 0 || p.counters["jobs"].Get() != 9 || p.Report().Error != "" {
+		t.Errorf("unexpected metrics after second run: %+v", p.Report())
+	}
+
+	writeScript(t, dir, "check", "echo oops >&2; exit 3\n")
+	p.Run()
+	if p.Metrics.Failures.Get() != 1 || !strings.Contains(p.Report().Error, "oops") {
+		t.Errorf("failure not reported: %+v", p.Report())
+	}
+
+	writeScript(t, dir, "check", "sleep 5\n")
+	p.Timeout = 100 * time.Millisecond
+	p.Run()
+	if p.Metrics.Timeouts.Get() != 1 || p.Metrics.Failures.Get() != 2 {
+		t.Errorf("timeout not counted")
+	}
+}
+
+func TestStopDuringRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := metrics.NewMetricContext("test")
+	path := writeScript(t, dir, "slow", "sleep 0.3; echo 'temp gauge 1'\n")
+	p := &Plugin{Name: "slow", Path: path, Timeout: time.Second, m: m,
+		gauges: make(map[string]*metrics.Gauge), counters: make(map[string]*metrics.Counter),
+		types: make(map[string]string), Metrics: new(PluginMetrics)}
+	misc.InitializeMetrics(p.Metrics, m, p.prefix(), true)
+
+	done := make(chan bool)
+	go func() {
+		p.Run()
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	p.Stop()
+	<-done
+
+	if len(p.gauges) != 0 {
+		t.Errorf("stopped plugin registered %v", p.gauges)
+	}
+	if _, ok := misc.Health()[p.prefix()]; ok {
+		t.Errorf("stopped plugin reported health")
+	}
+}
+
+func TestConfigureDir(t *testing.T) {
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "plugin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		writeScript(t, dir, "check", "echo 'temp gauge 1'\n")
+		dirs = append(dirs, dir)
+	}
+
+	conf := func(dir string) *config.Plugins {
+		c, err := config.Parse([]byte("plugins: {dir: " + dir + "}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &c.Plugins
+	}
+	s := New(metrics.NewMetricContext("test"), conf(dirs[0]))
+	s.Configure(conf(dirs[1]))
+
+	p := s.Plugins["check"]
+	if p == nil || p.Path != filepath.Join(dirs[1], "check") {
+		t.Errorf("plugin not restarted from the new directory: %+v", p)
+	}
+	s.Configure(conf(""))
+	if len(s.Plugins) != 0 {
+		t.Errorf("plugins not stopped without a directory")
+	}
+}
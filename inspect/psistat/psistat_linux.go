@@ -0,0 +1,300 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package psistat collects Pressure Stall Information: the share of
+// time tasks were stalled waiting for CPU, memory or IO, system wide
+// from /proc/pressure and per cgroup from the *.pressure files of
+// the cgroup v2 hierarchy (linux 4.20+).
+//
+// "some" is time at least one task stalled, "full" time all
+// non-idle tasks stalled at once; full isn't reported for the CPU
+// of the whole system before linux 5.13.
+package psistat
+
+import (
+	"bufio"
+	"errors"
+	"github.com/square/prodeng/inspect/cgroup"
+	"github.com/square/prodeng/inspect/config"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Resources with pressure information
+var Resources = []string{"cpu", "memory", "io"}
+
+type PSIStat struct {
+	Resources map[string]*PerResourceStat
+	Schedule  *misc.Schedule
+	m         *metrics.MetricContext
+}
+
+func New(m *metrics.MetricContext, Step time.Duration) *PSIStat {
+	s := new(PSIStat)
+	s.m = m
+	s.Resources = make(map[string]*PerResourceStat, len(Resources))
+	for _, r := range Resources {
+		s.Resources[r] = NewPerResourceStat(m, "psistat."+r)
+	}
+	s.Schedule = misc.NewSchedule("psistat", Step, s.Collect)
+	return s
+}
+
+func (s *PSIStat) Collect() {
+	// kernels without PSI have no /proc/pressure; not a failure
+	if _, err := os.Stat(misc.ProcPath("pressure")); os.IsNotExist(err) {
+		misc.CollectorSucceeded("psistat")
+		return
+	}
+	for _, r := range Resources {
+		if err := s.Resources[r].Collect(misc.ProcPath("pressure", r)); err != nil {
+			misc.CollectorFailed("psistat", err)
+			return
+		}
+	}
+	misc.CollectorSucceeded("psistat")
+}
+
+// Available returns whether the kernel reports pressure
+func (s *PSIStat) Available() bool {
+	return s.Resources["cpu"].Collected()
+}
+
+// PerResourceStat is the pressure of one resource
+type PerResourceStat struct {
+	Metrics   *PerResourceStatMetrics
+	collected bool
+	mu        sync.RWMutex // guards collected
+}
+
+// averages are % of time stalled, totals usec stalled
+type PerResourceStatMetrics struct {
+	SomeAvg10  *metrics.Gauge
+	SomeAvg60  *metrics.Gauge
+	SomeAvg300 *metrics.Gauge
+	SomeTotal  *metrics.Counter
+	FullAvg10  *metrics.Gauge
+	FullAvg60  *metrics.Gauge
+	FullAvg300 *metrics.Gauge
+	FullTotal  *metrics.Counter
+}
+
+func NewPerResourceStat(m *metrics.MetricContext, prefix string) *PerResourceStat {
+	s := new(PerResourceStat)
+	s.Metrics = new(PerResourceStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, prefix, true)
+	return s
+}
+
+// Collected returns whether the pressure file was read at least once
+func (s *PerResourceStat) Collected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.collected
+}
+
+// Some returns % of time at least one task stalled, averaged over
+// the last 10 seconds
+func (s *PerResourceStat) Some() float64 {
+	return s.Metrics.SomeAvg10.Get()
+}
+
+// Full returns % of time all non-idle tasks stalled, averaged over
+// the last 10 seconds; NaN if not reported
+func (s *PerResourceStat) Full() float64 {
+	return s.Metrics.FullAvg10.Get()
+}
+
+// Collect parses a pressure file:
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func (s *PerResourceStat) Collect(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	o := s.Metrics
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) != 5 {
+			continue
+		}
+		var avg10, avg60, avg300 *metrics.Gauge
+		var total *metrics.Counter
+		switch f[0] {
+		case "some":
+			avg10, avg60, avg300, total = o.SomeAvg10, o.SomeAvg60, o.SomeAvg300, o.SomeTotal
+		case "full":
+			avg10, avg60, avg300, total = o.FullAvg10, o.FullAvg60, o.FullAvg300, o.FullTotal
+		default:
+			continue
+		}
+		for _, kv := range f[1:] {
+			i := strings.Index(kv, "=")
+			if i < 0 {
+				return errors.New("malformed pressure line in " + path)
+			}
+			k, v := kv[:i], kv[i+1:]
+			switch k {
+			case "avg10":
+				setAvg(avg10, v)
+			case "avg60":
+				setAvg(avg60, v)
+			case "avg300":
+				setAvg(avg300, v)
+			case "total":
+				total.Set(misc.ParseUint(v))
+			}
+		}
+	}
+	s.mu.Lock()
+	s.collected = true
+	s.mu.Unlock()
+	return scanner.Err()
+}
+
+// CgroupStat collects pressure of cgroups in the v2 hierarchy
+type CgroupStat struct {
+	Cgroups    map[string]*PerCgroupStat // by path
+	Hierarchy  *cgroup.Hierarchy
+	Mountpoint string
+	Schedule   *misc.Schedule
+	m          *metrics.MetricContext
+	filter     atomic.Value // *config.Filter, replaced on reload
+	mu         sync.RWMutex // guards Cgroups
+}
+
+func NewCgroupStat(m *metrics.MetricContext, Step time.Duration) *CgroupStat {
+	c := new(CgroupStat)
+	c.m = m
+	c.Cgroups = make(map[string]*PerCgroupStat, 1)
+	c.filter.Store(&config.Default().Cgroups)
+
+	// pressure is accounted on the unified hierarchy even if
+	// controllers are still attached to v1
+	hs, _ := cgroup.Hierarchies()
+	for _, h := range hs {
+		if h.Unified {
+			c.Hierarchy = h
+		}
+	}
+	if c.Hierarchy == nil {
+		return c
+	}
+	c.Mountpoint = c.Hierarchy.Mountpoint
+
+	c.Schedule = misc.NewSchedule("cgroup_psistat", Step, c.Collect)
+	return c
+}
+
+// SetFilter selects cgroups by name relative to the mountpoint;
+// cgroups no longer selected are dropped on the next collection
+func (c *CgroupStat) SetFilter(f *config.Filter) {
+	c.filter.Store(f)
+}
+
+// PerCgroup returns a copy of the cgroups by path
+func (c *CgroupStat) PerCgroup() map[string]*PerCgroupStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make(map[string]*PerCgroupStat, len(c.Cgroups))
+	for path, o := range c.Cgroups {
+		ret[path] = o
+	}
+	return ret
+}
+
+func (c *CgroupStat) Collect() {
+	if c.Hierarchy == nil {
+		return
+	}
+	filter := c.filter.Load().(*config.Filter)
+	cgroups, err := c.Hierarchy.Cgroups()
+	if err != nil {
+		misc.CollectorFailed("cgroup_psistat", err)
+		return
+	}
+
+	seen := make(map[string]bool, len(cgroups))
+	for _, cg := range cgroups {
+		if !filter.Match(cg.Name) {
+			continue
+		}
+
+		o, ok := c.Cgroups[cg.Path]
+		if !ok {
+			// kernels without PSI have no pressure files
+			if _, err := os.Stat(cg.File("cpu.pressure")); err != nil {
+				continue
+			}
+			o = NewPerCgroupStat(c.m, cg)
+			c.mu.Lock()
+			c.Cgroups[cg.Path] = o
+			c.mu.Unlock()
+		}
+		seen[cg.Path] = true
+		o.Collect()
+	}
+
+	// stop tracking cgroups which don't exist
+	// anymore, have no tasks or are excluded
+	c.mu.Lock()
+	for path, o := range c.Cgroups {
+		if !seen[path] {
+			o.unregister(c.m)
+			delete(c.Cgroups, path)
+		}
+	}
+	c.mu.Unlock()
+	misc.CollectorSucceeded("cgroup_psistat")
+}
+
+// PerCgroupStat is the pressure of each resource of a cgroup
+type PerCgroupStat struct {
+	Resources map[string]*PerResourceStat
+	Cgroup    *cgroup.Cgroup
+}
+
+func NewPerCgroupStat(m *metrics.MetricContext, cg *cgroup.Cgroup) *PerCgroupStat {
+	c := new(PerCgroupStat)
+	c.Cgroup = cg
+	c.Resources = make(map[string]*PerResourceStat, len(Resources))
+	for _, r := range Resources {
+		c.Resources[r] = NewPerResourceStat(m, c.prefix(r))
+	}
+	return c
+}
+
+func (c *PerCgroupStat) Collect() {
+	for _, r := range Resources {
+		c.Resources[r].Collect(c.Cgroup.File(r + ".pressure"))
+	}
+}
+
+// Unexported functions
+
+func (c *PerCgroupStat) prefix(resource string) string {
+	return "psistat.cgroup." + c.Cgroup.Name + "." + resource
+}
+
+func (c *PerCgroupStat) unregister(m *metrics.MetricContext) {
+	for r, o := range c.Resources {
+		misc.UnregisterMetrics(o.Metrics, m, c.prefix(r))
+	}
+}
+
+func setAvg(g *metrics.Gauge, v string) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err == nil {
+		g.Set(f)
+	}
+}
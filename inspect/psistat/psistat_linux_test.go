@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Square, Inc
+
+package psistat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	if s.Available() {
+		t.Fatalf("available before collection")
+	}
+	s.Collect()
+	if !s.Available() {
+		t.Fatalf("pressure not collected")
+	}
+
+	// no full line for the cpu of older kernels
+	cpu := s.Resources["cpu"]
+	if cpu.Some() != 12.5 || !math.IsNaN(cpu.Full()) ||
+		cpu.Metrics.SomeAvg300.Get() != 3 || cpu.Metrics.SomeTotal.Get() != 123456789 {
+		t.Errorf("unexpected cpu pressure %+v", cpu.Metrics)
+	}
+	io := s.Resources["io"].Metrics
+	if io.FullAvg10.Get() != 22.1 || io.FullAvg60.Get() != 15 ||
+		io.SomeAvg60.Get() != 20 || io.FullTotal.Get() != 54321 {
+		t.Errorf("unexpected io pressure %+v", io)
+	}
+}
+
+func TestCollectUnavailable(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/none"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+	if h := misc.Health()["psistat"]; s.Available() || h.LastFailure != nil {
+		t.Errorf("missing /proc/pressure reported as failure: %+v", h)
+	}
+}
+
+func TestCgroupCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Mtab: "testdata/mtab", Sys: "testdata/sys"})
+
+	// the unified hierarchy is used though it has no controllers
+	c := NewCgroupStat(metrics.NewMetricContext("test"), time.Hour)
+	if c.Hierarchy == nil || !c.Hierarchy.Unified {
+		t.Fatalf("unified hierarchy not found")
+	}
+	c.Collect()
+
+	// old has no pressure files
+	cg, ok := c.PerCgroup()[c.Mountpoint+"/web"]
+	if len(c.Cgroups) != 1 || !ok {
+		t.Fatalf("got cgroups %v, want web", c.Cgroups)
+	}
+	if cpu := cg.Resources["cpu"]; cpu.Some() != 45 || cpu.Full() != 5 {
+		t.Errorf("unexpected cpu pressure %+v", cpu.Metrics)
+	}
+	if io := cg.Resources["io"]; io.Full() != 7.5 || io.Metrics.SomeTotal.Get() != 900 {
+		t.Errorf("unexpected io pressure %+v", io.Metrics)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2014 Square, Inc
+
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPath    = "/report.json"
+	DefaultTimeout = 10 * time.Second
+)
+
+// Client fetches reports from a running `inspect -server`
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewClient returns a client for address which is either a full URL
+// or host:port of an inspect server
+func NewClient(address string) *Client {
+	c := new(Client)
+	c.URL = address
+	if !strings.HasPrefix(address, "http://") &&
+		!strings.HasPrefix(address, "https://") {
+		c.URL = "http://" + address + DefaultPath
+	}
+	c.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	return c
+}
+
+// Fetch retrieves the current report from the server
+func (c *Client) Fetch() (*Report, error) {
+	resp, err := c.HTTPClient.Get(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", c.URL, resp.Status)
+	}
+
+	r := new(Report)
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.URL, err)
+	}
+	return r, nil
+}
+
+// HttpHandler serves the report returned by source as JSON
+func HttpHandler(source func() *Report) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(source())
+	}
+}
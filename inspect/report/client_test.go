@@ -0,0 +1,48 @@
+// Copyright (c) 2014 Square, Inc
+
+package report
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/square/prodeng/inspect/rules"
+)
+
+func TestClientFetch(t *testing.T) {
+	r := testReport()
+	r.OS = "linux"
+	r.Disks = []*Disk{{Name: "sdb", Usage: 92.7}}
+	r.Problems = []*rules.Problem{{Rule: "disk_io", Message: "Disk IO usage on (sdb): 92.7%"}}
+
+	server := httptest.NewServer(HttpHandler(func() *Report { return r }))
+	defer server.Close()
+
+	got, err := NewClient(server.URL + DefaultPath).Fetch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname != "host1" || len(got.Processes) != 3 ||
+		len(got.Disks) != 1 || len(got.Problems) != 1 {
+		t.Errorf("Fetch() = %+v", got)
+	}
+
+	// the remote report renders like a local one
+	var local, remote bytes.Buffer
+	Print(&local, r, 5, false)
+	Print(&remote, got, 5, false)
+	if local.String() != remote.String() {
+		t.Errorf("remote output differs:\n%s\nwant:\n%s", remote.String(), local.String())
+	}
+	if !strings.Contains(local.String(), "diskio: sdb usage: 92.7%") ||
+		!strings.Contains(local.String(), "Problem:  Disk IO usage on (sdb): 92.7%") {
+		t.Errorf("unexpected output:\n%s", local.String())
+	}
+
+	server.Close()
+	if _, err := NewClient(server.URL + DefaultPath).Fetch(); err == nil {
+		t.Errorf("Fetch() from closed server should fail")
+	}
+}
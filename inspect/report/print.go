@@ -0,0 +1,161 @@
+// Copyright (c) 2014 Square, Inc
+
+package report
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/mgutz/ansi"
+	"github.com/square/prodeng/inspect/misc"
+)
+
+// Print writes the command line view of r to w: totals, the top n
+// processes by cpu, memory and io, per CPU usage, disks, filesystems, interfaces,
+// cgroups, problems and incidents. Problems are colored if color
+// is set.
+func Print(w io.Writer, r *Report, n int, color bool) {
+	fmt.Fprintln(w, "--------------------------")
+	fmt.Fprintf(w,
+		"total: cpu: %3.1f%%, mem: %3.1f%% (%s/%s)\n",
+		r.CPU.Usage, r.Mem.UsagePct,
+		misc.ByteSize(r.Mem.Usage), misc.ByteSize(r.Mem.Total))
+	if r.Load != nil {
+		fmt.Fprintf(w, "load: %.2f %.2f %.2f (cpus: %d) running: %d blocked: %d\n",
+			r.Load.Load1, r.Load.Load5, r.Load.Load15, r.CPU.Count,
+			r.Load.Running, r.Load.Blocked)
+	}
+	if r.Pressure != nil {
+		fmt.Fprintf(w, "pressure: %s\n", r.Pressure)
+	}
+	if r.OS == "linux" {
+		fmt.Fprintf(w, "cpu: user: %3.1f%% kernel: %3.1f%% iowait: %3.1f%% steal: %3.1f%% irq: %3.1f%% softirq: %3.1f%% imbalance: %3.1f%%\n",
+			r.CPU.User, r.CPU.Kernel, r.CPU.IOWait, r.CPU.Steal,
+			r.CPU.IRQ, r.CPU.SoftIRQ, r.CPU.Imbalance)
+		fmt.Fprintf(w, "paging: swap in: %.1f/s out: %.1f/s major faults: %.1f/s direct reclaim: %.1f/s\n",
+			r.Mem.SwapIn, r.Mem.SwapOut, r.Mem.MajorFaults, r.Mem.DirectReclaim)
+	}
+
+	fmt.Fprintln(w, "Top processes by CPU usage:")
+	for _, p := range top(r.Processes, SortCPU, n) {
+		fmt.Fprintf(w, "cpu: %3.1f%%  command: %s user: %s pid: %v\n",
+			p.CPU, p.Comm, p.User, p.Pid)
+	}
+
+	fmt.Fprintln(w, "---")
+	fmt.Fprintln(w, "Top processes by Mem usage:")
+	for _, p := range top(r.Processes, SortMem, n) {
+		fmt.Fprintf(w, "mem: %s command: %s user: %s pid: %v\n",
+			misc.ByteSize(p.Mem), p.Comm, p.User, p.Pid)
+	}
+
+	// per process io, disks, interfaces and cgroups are only
+	// collected on linux
+	if r.OS == "linux" {
+		fmt.Fprintln(w, "---")
+		fmt.Fprintln(w, "Top processes by IO usage:")
+		for _, p := range top(r.Processes, SortIO, n) {
+			fmt.Fprintf(w, "io: %s/s command: %s user: %s pid: %v\n",
+				misc.ByteSize(p.IO), p.Comm, p.User, p.Pid)
+		}
+
+		fmt.Fprintln(w, "---")
+		for _, c := range r.CPU.PerCPU {
+			fmt.Fprintf(w, "%s\n", c)
+		}
+		for _, z := range r.Thermal {
+			fmt.Fprintf(w, "thermal: %s (%s) %.1fC\n", z.Name, z.Type, z.Temp)
+		}
+
+		fmt.Fprintln(w, "---")
+		for _, d := range r.Disks {
+			fmt.Fprintf(w, "diskio: %s usage: %3.1f%%\n", d.Name, d.Usage)
+		}
+		for _, f := range r.Filesystems {
+			fmt.Fprintf(w, "fs: %s usage: %3.1f%%\n", f.Name, f.Usage)
+		}
+
+		fmt.Fprintln(w, "---")
+		for _, i := range r.Interfaces {
+			fmt.Fprintf(w, "iface: %s TX: %3.1f%% (%s/s), RX: %3.1f%% (%s/s)\n",
+				i.Name,
+				i.TXUsage, misc.BitSize(i.TXBandwidth),
+				i.RXUsage, misc.BitSize(i.RXBandwidth))
+		}
+		if r.Net != nil {
+			fmt.Fprintf(w, "tcp: established: %.0f time_wait: %.0f orphans: %.0f mem: %s/%s retransmits: %3.1f%% listen overflows: %.1f/s\n",
+				r.Net.Established, r.Net.TimeWait, r.Net.Orphans,
+				misc.ByteSize(r.Net.TCPMem), misc.ByteSize(r.Net.TCPMemLimit),
+				r.Net.Retransmits, r.Net.ListenOverflows)
+		}
+		for _, p := range r.Ports {
+			out := fmt.Sprintf("port: %s:%d sockets: %d established: %d time_wait: %d ",
+				p.Proto, p.Port, p.Sockets, p.Established, p.TimeWait)
+			if p.Pid != "" {
+				out += fmt.Sprintf("listener: %s pid: %s", p.Comm, p.Pid)
+			}
+			fmt.Fprintln(w, out)
+		}
+		for _, p := range r.Remotes {
+			fmt.Fprintf(w, "remote: %s %s sockets: %d established: %d time_wait: %d\n",
+				p.Proto, p.Address, p.Sockets, p.Established, p.TimeWait)
+		}
+
+		fmt.Fprintln(w, "---")
+		for _, c := range r.Cgroups {
+			out := fmt.Sprintf("cgroup:%s ", c.Name)
+			out += fmt.Sprintf("cpu: %3.1f%% ", c.CPUUsage)
+			out += fmt.Sprintf("cpu_throttling: %3.1f%% (%.1f/%d) ",
+				c.CPUThrottle, c.CPUQuota, r.CPU.Count)
+			if c.MemLimit > 0 {
+				out += fmt.Sprintf("mem: %3.1f%% (%s/%s) ",
+					c.MemUsagePct(),
+					misc.ByteSize(c.MemUsage), misc.ByteSize(c.MemLimit))
+			}
+			if c.IORead > 0 || c.IOWrite > 0 {
+				out += fmt.Sprintf("io: read: %s/s write: %s/s ",
+					misc.ByteSize(c.IORead), misc.ByteSize(c.IOWrite))
+			}
+			if c.Pressure != nil {
+				out += fmt.Sprintf("pressure: %s ", c.Pressure)
+			}
+			fmt.Fprintln(w, out)
+		}
+
+		fmt.Fprintln(w, "---")
+	}
+
+	if len(r.Plugins) > 0 {
+		for _, p := range r.Plugins {
+			if p.Error != "" {
+				fmt.Fprintf(w, "plugin: %s failed: %s\n", p.Name, p.Error)
+			}
+			for _, m := range p.Metrics {
+				fmt.Fprintf(w, "plugin: %s %s: %g\n", p.Name, m.Name, m.Value)
+			}
+		}
+		fmt.Fprintln(w, "---")
+	}
+
+	for _, p := range r.Problems {
+		msg := p.Message
+		if color {
+			msg = ansi.Color(msg, "red")
+		}
+		fmt.Fprintln(w, "Problem: ", msg)
+	}
+
+	for _, i := range r.Incidents {
+		fmt.Fprintln(w, "Incident: ", i.Summary)
+	}
+}
+
+// Unexported functions
+
+func top(procs []*Process, key string, n int) []*Process {
+	procs = SortProcesses(procs, key)
+	if len(procs) > n {
+		procs = procs[:n]
+	}
+	return procs
+}
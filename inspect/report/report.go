@@ -0,0 +1,348 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package report holds a point in time view of the computed
+// statistics inspect shows: totals, processes, disks, interfaces,
+// cgroups and problems. It contains plain data only so it can be
+// rendered, serialized or sent over the wire.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/square/prodeng/inspect/rules"
+	"gopkg.in/yaml.v2"
+)
+
+type Report struct {
+	Time        time.Time        `json:"time" yaml:"time"`
+	Hostname    string           `json:"hostname" yaml:"hostname"`
+	OS          string           `json:"os" yaml:"os"` // runtime.GOOS of the collector
+	CPU         CPU              `json:"cpu" yaml:"cpu"`
+	Mem         Mem              `json:"mem" yaml:"mem"`
+	Load        *Load            `json:"load,omitempty" yaml:"load,omitempty"`         // linux only
+	Pressure    *Pressure        `json:"pressure,omitempty" yaml:"pressure,omitempty"` // nil without PSI
+	Processes   []*Process       `json:"processes,omitempty" yaml:"processes,omitempty"`
+	Top         *Top             `json:"top,omitempty" yaml:"top,omitempty"`
+	Disks       []*Disk          `json:"disks,omitempty" yaml:"disks,omitempty"`
+	Filesystems []*Filesystem    `json:"filesystems,omitempty" yaml:"filesystems,omitempty"`
+	Interfaces  []*Interface     `json:"interfaces,omitempty" yaml:"interfaces,omitempty"`
+	Net         *Net             `json:"net,omitempty" yaml:"net,omitempty"` // linux only
+	Ports       []*Port          `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Remotes     []*Remote        `json:"remotes,omitempty" yaml:"remotes,omitempty"`
+	Thermal     []*ThermalZone   `json:"thermal,omitempty" yaml:"thermal,omitempty"`
+	Cgroups     []*Cgroup        `json:"cgroups,omitempty" yaml:"cgroups,omitempty"`
+	Plugins     []*Plugin        `json:"plugins,omitempty" yaml:"plugins,omitempty"`
+	Problems    []*rules.Problem `json:"problems" yaml:"problems"`
+	Incidents   []*Incident      `json:"incidents,omitempty" yaml:"incidents,omitempty"`
+}
+
+// Top holds the processes using most CPU, memory and IO
+type Top struct {
+	CPU []*Process `json:"cpu" yaml:"cpu"`
+	Mem []*Process `json:"mem" yaml:"mem"`
+	IO  []*Process `json:"io" yaml:"io"`
+}
+
+type CPU struct {
+	Usage  float64 `json:"usage" yaml:"usage"`   // % of all CPUs
+	User   float64 `json:"user" yaml:"user"`     // % in userspace
+	Kernel float64 `json:"kernel" yaml:"kernel"` // % in kernel
+	Count  int     `json:"count" yaml:"count"`   // number of logical CPUs
+
+	// time breakdown and per CPU usage (linux only)
+	IOWait    float64    `json:"iowait" yaml:"iowait"`       // % idle with IO outstanding
+	Steal     float64    `json:"steal" yaml:"steal"`         // % taken by the hypervisor
+	IRQ       float64    `json:"irq" yaml:"irq"`             // % servicing interrupts
+	SoftIRQ   float64    `json:"softirq" yaml:"softirq"`     // % in softirqs
+	Imbalance float64    `json:"imbalance" yaml:"imbalance"` // busiest CPU usage - average of the others
+	PerCPU    []*CPUCore `json:"per_cpu,omitempty" yaml:"per_cpu,omitempty"`
+}
+
+// CPUCore is the usage of a single logical CPU in % of its time
+type CPUCore struct {
+	Name    string  `json:"name" yaml:"name"` // cpu0, cpu1, ...
+	Usage   float64 `json:"usage" yaml:"usage"`
+	User    float64 `json:"user" yaml:"user"`
+	Kernel  float64 `json:"kernel" yaml:"kernel"`
+	IOWait  float64 `json:"iowait" yaml:"iowait"`
+	Steal   float64 `json:"steal" yaml:"steal"`
+	IRQ     float64 `json:"irq" yaml:"irq"`
+	SoftIRQ float64 `json:"softirq" yaml:"softirq"`
+
+	// 0 without cpufreq, as on most virtual machines
+	Freq      float64 `json:"freq,omitempty" yaml:"freq,omitempty"`         // MHz
+	MaxFreq   float64 `json:"max_freq,omitempty" yaml:"max_freq,omitempty"` // MHz
+	Throttles float64 `json:"throttles" yaml:"throttles"`                   // thermal throttling events/sec
+
+	Interrupts float64 `json:"interrupts" yaml:"interrupts"`               // per second
+	SoftIRQs   float64 `json:"softirqs" yaml:"softirqs"`                   // per second
+	TopIRQ     string  `json:"top_irq,omitempty" yaml:"top_irq,omitempty"` // busiest irq and its devices
+}
+
+func (c *CPUCore) String() string {
+	out := fmt.Sprintf("%s: %3.1f%% (user %3.1f%%, kernel %3.1f%%, iowait %3.1f%%, steal %3.1f%%, irq %3.1f%%, softirq %3.1f%%)",
+		c.Name, c.Usage, c.User, c.Kernel, c.IOWait, c.Steal, c.IRQ, c.SoftIRQ)
+	if c.MaxFreq > 0 {
+		out += fmt.Sprintf(" freq: %.0f/%.0f MHz", c.Freq, c.MaxFreq)
+	}
+	if c.Throttles > 0 {
+		out += fmt.Sprintf(" throttled: %.1f/s", c.Throttles)
+	}
+	if c.Interrupts > 0 || c.SoftIRQs > 0 {
+		out += fmt.Sprintf(" interrupts: %.0f/s softirqs: %.0f/s", c.Interrupts, c.SoftIRQs)
+	}
+	if c.TopIRQ != "" {
+		out += " top irq: " + c.TopIRQ
+	}
+	return out
+}
+
+// ThermalZone is a temperature sensor of /sys/class/thermal
+type ThermalZone struct {
+	Name string  `json:"name" yaml:"name"` // thermal_zone0, ...
+	Type string  `json:"type" yaml:"type"` // x86_pkg_temp, acpitz, ...
+	Temp float64 `json:"temp" yaml:"temp"` // degrees Celsius
+}
+
+type Mem struct {
+	Usage    float64 `json:"usage" yaml:"usage"` // bytes
+	Total    float64 `json:"total" yaml:"total"` // bytes
+	UsagePct float64 `json:"usage_pct" yaml:"usage_pct"`
+
+	// paging and reclaim rates per second (linux only)
+	SwapIn        float64 `json:"swap_in" yaml:"swap_in"`   // pages
+	SwapOut       float64 `json:"swap_out" yaml:"swap_out"` // pages
+	MajorFaults   float64 `json:"major_faults" yaml:"major_faults"`
+	DirectReclaim float64 `json:"direct_reclaim" yaml:"direct_reclaim"` // pages scanned
+}
+
+// Load is the run queue: load averages and tasks running or
+// blocked on IO
+type Load struct {
+	Load1   float64 `json:"load1" yaml:"load1"`
+	Load5   float64 `json:"load5" yaml:"load5"`
+	Load15  float64 `json:"load15" yaml:"load15"`
+	Running int     `json:"running" yaml:"running"`
+	Blocked int     `json:"blocked" yaml:"blocked"`
+}
+
+// Pressure is the % of time tasks stalled on each resource over the
+// last 10 seconds (pressure stall information)
+type Pressure struct {
+	CPU    Stall `json:"cpu" yaml:"cpu"`
+	Memory Stall `json:"memory" yaml:"memory"`
+	IO     Stall `json:"io" yaml:"io"`
+}
+
+type Stall struct {
+	Some float64 `json:"some" yaml:"some"` // some tasks stalled
+	Full float64 `json:"full" yaml:"full"` // all non-idle tasks stalled
+}
+
+func (p *Pressure) String() string {
+	return fmt.Sprintf("cpu: %3.1f%%, mem: %3.1f%% (full %3.1f%%), io: %3.1f%% (full %3.1f%%)",
+		p.CPU.Some, p.Memory.Some, p.Memory.Full, p.IO.Some, p.IO.Full)
+}
+
+type Process struct {
+	Pid     string  `json:"pid" yaml:"pid"`
+	Comm    string  `json:"comm" yaml:"comm"`
+	User    string  `json:"user" yaml:"user"`
+	Cmdline string  `json:"cmdline,omitempty" yaml:"cmdline,omitempty"`
+	CPU     float64 `json:"cpu" yaml:"cpu"`       // % of one CPU
+	Mem     float64 `json:"mem" yaml:"mem"`       // resident bytes
+	IO      float64 `json:"io" yaml:"io"`         // bytes/sec read+written
+	Cgroup  string  `json:"cgroup" yaml:"cgroup"` // cpu cgroup
+
+	Connections int      `json:"connections,omitempty" yaml:"connections,omitempty"` // tcp
+	Listening   []string `json:"listening,omitempty" yaml:"listening,omitempty"`
+}
+
+type Disk struct {
+	Name  string  `json:"name" yaml:"name"`
+	Usage float64 `json:"usage" yaml:"usage"` // % of time busy
+}
+
+type Filesystem struct {
+	Name      string  `json:"name" yaml:"name"`             // mountpoint
+	Usage     float64 `json:"usage" yaml:"usage"`           // % of blocks used
+	FileUsage float64 `json:"file_usage" yaml:"file_usage"` // % of inodes used
+}
+
+type Interface struct {
+	Name        string  `json:"name" yaml:"name"`
+	TXUsage     float64 `json:"tx_usage" yaml:"tx_usage"`         // % of link speed
+	RXUsage     float64 `json:"rx_usage" yaml:"rx_usage"`         // % of link speed
+	TXBandwidth float64 `json:"tx_bandwidth" yaml:"tx_bandwidth"` // bits/sec
+	RXBandwidth float64 `json:"rx_bandwidth" yaml:"rx_bandwidth"` // bits/sec
+}
+
+// Net is the state of the TCP stack
+type Net struct {
+	Established     float64 `json:"established" yaml:"established"`
+	TimeWait        float64 `json:"time_wait" yaml:"time_wait"`
+	Orphans         float64 `json:"orphans" yaml:"orphans"`
+	TCPMem          float64 `json:"tcp_mem" yaml:"tcp_mem"`                   // bytes
+	TCPMemLimit     float64 `json:"tcp_mem_limit" yaml:"tcp_mem_limit"`       // bytes
+	Retransmits     float64 `json:"retransmits" yaml:"retransmits"`           // % of segments sent
+	ListenOverflows float64 `json:"listen_overflows" yaml:"listen_overflows"` // per second
+}
+
+// Port is a local port with the sockets bound to it
+type Port struct {
+	Proto       string `json:"proto" yaml:"proto"` // tcp or udp
+	Port        int    `json:"port" yaml:"port"`
+	Sockets     int    `json:"sockets" yaml:"sockets"`
+	Established int    `json:"established" yaml:"established"`
+	TimeWait    int    `json:"time_wait" yaml:"time_wait"`
+	Pid         string `json:"pid,omitempty" yaml:"pid,omitempty"` // listening process
+	Comm        string `json:"comm,omitempty" yaml:"comm,omitempty"`
+}
+
+// Remote is a remote address with the client sockets connected to it
+type Remote struct {
+	Proto       string `json:"proto" yaml:"proto"`     // tcp or udp
+	Address     string `json:"address" yaml:"address"` // host:port
+	Sockets     int    `json:"sockets" yaml:"sockets"`
+	Established int    `json:"established" yaml:"established"`
+	TimeWait    int    `json:"time_wait" yaml:"time_wait"`
+}
+
+// Plugin is an external plugin collector with the metrics of its
+// last successful run
+type Plugin struct {
+	Name    string          `json:"name" yaml:"name"`
+	Error   string          `json:"error,omitempty" yaml:"error,omitempty"` // of the last run
+	Metrics []*PluginMetric `json:"metrics" yaml:"metrics"`
+}
+
+type PluginMetric struct {
+	Name  string  `json:"name" yaml:"name"` // metric name including labels
+	Type  string  `json:"type" yaml:"type"`
+	Value float64 `json:"value" yaml:"value"` // gauge value, counter rate per second
+}
+
+type Cgroup struct {
+	Name        string    `json:"name" yaml:"name"`
+	CPUUsage    float64   `json:"cpu_usage" yaml:"cpu_usage"`       // % of one CPU used by tasks
+	CPUThrottle float64   `json:"cpu_throttle" yaml:"cpu_throttle"` // % of time throttled
+	CPUQuota    float64   `json:"cpu_quota" yaml:"cpu_quota"`       // logical CPUs allowed
+	MemUsage    float64   `json:"mem_usage" yaml:"mem_usage"`       // bytes
+	MemLimit    float64   `json:"mem_limit" yaml:"mem_limit"`       // bytes
+	IORead      float64   `json:"io_read" yaml:"io_read"`           // bytes/s
+	IOWrite     float64   `json:"io_write" yaml:"io_write"`         // bytes/s
+	Pressure    *Pressure `json:"pressure,omitempty" yaml:"pressure,omitempty"`
+}
+
+// MemUsagePct returns memory usage as percentage of the limit
+func (c *Cgroup) MemUsagePct() float64 {
+	return (c.MemUsage / c.MemLimit) * 100
+}
+
+// Incident is a problem with evidence of what caused it, gathered
+// when the problem started
+type Incident struct {
+	Problem  *rules.Problem `json:"problem" yaml:"problem"`
+	Kind     string         `json:"kind" yaml:"kind"` // cpu, io, memory or other
+	Started  time.Time      `json:"started" yaml:"started"`
+	Resolved *time.Time     `json:"resolved,omitempty" yaml:"resolved,omitempty"`
+	Summary  string         `json:"summary" yaml:"summary"`
+	Evidence *Evidence      `json:"evidence,omitempty" yaml:"evidence,omitempty"`
+}
+
+// Evidence lists the biggest consumers of the resource a problem
+// is about
+type Evidence struct {
+	Metric    string     `json:"metric" yaml:"metric"` // what culprits are ranked by
+	Processes []*Culprit `json:"processes,omitempty" yaml:"processes,omitempty"`
+	Cgroups   []*Culprit `json:"cgroups,omitempty" yaml:"cgroups,omitempty"`
+	Device    *Disk      `json:"device,omitempty" yaml:"device,omitempty"` // busiest disk
+}
+
+type Culprit struct {
+	Name  string  `json:"name" yaml:"name"` // command or cgroup name
+	Pid   string  `json:"pid,omitempty" yaml:"pid,omitempty"`
+	User  string  `json:"user,omitempty" yaml:"user,omitempty"`
+	Value float64 `json:"value" yaml:"value"`
+}
+
+// Sort keys for processes
+const (
+	SortCPU = "cpu"
+	SortMem = "mem"
+	SortIO  = "io"
+)
+
+// SortProcesses returns processes sorted by key (highest first)
+func SortProcesses(procs []*Process, key string) []*Process {
+	ret := make([]*Process, len(procs))
+	copy(ret, procs)
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		switch key {
+		case SortMem:
+			return a.Mem > b.Mem
+		case SortIO:
+			return a.IO > b.IO
+		}
+		return a.CPU > b.CPU
+	})
+	return ret
+}
+
+// SetTop fills r.Top with the n processes using most CPU, memory
+// and IO
+func (r *Report) SetTop(n int) {
+	top := func(key string) []*Process {
+		procs := SortProcesses(r.Processes, key)
+		if len(procs) > n {
+			procs = procs[:n]
+		}
+		return procs
+	}
+	r.Top = &Top{
+		CPU: top(SortCPU),
+		Mem: top(SortMem),
+		IO:  top(SortIO),
+	}
+}
+
+// Sort orders disks, filesystems, interfaces and cgroups by name
+func (r *Report) Sort() {
+	sort.Slice(r.Disks, func(i, j int) bool {
+		return r.Disks[i].Name < r.Disks[j].Name
+	})
+	sort.Slice(r.Filesystems, func(i, j int) bool {
+		return r.Filesystems[i].Name < r.Filesystems[j].Name
+	})
+	sort.Slice(r.Interfaces, func(i, j int) bool {
+		return r.Interfaces[i].Name < r.Interfaces[j].Name
+	})
+	sort.Slice(r.Cgroups, func(i, j int) bool {
+		return r.Cgroups[i].Name < r.Cgroups[j].Name
+	})
+}
+
+// Output formats
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// Marshal encodes r as json or yaml
+func Marshal(r *Report, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	case FormatYAML:
+		return yaml.Marshal(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
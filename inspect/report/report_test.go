@@ -0,0 +1,78 @@
+// Copyright (c) 2014 Square, Inc
+
+package report
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func testReport() *Report {
+	r := new(Report)
+	r.Hostname = "host1"
+	r.Processes = []*Process{
+		{Pid: "1", Comm: "(init)", CPU: 1, Mem: 300, IO: 0},
+		{Pid: "2", Comm: "(mysqld)", CPU: 50, Mem: 100, IO: 10},
+		{Pid: "3", Comm: "(fio)", CPU: 10, Mem: 200, IO: 1000},
+	}
+	return r
+}
+
+func TestSetTop(t *testing.T) {
+	r := testReport()
+	r.SetTop(2)
+
+	pids := func(procs []*Process) string {
+		var s []string
+		for _, p := range procs {
+			s = append(s, p.Pid)
+		}
+		return strings.Join(s, ",")
+	}
+	if got := pids(r.Top.CPU); got != "2,3" {
+		t.Errorf("top cpu = %s, want 2,3", got)
+	}
+	if got := pids(r.Top.Mem); got != "1,3" {
+		t.Errorf("top mem = %s, want 1,3", got)
+	}
+	if got := pids(r.Top.IO); got != "3,2" {
+		t.Errorf("top io = %s, want 3,2", got)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	r := testReport()
+	r.SetTop(1)
+	r.Processes = nil
+
+	b, err := Marshal(r, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var j map[string]interface{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		t.Fatal(err)
+	}
+	if j["hostname"] != "host1" || j["top"] == nil || j["processes"] != nil {
+		t.Errorf("unexpected json: %s", b)
+	}
+
+	b, err = Marshal(r, FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var y map[string]interface{}
+	if err := yaml.Unmarshal(b, &y); err != nil {
+		t.Fatal(err)
+	}
+	if y["hostname"] != "host1" || y["top"] == nil {
+		t.Errorf("unexpected yaml: %s", b)
+	}
+
+	if _, err := Marshal(r, "xml"); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
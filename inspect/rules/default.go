@@ -0,0 +1,200 @@
+// Copyright (c) 2014 Square, Inc
+
+package rules
+
+// DefaultRules are used when no rules file is supplied.
+// Value names are produced by inspect (see osmain):
+//
+//	cpu.usage                 - total CPU usage %
+//	cpu.<iowait|steal|irq|softirq> - % of CPU time idle waiting for
+//	                            IO, stolen by the hypervisor or spent
+//	                            in interrupts and softirqs
+//	cpu.imbalance             - usage % of the busiest CPU above the
+//	                            average of the others, 0 while they
+//	                            are mostly idle
+//	cpu.<n>.usage             - CPU usage % of CPU n
+//	cpu.<n>.softirq           - % of time CPU n spent in softirqs
+//	cpu.<n>.freq_pct          - clock of CPU n as % of its base
+//	                            frequency
+//	cpu.<n>.busy_freq_pct     - the same, only while CPU n is more
+//	                            than 50% busy
+//	cpu.<n>.throttles         - thermal throttling events per second
+//	thermal.<zone>.temp       - temperature of a thermal zone in C
+//	cpu.<n>.interrupts        - interrupts per second serviced by CPU n
+//	cpu.<n>.softirqs          - softirqs per second run on CPU n
+//	load.<1|5|15>             - load averages
+//	load.per_cpu              - 1 minute load average per CPU
+//	mem.usage_pct             - memory usage %
+//	vm.swap_in, vm.swap_out   - pages swapped per second
+//	vm.major_faults           - page faults needing IO per second
+//	vm.direct_reclaim         - pages scanned by direct reclaim per second
+//	vm.alloc_stalls           - allocations stalled in direct reclaim per second
+//	vm.compact_stalls         - allocations stalled in compaction per second
+//	vm.oom_kills              - OOM kills per second
+//	disk.<dev>.usage          - % of time disk was busy
+//	fs.<mountpoint>.usage     - % of filesystem blocks used
+//	iface.<dev>.tx_usage      - % of link speed used for transmit
+//	iface.<dev>.rx_usage      - % of link speed used for receive
+//	net.tcp.retransmits       - % of TCP segments retransmitted
+//	net.tcp.listen_overflows  - connections dropped per second for a
+//	                            full accept queue
+//	net.tcp.mem_usage         - TCP memory % of the tcp_mem maximum
+//	net.tcp.mem_aborts        - connections reset per second for lack
+//	                            of TCP memory
+//	net.tcp.orphan_usage      - orphaned sockets % of tcp_max_orphans
+//	net.tcp.timewait_usage    - TIME_WAIT sockets % of tcp_max_tw_buckets
+//	cgroup.<name>.cpu_throttle - % of time cgroup was throttled
+//	psi.<res>.some            - % of time some tasks stalled on cpu,
+//	                            memory or io (10s average)
+//	psi.<res>.full            - % of time all tasks stalled
+//	cgroup.<name>.cpu_pressure - psi cpu some of the cgroup
+//	cgroup.<name>.mem_pressure - psi memory full of the cgroup
+//	cgroup.<name>.io_pressure  - psi io full of the cgroup
+//
+// as well as every metric in the metric context by its registered
+// name (gauges by value, counters by rate per second).
+const DefaultRules = `
+- name: cpu_usage
+  select: cpu.usage
+  op: ">"
+  threshold: 80
+  message: CPU usage > 80%
+
+- name: cpu_softirq
+  select: cpu.*.softirq
+  op: ">"
+  threshold: 50
+  message: 'Softirqs on CPU {{.Match}}: {{printf "%3.1f" .Value}}% of the time'
+
+- name: cpu_imbalance
+  select: cpu.imbalance
+  op: ">"
+  threshold: 50
+  message: 'CPU imbalance: busiest CPU {{printf "%3.1f" .Value}}% above the others'
+
+- name: cpu_frequency
+  select: cpu.*.busy_freq_pct
+  op: "<"
+  threshold: 60
+  message: 'CPU {{.Match}} busy at {{printf "%.0f" .Value}}% of its base frequency'
+
+- name: mem_usage
+  select: mem.usage_pct
+  op: ">"
+  threshold: 80
+  message: Memory usage > 80%
+
+- name: swapping
+  select: vm.swap_*
+  op: ">"
+  threshold: 100
+  message: 'Host is swapping ({{.Match}}): {{printf "%.0f" .Value}} pages/s'
+
+- name: direct_reclaim
+  select: vm.alloc_stalls
+  op: ">"
+  threshold: 1
+  message: 'Direct reclaim stalls: {{printf "%.1f" .Value}} allocations/s'
+
+- name: oom_kill
+  select: vm.oom_kills
+  op: ">"
+  threshold: 0
+  message: OOM killer invoked
+
+- name: disk_io
+  select: disk.*.usage
+  op: ">"
+  threshold: 75
+  message: 'Disk IO usage on ({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: tx_bandwidth
+  select: iface.*.tx_usage
+  op: ">"
+  threshold: 75
+  message: 'TX bandwidth usage on ({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: rx_bandwidth
+  select: iface.*.rx_usage
+  op: ">"
+  threshold: 75
+  message: 'RX bandwidth usage on ({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: tcp_retransmits
+  select: net.tcp.retransmits
+  op: ">"
+  threshold: 5
+  message: 'TCP retransmits: {{printf "%3.1f" .Value}}% of segments sent'
+
+- name: tcp_listen_overflows
+  select: net.tcp.listen_overflows
+  op: ">"
+  threshold: 0
+  message: 'TCP accept queues overflowing: {{printf "%.1f" .Value}} connections/s dropped'
+
+- name: tcp_memory
+  select: net.tcp.mem_usage
+  op: ">"
+  threshold: 90
+  message: 'TCP memory usage: {{printf "%3.1f" .Value}}% of tcp_mem'
+
+- name: tcp_memory_aborts
+  select: net.tcp.mem_aborts
+  op: ">"
+  threshold: 0
+  message: 'TCP out of memory: {{printf "%.1f" .Value}} connections/s reset'
+
+- name: tcp_orphans
+  select: net.tcp.orphan_usage
+  op: ">"
+  threshold: 80
+  message: 'Orphaned TCP sockets: {{printf "%3.1f" .Value}}% of tcp_max_orphans'
+
+- name: tcp_timewait
+  select: net.tcp.timewait_usage
+  op: ">"
+  threshold: 80
+  message: 'TIME_WAIT sockets: {{printf "%3.1f" .Value}}% of tcp_max_tw_buckets'
+
+- name: cgroup_cpu_throttling
+  select: cgroup.*.cpu_throttle
+  op: ">"
+  threshold: 0.5
+  message: 'CPU throttling on cgroup({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: cpu_pressure
+  select: psi.cpu.some
+  op: ">"
+  threshold: 40
+  message: 'CPU pressure: tasks waiting for CPU {{printf "%3.1f" .Value}}% of the time'
+
+- name: mem_pressure
+  select: psi.memory.full
+  op: ">"
+  threshold: 5
+  message: 'Memory pressure: all tasks stalled on memory {{printf "%3.1f" .Value}}% of the time'
+
+- name: io_pressure
+  select: psi.io.full
+  op: ">"
+  threshold: 10
+  message: 'IO pressure: all tasks stalled on IO {{printf "%3.1f" .Value}}% of the time'
+
+- name: cgroup_cpu_pressure
+  select: cgroup.*.cpu_pressure
+  op: ">"
+  threshold: 40
+  message: 'CPU pressure on cgroup({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: cgroup_mem_pressure
+  select: cgroup.*.mem_pressure
+  op: ">"
+  threshold: 5
+  message: 'Memory pressure on cgroup({{.Match}}): {{printf "%3.1f" .Value}}%'
+
+- name: cgroup_io_pressure
+  select: cgroup.*.io_pressure
+  op: ">"
+  threshold: 10
+  message: 'IO pressure on cgroup({{.Match}}): {{printf "%3.1f" .Value}}%'
+`
@@ -0,0 +1,281 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package rules evaluates user supplied rules against values
+// gathered by inspect to detect problems.
+//
+// A rules file (YAML or JSON) is a list of rules:
+//
+//   - name: disk_io
+//     select: "disk.*.usage"     # glob over value names
+//     op: ">"                    # one of > >= < <= == !=
+//     threshold: 75
+//     for: 30s                   # condition must hold this long
+//     severity: warning          # warning or critical
+//     message: 'Disk IO usage on ({{.Match}}): {{printf "%3.1f" .Value}}%'
+//
+// Messages are text/template strings with the fields Rule, Name,
+// Value, Threshold, Match (the part of Name matched by the first
+// wildcard) and Matches (all wildcard matches).
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+type Rule struct {
+	Name      string  `yaml:"name" json:"name"`
+	Select    string  `yaml:"select" json:"select"`
+	Op        string  `yaml:"op" json:"op"`
+	Threshold float64 `yaml:"threshold" json:"threshold"`
+	For       string  `yaml:"for" json:"for"`
+	Severity  string  `yaml:"severity" json:"severity"`
+	Message   string  `yaml:"message" json:"message"`
+	re        *regexp.Regexp
+	tmpl      *template.Template
+	forDur    time.Duration
+}
+
+// Problem is a rule condition that holds for a value
+type Problem struct {
+	Rule      string    `json:"rule" yaml:"rule"`
+	Name      string    `json:"name" yaml:"name"`
+	Value     float64   `json:"value" yaml:"value"`
+	Threshold float64   `json:"threshold" yaml:"threshold"`
+	Severity  string    `json:"severity" yaml:"severity"`
+	Message   string    `json:"message" yaml:"message"`
+	Since     time.Time `json:"since" yaml:"since"`   // condition first seen
+	Firing    bool      `json:"firing" yaml:"firing"` // condition held for the rule's "for" duration
+}
+
+type Engine struct {
+	Rules    []*Rule
+	problems map[string]*Problem // keyed by rule name + value name
+	mu       sync.RWMutex
+}
+
+// Load reads rules from a YAML or JSON file
+func Load(path string) ([]*Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads rules from YAML or JSON data
+func Parse(data []byte) ([]*Rule, error) {
+	var rules []*Rule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// Default returns the built-in rules used when no rules file is given
+func Default() []*Rule {
+	rules, err := Parse([]byte(DefaultRules))
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+func NewEngine(rules []*Rule) *Engine {
+	e := new(Engine)
+	e.Rules = rules
+	e.problems = make(map[string]*Problem)
+	return e
+}
+
+// Evaluate checks all rules against values. It returns problems
+// that are firing, sorted by severity and message.
+func (e *Engine) Evaluate(values map[string]float64, now time.Time) []*Problem {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for _, r := range e.Rules {
+		for name, v := range values {
+			m := r.re.FindStringSubmatch(name)
+			if m == nil || math.IsNaN(v) || !r.holds(v) {
+				continue
+			}
+
+			key := r.Name + "\x00" + name
+			seen[key] = true
+			p, ok := e.problems[key]
+			if !ok {
+				p = &Problem{Rule: r.Name, Name: name, Since: now}
+				e.problems[key] = p
+			}
+			p.Value = v
+			p.Threshold = r.Threshold
+			p.Severity = r.Severity
+			p.Message = r.message(name, v, m[1:])
+			p.Firing = now.Sub(p.Since) >= r.forDur
+		}
+	}
+
+	// conditions which no longer hold are resolved
+	for key := range e.problems {
+		if !seen[key] {
+			delete(e.problems, key)
+		}
+	}
+
+	return e.firing()
+}
+
+// Problems returns problems found by the last Evaluate
+func (e *Engine) Problems() []*Problem {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.firing()
+}
+
+// MetricValues adds all metrics in m to values: gauges with their
+// value, counters with their rate per second
+func MetricValues(m *metrics.MetricContext, values map[string]float64) {
+	// collectors register metrics concurrently; read a copy
+	snap := m.Snapshot()
+	for _, g := range snap.Gauges {
+		values[g.Name] = g.Value
+	}
+	for _, c := range snap.Counters {
+		values[c.Name] = c.Rate
+	}
+	for _, c := range snap.BasicCounters {
+		values[c.Name] = float64(c.Value)
+	}
+}
+
+// Unexported functions
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return errors.New("rule without a name")
+	}
+	var err error
+	r.re, err = misc.GlobToRegexp(r.Select)
+	if err != nil {
+		return fmt.Errorf("rule %s: %v", r.Name, err)
+	}
+
+	switch r.Op {
+	case "":
+		r.Op = ">"
+	case ">", ">=", "<", "<=", "==", "!=":
+	default:
+		return fmt.Errorf("rule %s: unknown op %q", r.Name, r.Op)
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("rule %s: unknown severity %q", r.Name, r.Severity)
+	}
+
+	if r.For != "" {
+		r.forDur, err = time.ParseDuration(r.For)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+	}
+
+	msg := r.Message
+	if msg == "" {
+		msg = `{{.Name}} {{.Op}} {{.Threshold}}: {{printf "%.2f" .Value}}`
+	}
+	r.tmpl, err = template.New(r.Name).Parse(msg)
+	if err != nil {
+		return fmt.Errorf("rule %s: %v", r.Name, err)
+	}
+	return nil
+}
+
+func (r *Rule) holds(v float64) bool {
+	switch r.Op {
+	case ">":
+		return v > r.Threshold
+	case ">=":
+		return v >= r.Threshold
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case "==":
+		return v == r.Threshold
+	case "!=":
+		return v != r.Threshold
+	}
+	return false
+}
+
+func (r *Rule) message(name string, v float64, matches []string) string {
+	data := struct {
+		Rule      string
+		Name      string
+		Op        string
+		Value     float64
+		Threshold float64
+		Match     string
+		Matches   []string
+	}{r.Name, name, r.Op, v, r.Threshold, "", matches}
+	if len(matches) > 0 {
+		data.Match = matches[0]
+	}
+
+	var b bytes.Buffer
+	if err := r.tmpl.Execute(&b, data); err != nil {
+		return fmt.Sprintf("%s: %s = %.2f (%v)", r.Name, name, v, err)
+	}
+	return b.String()
+}
+
+func (e *Engine) firing() []*Problem {
+	ret := make([]*Problem, 0)
+	for _, p := range e.problems {
+		if p.Firing {
+			c := *p
+			ret = append(ret, &c)
+		}
+	}
+	sort.Sort(bySeverity(ret))
+	return ret
+}
+
+type bySeverity []*Problem
+
+func (a bySeverity) Len() int      { return len(a) }
+func (a bySeverity) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a bySeverity) Less(i, j int) bool {
+	if a[i].Severity != a[j].Severity {
+		return a[i].Severity == SeverityCritical
+	}
+	return a[i].Message < a[j].Message
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2014 Square, Inc
+
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaultRules(t *testing.T) {
+	e := NewEngine(Default())
+	values := map[string]float64{
+		"cpu.usage":                 95,
+		"cpu.3.softirq":             72.5,
+		"cpu.3.softirqs":            90000,
+		"cpu.imbalance":             20,
+		"cpu.5.busy_freq_pct":       33.3,
+		"cpu.5.freq_pct":            33.3,
+		"mem.usage_pct":             10,
+		"disk.sdb.usage":            92.7,
+		"disk.sda.usage":            1,
+		"cgroup.small.cpu_throttle": 79.6,
+		"psi.io.full":               23.5,
+		"psi.memory.full":           0.2,
+		"vm.swap_in":                350,
+		"vm.swap_out":               20,
+		"net.tcp.mem_usage":         96.5,
+	}
+	p := e.Evaluate(values, time.Now())
+
+	want := []string{
+		"CPU 5 busy at 33% of its base frequency",
+		"CPU throttling on cgroup(small): 79.6%",
+		"CPU usage > 80%",
+		"Disk IO usage on (sdb): 92.7%",
+		"Host is swapping (in): 350 pages/s",
+		"IO pressure: all tasks stalled on IO 23.5% of the time",
+		"Softirqs on CPU 3: 72.5% of the time",
+		"TCP memory usage: 96.5% of tcp_mem",
+	}
+	if len(p) != len(want) {
+		t.Fatalf("got %d problems, want %d: %v", len(p), len(want), p)
+	}
+	for i := range want {
+		if p[i].Message != want[i] {
+			t.Errorf("problem %d = %q, want %q", i, p[i].Message, want[i])
+		}
+	}
+}
+
+func TestForDuration(t *testing.T) {
+	rules, err := Parse([]byte(`[{"name": "load", "select": "load.*",
+		"op": ">=", "threshold": 4, "for": "10s", "severity": "critical"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEngine(rules)
+	now := time.Now()
+	v := map[string]float64{"load.1": 5}
+
+	if p := e.Evaluate(v, now); len(p) != 0 {
+		t.Errorf("fired before for duration: %v", p)
+	}
+	p := e.Evaluate(v, now.Add(10*time.Second))
+	if len(p) != 1 || p[0].Severity != SeverityCritical || !p[0].Since.Equal(now) {
+		t.Fatalf("expected one critical problem since %v: %v", now, p)
+	}
+
+	// resolving resets the for duration
+	v["load.1"] = 1
+	e.Evaluate(v, now.Add(11*time.Second))
+	v["load.1"] = 5
+	if p := e.Evaluate(v, now.Add(12*time.Second)); len(p) != 0 {
+		t.Errorf("fired right after resolving: %v", p)
+	}
+	if p := e.Problems(); len(p) != 0 {
+		t.Errorf("Problems() = %v, want none", p)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	bad := []string{
+		`[{"select": "x"}]`,
+		`[{"name": "a", "select": "x", "op": "~"}]`,
+		`[{"name": "a", "select": "x", "for": "soon"}]`,
+		`[{"name": "a", "select": "x", "severity": "meh"}]`,
+		`[{"name": "a", "select": "x", "message": "{{.Nope"}]`,
+	}
+	for _, b := range bad {
+		if _, err := Parse([]byte(b)); err == nil {
+			t.Errorf("expected error parsing %s", b)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package socketstat reads the socket tables of /proc/net/{tcp,udp}{,6},
+// counts sockets by state, by local port for servers and by remote
+// address for clients, and attributes them to the processes holding
+// them via /proc/<pid>/fd.
+//
+// Only sockets of inspect's network namespace are seen; TIME_WAIT
+// sockets belong to the kernel, not a process, and are attributed
+// through the port they are bound to or the address they connected to.
+package socketstat
+
+import (
+	"bufio"
+	"encoding/hex"
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Protocols are the socket tables read
+var Protocols = []string{"tcp", "tcp6", "udp", "udp6"}
+
+// FD_INTERVAL is how often the fds of all processes are read to
+// attribute sockets; sockets opened since are attributed on the
+// next read
+const FD_INTERVAL = time.Minute
+
+// States names the TCP states of the socket tables
+// (include/net/tcp_states.h)
+var States = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+// Socket is an entry of a socket table
+type Socket struct {
+	Proto      string // tcp, tcp6, udp or udp6
+	State      string // see States
+	IP         net.IP // local address
+	Port       int
+	RemoteIP   net.IP
+	RemotePort int
+	Inode      string // "0" for sockets without a file (TIME_WAIT)
+}
+
+func (s *Socket) String() string {
+	return s.Proto + " " + net.JoinHostPort(s.IP.String(), strconv.Itoa(s.Port))
+}
+
+// Listening returns whether s is a listening tcp or unconnected udp
+// socket
+func (s *Socket) Listening() bool {
+	if strings.HasPrefix(s.Proto, "udp") {
+		return s.State == "CLOSE"
+	}
+	return s.State == "LISTEN"
+}
+
+// family returns the protocol without the address family
+func (s *Socket) family() string {
+	return strings.TrimSuffix(s.Proto, "6")
+}
+
+type SocketStat struct {
+	Metrics  *SocketStatMetrics
+	Schedule *misc.Schedule
+	m        *metrics.MetricContext
+	states   map[string]*metrics.Gauge
+	mu       sync.RWMutex
+	ports    map[string]*PortStat
+	remotes  map[string]*RemoteStat
+	procs    map[string]*ProcessSockets
+	owners   map[string][]string // pids by socket inode
+	fdread   time.Time           // when owners was last read
+}
+
+// tcp and tcp6 sockets by state; udp and udp6 sockets
+type SocketStatMetrics struct {
+	Established *metrics.Gauge
+	Syn_sent    *metrics.Gauge
+	Syn_recv    *metrics.Gauge
+	Fin_wait1   *metrics.Gauge
+	Fin_wait2   *metrics.Gauge
+	Time_wait   *metrics.Gauge
+	Close       *metrics.Gauge
+	Close_wait  *metrics.Gauge
+	Last_ack    *metrics.Gauge
+	Listen      *metrics.Gauge
+	Closing     *metrics.Gauge
+	Udp         *metrics.Gauge
+}
+
+// PortStat counts sockets bound to a local port
+type PortStat struct {
+	Proto   string         // tcp or udp; v4 and v6 combined
+	Port    int            // local port
+	Sockets int            // all sockets
+	States  map[string]int // tcp sockets by state
+	Pids    []string       // processes listening on the port
+}
+
+// RemoteStat counts client sockets connected to a remote address
+type RemoteStat struct {
+	Proto   string         // tcp or udp; v4 and v6 combined
+	IP      net.IP         // remote address
+	Port    int            // remote port
+	Sockets int            // all sockets
+	States  map[string]int // tcp sockets by state
+}
+
+func (r *RemoteStat) String() string {
+	return r.Proto + " " + r.Addr()
+}
+
+// Addr returns the remote address as host:port
+func (r *RemoteStat) Addr() string {
+	return net.JoinHostPort(r.IP.String(), strconv.Itoa(r.Port))
+}
+
+// ProcessSockets are the sockets a process holds
+type ProcessSockets struct {
+	Connections map[string]int // tcp sockets by state, listening excluded
+	Listening   []*Socket
+}
+
+// Total returns the number of tcp connections
+func (p *ProcessSockets) Total() int {
+	n := 0
+	for _, c := range p.Connections {
+		n += c
+	}
+	return n
+}
+
+func New(m *metrics.MetricContext, Step time.Duration) *SocketStat {
+	s := new(SocketStat)
+	s.m = m
+	s.Metrics = new(SocketStatMetrics)
+	misc.InitializeMetrics(s.Metrics, m, "socketstat", true)
+
+	o := s.Metrics
+	s.states = map[string]*metrics.Gauge{
+		"ESTABLISHED": o.Established,
+		"SYN_SENT":    o.Syn_sent,
+		"SYN_RECV":    o.Syn_recv,
+		"FIN_WAIT1":   o.Fin_wait1,
+		"FIN_WAIT2":   o.Fin_wait2,
+		"TIME_WAIT":   o.Time_wait,
+		"CLOSE":       o.Close,
+		"CLOSE_WAIT":  o.Close_wait,
+		"LAST_ACK":    o.Last_ack,
+		"LISTEN":      o.Listen,
+		"CLOSING":     o.Closing,
+	}
+	s.ports = make(map[string]*PortStat)
+	s.remotes = make(map[string]*RemoteStat)
+	s.procs = make(map[string]*ProcessSockets)
+
+	s.Schedule = misc.NewSchedule("socketstat", Step, s.Collect)
+	return s
+}
+
+func (s *SocketStat) Collect() {
+	var socks []*Socket
+	for _, proto := range Protocols {
+		t, err := ReadSockets(misc.ProcPath("net", proto), proto)
+		if err != nil && proto == "tcp" {
+			misc.CollectorFailed("socketstat", err)
+			return
+		}
+		socks = append(socks, t...)
+	}
+
+	// sockets on a local port something listens on are the server
+	// side; the others are clients, whose local ports are ephemeral
+	listening := make(map[string]bool)
+	for _, sock := range socks {
+		if sock.Listening() {
+			listening[sock.family()+":"+strconv.Itoa(sock.Port)] = true
+		}
+	}
+
+	states := make(map[string]float64, len(s.states))
+	var udp float64
+	ports := make(map[string]*PortStat)
+	remotes := make(map[string]*RemoteStat)
+	for _, sock := range socks {
+		var sockets *int
+		var byState map[string]int
+		key := sock.family() + ":" + strconv.Itoa(sock.Port)
+		if listening[key] {
+			p, ok := ports[key]
+			if !ok {
+				p = &PortStat{Proto: sock.family(), Port: sock.Port, States: make(map[string]int)}
+				ports[key] = p
+			}
+			sockets, byState = &p.Sockets, p.States
+		} else {
+			key = sock.family() + " " +
+				net.JoinHostPort(sock.RemoteIP.String(), strconv.Itoa(sock.RemotePort))
+			r, ok := remotes[key]
+			if !ok {
+				r = &RemoteStat{Proto: sock.family(), IP: sock.RemoteIP,
+					Port: sock.RemotePort, States: make(map[string]int)}
+				remotes[key] = r
+			}
+			sockets, byState = &r.Sockets, r.States
+		}
+		*sockets++
+
+		if sock.family() == "udp" {
+			udp++
+		} else {
+			states[sock.State]++
+			byState[sock.State]++
+		}
+	}
+	for state, g := range s.states {
+		g.Set(states[state])
+	}
+	s.Metrics.Udp.Set(udp)
+
+	// attribute sockets to processes by their fds
+	if s.owners == nil || time.Since(s.fdread) >= FD_INTERVAL {
+		s.owners = socketOwners()
+		s.fdread = time.Now()
+	}
+	procs := make(map[string]*ProcessSockets)
+	for _, sock := range socks {
+		for _, pid := range s.owners[sock.Inode] {
+			ps, ok := procs[pid]
+			if !ok {
+				ps = &ProcessSockets{Connections: make(map[string]int)}
+				procs[pid] = ps
+			}
+			if sock.Listening() {
+				ps.Listening = append(ps.Listening, sock)
+				p := ports[sock.family()+":"+strconv.Itoa(sock.Port)]
+				if !contains(p.Pids, pid) {
+					p.Pids = append(p.Pids, pid)
+				}
+			} else if sock.family() == "tcp" {
+				ps.Connections[sock.State]++
+			}
+		}
+	}
+
+	for _, p := range ports {
+		sort.Strings(p.Pids)
+	}
+	for _, ps := range procs {
+		sort.Slice(ps.Listening, func(i, j int) bool {
+			a, b := ps.Listening[i], ps.Listening[j]
+			if a.Port != b.Port {
+				return a.Port < b.Port
+			}
+			return a.Proto < b.Proto
+		})
+	}
+
+	s.mu.Lock()
+	s.ports = ports
+	s.remotes = remotes
+	s.procs = procs
+	s.mu.Unlock()
+	misc.CollectorSucceeded("socketstat")
+}
+
+// Ports returns the n local ports with most sockets, most first
+func (s *SocketStat) Ports(n int) []*PortStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make([]*PortStat, 0, len(s.ports))
+	for _, p := range s.ports {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Sockets != ret[j].Sockets {
+			return ret[i].Sockets > ret[j].Sockets
+		}
+		return ret[i].Port < ret[j].Port
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// Remotes returns the n remote addresses clients hold most sockets
+// to, most first
+func (s *SocketStat) Remotes(n int) []*RemoteStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ret := make([]*RemoteStat, 0, len(s.remotes))
+	for _, r := range s.remotes {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Sockets != ret[j].Sockets {
+			return ret[i].Sockets > ret[j].Sockets
+		}
+		return ret[i].String() < ret[j].String()
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// Port returns the sockets bound to a local port; nil if there
+// are none
+func (s *SocketStat) Port(proto string, port int) *PortStat {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ports[proto+":"+strconv.Itoa(port)]
+}
+
+// Process returns the sockets of pid; nil if it has none
+func (s *SocketStat) Process(pid string) *ProcessSockets {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.procs[pid]
+}
+
+// ReadSockets parses a socket table such as /proc/net/tcp:
+//
+//	sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+//	 0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   106        0 12345
+func ReadSockets(path string, proto string) ([]*Socket, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ret []*Socket
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 10 {
+			continue
+		}
+		ip, port, err := ParseAddr(f[1])
+		if err != nil {
+			continue
+		}
+		rip, rport, err := ParseAddr(f[2])
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &Socket{
+			Proto:      proto,
+			State:      States[f[3]],
+			IP:         ip,
+			Port:       port,
+			RemoteIP:   rip,
+			RemotePort: rport,
+			Inode:      f[9],
+		})
+	}
+	return ret, scanner.Err()
+}
+
+// ParseAddr parses "0100007F:0050" style addresses. The address is
+// a sequence of 32 bit words in host (little endian) byte order.
+func ParseAddr(s string) (net.IP, int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return nil, 0, strconv.ErrSyntax
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || len(b)%4 != 0 {
+		return nil, 0, strconv.ErrSyntax
+	}
+	for w := 0; w < len(b); w += 4 {
+		b[w], b[w+1], b[w+2], b[w+3] = b[w+3], b[w+2], b[w+1], b[w]
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, err
+	}
+	return net.IP(b), int(port), nil
+}
+
+// SocketInodes returns the inodes of sockets a process has open;
+// dir is its /proc/<pid>
+func SocketInodes(dir string) map[string]bool {
+	inodes := make(map[string]bool)
+	fds, err := ioutil.ReadDir(filepath.Join(dir, "fd"))
+	if err != nil {
+		return inodes
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+		if err == nil && strings.HasPrefix(link, "socket:[") {
+			inodes[strings.TrimSuffix(link[len("socket:["):], "]")] = true
+		}
+	}
+	return inodes
+}
+
+// Unexported functions
+
+// socketOwners returns the pids holding each socket inode
+func socketOwners() map[string][]string {
+	owners := make(map[string][]string)
+	pids, _ := ioutil.ReadDir(misc.ProcPath())
+	for _, f := range pids {
+		pid := f.Name()
+		if _, err := strconv.Atoi(pid); err != nil || !f.IsDir() {
+			continue
+		}
+		for inode := range SocketInodes(misc.ProcPath(pid)) {
+			owners[inode] = append(owners[inode], pid)
+		}
+	}
+	return owners
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
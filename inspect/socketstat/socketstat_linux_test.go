@@ -0,0 +1,108 @@
+// Copyright (c) 2014 Square, Inc
+
+package socketstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/metrics"
+)
+
+func TestCollect(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	// tcp and tcp6 combined
+	o := s.Metrics
+	if o.Listen.Get() != 2 || o.Established.Get() != 2 ||
+		o.Time_wait.Get() != 5 || o.Udp.Get() != 1 {
+		t.Errorf("unexpected states listen %v established %v time_wait %v udp %v",
+			o.Listen.Get(), o.Established.Get(), o.Time_wait.Get(), o.Udp.Get())
+	}
+
+	// TIME_WAIT sockets are attributed through the listening port
+	top := s.Ports(1)
+	if len(top) != 1 || top[0].Proto != "tcp" || top[0].Port != 8080 {
+		t.Fatalf("Ports(1) = %+v, want tcp:8080", top)
+	}
+	p := top[0]
+	if p.Sockets != 5 || p.States["TIME_WAIT"] != 2 ||
+		len(p.Pids) != 1 || p.Pids[0] != "100" {
+		t.Errorf("unexpected port 8080 %+v", p)
+	}
+	if p := s.Port("udp", 53); p == nil || len(p.Pids) != 1 || p.Pids[0] != "200" {
+		t.Errorf("udp:53 not owned by 200: %+v", p)
+	}
+
+	ps := s.Process("100")
+	if ps == nil || ps.Total() != 1 || len(ps.Listening) != 2 {
+		t.Fatalf("unexpected sockets of 100 %+v", ps)
+	}
+	if ps.Listening[0].String() != "tcp 0.0.0.0:8080" ||
+		ps.Listening[1].String() != "tcp6 [::]:8080" {
+		t.Errorf("unexpected listening sockets %v %v", ps.Listening[0], ps.Listening[1])
+	}
+	if ps := s.Process("200"); ps == nil || ps.Connections["ESTABLISHED"] != 1 {
+		t.Errorf("unexpected sockets of 200 %+v", ps)
+	}
+}
+
+// client side TIME_WAIT sockets are on ephemeral ports; they are
+// grouped by the address they connected to
+func TestClientTimeWait(t *testing.T) {
+	misc.SetTestFS(t, misc.FS{Proc: "testdata/proc"})
+
+	s := New(metrics.NewMetricContext("test"), time.Hour)
+	s.Collect()
+
+	top := s.Remotes(2)
+	if len(top) != 2 {
+		t.Fatalf("Remotes(2) = %+v, want 2", top)
+	}
+	r := top[0]
+	if r.String() != "tcp 10.0.0.2:3306" || r.Sockets != 3 || r.States["TIME_WAIT"] != 3 {
+		t.Errorf("unexpected remote %v %+v", r, r)
+	}
+	// the client of the local server
+	if r := top[1]; r.String() != "tcp 127.0.0.1:8080" || r.States["ESTABLISHED"] != 1 {
+		t.Errorf("unexpected remote %v %+v", r, r)
+	}
+
+	for _, port := range []int{0xC350, 0xC351, 0xC352, 0xD431} {
+		if p := s.Port("tcp", port); p != nil {
+			t.Errorf("client port %d counted as a server: %+v", port, p)
+		}
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		ip   string
+		port int
+	}{
+		{"0100007F:0050", "127.0.0.1", 80},
+		{"00000000:0CEA", "0.0.0.0", 3306},
+		{"00000000000000000000000001000000:1F90", "::1", 8080},
+		{"0000000000000000FFFF00000100007F:0016", "127.0.0.1", 22},
+	}
+	for _, tt := range tests {
+		ip, port, err := ParseAddr(tt.in)
+		if err != nil {
+			t.Errorf("ParseAddr(%q): %v", tt.in, err)
+			continue
+		}
+		if ip.String() != tt.ip || port != tt.port {
+			t.Errorf("ParseAddr(%q) = %s %d, want %s %d",
+				tt.in, ip, port, tt.ip, tt.port)
+		}
+	}
+
+	if _, _, err := ParseAddr("zz"); err == nil {
+		t.Errorf("ParseAddr(zz) should fail")
+	}
+}
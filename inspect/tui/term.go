@@ -0,0 +1,63 @@
+// Copyright (c) 2014 Square, Inc
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// terminal handling using plain termios ioctls so the UI works on any
+// terminal without curses
+
+type termState struct {
+	termios syscall.Termios
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// makeRaw puts the terminal in raw mode (no echo, no line buffering,
+// no signal generation) and returns the previous state
+func makeRaw(fd int) (*termState, error) {
+	old := new(termState)
+	if err := ioctl(fd, ioctlGetTermios, unsafe.Pointer(&old.termios)); err != nil {
+		return nil, errors.New("not a terminal")
+	}
+
+	t := old.termios
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK |
+		syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func restore(fd int, s *termState) error {
+	return ioctl(fd, ioctlSetTermios, unsafe.Pointer(&s.termios))
+}
+
+// getSize returns the terminal size in columns and rows
+func getSize(fd int) (int, int, error) {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
@@ -0,0 +1,12 @@
+// Copyright (c) 2014 Square, Inc
+
+package tui
+
+import (
+	"syscall"
+)
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
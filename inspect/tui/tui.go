@@ -0,0 +1,564 @@
+// Copyright (c) 2014 Square, Inc
+//go:build linux || darwin
+// +build linux darwin
+
+// Package tui implements an interactive top-like view of an
+// inspect report. It only needs a terminal that understands basic
+// ANSI escape sequences.
+//
+// Keys:
+//
+//	c m i      sort processes by cpu, memory or io
+//	j k        move selection (arrow keys work as well)
+//	Enter      open detail pane for the selected process or cgroup
+//	Esc        close detail pane / cancel input
+//	p d n g    collapse/expand CPUs, disks, interfaces, cgroups
+//	u /        filter processes by user / command
+//	r          clear filters
+//	q          quit
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/square/prodeng/inspect/misc"
+	"github.com/square/prodeng/inspect/report"
+)
+
+// Source returns the latest report. It may return nil if no data
+// is available (yet).
+type Source func() *report.Report
+
+const (
+	sectionCPUs       = "cpus"
+	sectionDisks      = "disks"
+	sectionInterfaces = "interfaces"
+	sectionCgroups    = "cgroups"
+)
+
+// selectable row
+type row struct {
+	pid    string
+	cgroup string
+}
+
+type UI struct {
+	source     Source
+	report     *report.Report
+	sortKey    string
+	userFilter string
+	commFilter string
+	collapsed  map[string]bool
+	rows       []row // selectable rows of the last render
+	cursor     int
+	detail     *row    // non-nil when the detail pane is open
+	prompt     *string // filter being edited
+	input      string
+	width      int
+	height     int
+	out        *bufio.Writer
+}
+
+func New(source Source) *UI {
+	ui := new(UI)
+	ui.source = source
+	ui.sortKey = report.SortCPU
+	// hosts may have many CPUs
+	ui.collapsed = map[string]bool{sectionCPUs: true}
+	ui.width, ui.height = 80, 24
+	ui.out = bufio.NewWriter(os.Stdout)
+	return ui
+}
+
+// Run takes over the terminal and refreshes the view every refresh
+// until the user quits
+func Run(source Source, refresh time.Duration) error {
+	return New(source).Run(refresh)
+}
+
+func (ui *UI) Run(refresh time.Duration) error {
+	fd := int(os.Stdin.Fd())
+	old, err := makeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer restore(fd, old)
+
+	ui.out.WriteString("\033[?1049h\033[?25l") // alternate screen, hide cursor
+	defer func() {
+		ui.out.WriteString("\033[?25h\033[?1049l")
+		ui.out.Flush()
+	}()
+
+	keys := make(chan string)
+	go readKeys(os.Stdin, keys)
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	ui.report = ui.source()
+	for {
+		if w, h, err := getSize(int(os.Stdout.Fd())); err == nil && w > 0 && h > 0 {
+			ui.width, ui.height = w, h
+		}
+		ui.draw()
+
+		select {
+		case k, ok := <-keys:
+			if !ok || !ui.handleKey(k) {
+				return nil
+			}
+		case <-winch:
+		case <-ticker.C:
+			ui.report = ui.source()
+		}
+	}
+}
+
+// handleKey updates state for a key press. It returns false when
+// the user wants to quit.
+func (ui *UI) handleKey(k string) bool {
+	if k == "\x03" {
+		return false
+	}
+
+	if ui.prompt != nil {
+		switch k {
+		case "esc":
+			ui.prompt = nil
+		case "enter":
+			*ui.prompt = ui.input
+			ui.prompt = nil
+			ui.cursor = 0
+		case "backspace":
+			if len(ui.input) > 0 {
+				ui.input = ui.input[:len(ui.input)-1]
+			}
+		default:
+			if len(k) == 1 && k[0] >= ' ' {
+				ui.input += k
+			}
+		}
+		return true
+	}
+
+	switch k {
+	case "q":
+		return false
+	case "c":
+		ui.sortKey = report.SortCPU
+	case "m":
+		ui.sortKey = report.SortMem
+	case "i":
+		ui.sortKey = report.SortIO
+	case "j", "down":
+		if ui.cursor < len(ui.rows)-1 {
+			ui.cursor++
+		}
+	case "k", "up":
+		if ui.cursor > 0 {
+			ui.cursor--
+		}
+	case "enter":
+		if ui.detail == nil && ui.cursor < len(ui.rows) {
+			r := ui.rows[ui.cursor]
+			ui.detail = &r
+		} else {
+			ui.detail = nil
+		}
+	case "esc", "backspace":
+		ui.detail = nil
+	case "p":
+		ui.toggle(sectionCPUs)
+	case "d":
+		ui.toggle(sectionDisks)
+	case "n":
+		ui.toggle(sectionInterfaces)
+	case "g":
+		ui.toggle(sectionCgroups)
+	case "u":
+		ui.prompt, ui.input = &ui.userFilter, ui.userFilter
+	case "/":
+		ui.prompt, ui.input = &ui.commFilter, ui.commFilter
+	case "r":
+		ui.userFilter, ui.commFilter = "", ""
+		ui.cursor = 0
+	}
+	return true
+}
+
+func (ui *UI) toggle(section string) {
+	ui.collapsed[section] = !ui.collapsed[section]
+}
+
+// processes returns the filtered and sorted process list
+func (ui *UI) processes() []*report.Process {
+	var ret []*report.Process
+	for _, p := range ui.report.Processes {
+		if ui.userFilter != "" && p.User != ui.userFilter {
+			continue
+		}
+		if ui.commFilter != "" &&
+			!strings.Contains(p.Comm, ui.commFilter) &&
+			!strings.Contains(p.Cmdline, ui.commFilter) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return report.SortProcesses(ret, ui.sortKey)
+}
+
+// Rendering
+
+func (ui *UI) draw() {
+	var lines []string
+	if ui.report == nil {
+		lines = []string{"waiting for data..."}
+	} else if ui.detail != nil {
+		lines = ui.detailLines()
+	} else {
+		lines = ui.mainLines()
+	}
+
+	// keep the last line for status/prompt
+	if len(lines) > ui.height-1 {
+		lines = lines[:ui.height-1]
+	}
+
+	ui.out.WriteString("\033[H")
+	for _, l := range lines {
+		ui.out.WriteString(ui.fit(l))
+		ui.out.WriteString("\033[K\r\n")
+	}
+	ui.out.WriteString("\033[J")
+	ui.out.WriteString(fmt.Sprintf("\033[%d;1H", ui.height))
+	ui.out.WriteString(ui.fit(ui.status()))
+	ui.out.WriteString("\033[K")
+	ui.out.Flush()
+}
+
+// fit truncates a line to the terminal width, ignoring escape
+// sequences when counting
+func (ui *UI) fit(l string) string {
+	var b bytes.Buffer
+	n, esc := 0, false
+	for _, c := range l {
+		switch {
+		case c == '\033':
+			esc = true
+		case esc:
+			if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+				esc = false
+			}
+		default:
+			if n >= ui.width {
+				continue
+			}
+			n++
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (ui *UI) status() string {
+	if ui.prompt != nil {
+		label := "command"
+		if ui.prompt == &ui.userFilter {
+			label = "user"
+		}
+		return fmt.Sprintf("filter %s: %s_", label, ui.input)
+	}
+	if ui.detail != nil {
+		return reverse(" Esc/Enter: back  q: quit ")
+	}
+	return reverse(" c/m/i: sort  j/k: move  Enter: detail  p/d/n/g: sections  u,/: filter  r: reset  q: quit ")
+}
+
+func (ui *UI) header() []string {
+	r := ui.report
+	lines := []string{
+		fmt.Sprintf("%s - %s", r.Hostname, r.Time.Format("15:04:05")),
+		fmt.Sprintf("total: cpu: %3.1f%% (user %3.1f%%, kernel %3.1f%%) cpus: %d, mem: %3.1f%% (%s/%s)",
+			r.CPU.Usage, r.CPU.User, r.CPU.Kernel, r.CPU.Count, r.Mem.UsagePct,
+			misc.ByteSize(r.Mem.Usage), misc.ByteSize(r.Mem.Total)),
+	}
+	if r.Load != nil {
+		lines = append(lines, fmt.Sprintf("load: %.2f %.2f %.2f (cpus: %d) running: %d blocked: %d",
+			r.Load.Load1, r.Load.Load5, r.Load.Load15, r.CPU.Count,
+			r.Load.Running, r.Load.Blocked))
+	}
+	if r.Pressure != nil {
+		lines = append(lines, "pressure: "+r.Pressure.String())
+	}
+	if r.OS == "linux" {
+		lines = append(lines, fmt.Sprintf("cpu: iowait: %3.1f%% steal: %3.1f%% irq: %3.1f%% softirq: %3.1f%% imbalance: %3.1f%%",
+			r.CPU.IOWait, r.CPU.Steal, r.CPU.IRQ, r.CPU.SoftIRQ, r.CPU.Imbalance))
+		lines = append(lines, fmt.Sprintf("paging: swap in: %.1f/s out: %.1f/s major faults: %.1f/s direct reclaim: %.1f/s",
+			r.Mem.SwapIn, r.Mem.SwapOut, r.Mem.MajorFaults, r.Mem.DirectReclaim))
+	}
+	for _, p := range r.Problems {
+		lines = append(lines, red("Problem: "+p.Message))
+	}
+	return lines
+}
+
+func (ui *UI) mainLines() []string {
+	r := ui.report
+	lines := ui.header()
+
+	var filters []string
+	if ui.userFilter != "" {
+		filters = append(filters, "user="+ui.userFilter)
+	}
+	if ui.commFilter != "" {
+		filters = append(filters, "command="+ui.commFilter)
+	}
+	title := "Processes (sort: " + ui.sortKey
+	if len(filters) > 0 {
+		title += ", filter: " + strings.Join(filters, " ")
+	}
+	lines = append(lines, "", title+")")
+	lines = append(lines, reverse(fmt.Sprintf("%-8s %-10s %6s %9s %9s  %s",
+		"PID", "USER", "CPU%", "MEM", "IO/s", "COMMAND")))
+
+	var rows []row
+	var procLines []string
+	for _, p := range ui.processes() {
+		rows = append(rows, row{pid: p.Pid})
+		procLines = append(procLines, fmt.Sprintf("%-8s %-10.10s %6.1f %9s %9s  %s",
+			p.Pid, p.User, p.CPU, misc.ByteSize(p.Mem), misc.ByteSize(p.IO), p.Comm))
+	}
+
+	// sections below the process table
+	var tail []string
+	tail = append(tail, "", ui.sectionTitle("CPUs", "p", sectionCPUs, len(r.CPU.PerCPU)))
+	if !ui.collapsed[sectionCPUs] {
+		for _, c := range r.CPU.PerCPU {
+			tail = append(tail, "  "+c.String())
+		}
+		for _, z := range r.Thermal {
+			tail = append(tail, fmt.Sprintf("  thermal: %s (%s) %.1fC", z.Name, z.Type, z.Temp))
+		}
+	}
+	tail = append(tail, ui.sectionTitle("Disks", "d", sectionDisks, len(r.Disks)))
+	if !ui.collapsed[sectionDisks] {
+		for _, d := range r.Disks {
+			tail = append(tail, fmt.Sprintf("  %-16s usage: %5.1f%%", d.Name, d.Usage))
+		}
+	}
+	tail = append(tail, ui.sectionTitle("Interfaces", "n", sectionInterfaces, len(r.Interfaces)))
+	if !ui.collapsed[sectionInterfaces] {
+		for _, i := range r.Interfaces {
+			tail = append(tail, fmt.Sprintf("  %-16s TX: %5.1f%% (%s/s) RX: %5.1f%% (%s/s)",
+				i.Name, i.TXUsage, misc.BitSize(i.TXBandwidth),
+				i.RXUsage, misc.BitSize(i.RXBandwidth)))
+		}
+		if r.Net != nil {
+			tail = append(tail, fmt.Sprintf("  tcp: established: %.0f time_wait: %.0f orphans: %.0f mem: %s/%s retransmits: %3.1f%% listen overflows: %.1f/s",
+				r.Net.Established, r.Net.TimeWait, r.Net.Orphans,
+				misc.ByteSize(r.Net.TCPMem), misc.ByteSize(r.Net.TCPMemLimit),
+				r.Net.Retransmits, r.Net.ListenOverflows))
+		}
+	}
+	tail = append(tail, ui.sectionTitle("Cgroups", "g", sectionCgroups, len(r.Cgroups)))
+	var cgLines []string
+	if !ui.collapsed[sectionCgroups] {
+		for _, c := range r.Cgroups {
+			rows = append(rows, row{cgroup: c.Name})
+			cgLines = append(cgLines, fmt.Sprintf(
+				"  %-30s cpu: %5.1f%% throttle: %5.1f%% quota: %.1f mem: %5.1f%% (%s/%s)",
+				c.Name, c.CPUUsage, c.CPUThrottle, c.CPUQuota, misc.Finite(c.MemUsagePct()),
+				misc.ByteSize(c.MemUsage), misc.ByteSize(c.MemLimit)))
+		}
+	}
+
+	ui.rows = rows
+	if ui.cursor >= len(rows) {
+		ui.cursor = len(rows) - 1
+	}
+	if ui.cursor < 0 {
+		ui.cursor = 0
+	}
+
+	// highlight the selected row
+	for i := range procLines {
+		if i == ui.cursor {
+			procLines[i] = reverse(procLines[i])
+		}
+	}
+	for i := range cgLines {
+		if len(procLines)+i == ui.cursor {
+			cgLines[i] = reverse(cgLines[i])
+		}
+	}
+
+	// the process table gets whatever space is left and scrolls
+	// to keep the selection visible
+	avail := ui.height - 1 - len(lines) - len(tail) - len(cgLines)
+	if avail < 3 {
+		avail = 3
+	}
+	start := 0
+	if ui.cursor < len(procLines) && ui.cursor >= avail {
+		start = ui.cursor - avail + 1
+	}
+	end := start + avail
+	if end > len(procLines) {
+		end = len(procLines)
+	}
+
+	lines = append(lines, procLines[start:end]...)
+	lines = append(lines, tail...)
+	lines = append(lines, cgLines...)
+	return lines
+}
+
+func (ui *UI) sectionTitle(name, key, section string, n int) string {
+	mark := "[-]"
+	if ui.collapsed[section] {
+		mark = "[+]"
+	}
+	return fmt.Sprintf("%s %s (%d) [%s]", mark, name, n, key)
+}
+
+func (ui *UI) detailLines() []string {
+	r := ui.report
+	lines := ui.header()
+	lines = append(lines, "")
+
+	if ui.detail.pid != "" {
+		var p *report.Process
+		for _, x := range r.Processes {
+			if x.Pid == ui.detail.pid {
+				p = x
+			}
+		}
+		if p == nil {
+			return append(lines, "process "+ui.detail.pid+" is gone")
+		}
+		lines = append(lines,
+			reverse(" Process "+p.Pid+" "),
+			"  command: "+p.Comm,
+			"  cmdline: "+p.Cmdline,
+			"  user:    "+p.User,
+			fmt.Sprintf("  cpu:     %3.1f%%", p.CPU),
+			"  mem:     "+misc.ByteSize(p.Mem).String(),
+			"  io:      "+misc.ByteSize(p.IO).String()+"/s",
+			"  cgroup:  "+p.Cgroup)
+		if p.Connections > 0 || len(p.Listening) > 0 {
+			lines = append(lines,
+				fmt.Sprintf("  tcp connections: %d", p.Connections),
+				"  listening: "+strings.Join(p.Listening, ", "))
+		}
+		if c := findCgroup(r, p.Cgroup); c != nil {
+			lines = append(lines, "")
+			lines = append(lines, cgroupLines(c)...)
+		}
+		return lines
+	}
+
+	c := findCgroup(r, ui.detail.cgroup)
+	if c == nil {
+		return append(lines, "cgroup "+ui.detail.cgroup+" is gone")
+	}
+	lines = append(lines, cgroupLines(c)...)
+	lines = append(lines, "", "  tasks:")
+	for _, p := range report.SortProcesses(r.Processes, ui.sortKey) {
+		if findCgroup(r, p.Cgroup) == c {
+			lines = append(lines, fmt.Sprintf("    %-8s %-10.10s cpu: %5.1f%% mem: %9s  %s",
+				p.Pid, p.User, p.CPU, misc.ByteSize(p.Mem), p.Comm))
+		}
+	}
+	return lines
+}
+
+func cgroupLines(c *report.Cgroup) []string {
+	lines := []string{
+		reverse(" Cgroup " + c.Name + " "),
+		fmt.Sprintf("  cpu:      %3.1f%%", c.CPUUsage),
+		fmt.Sprintf("  throttle: %3.1f%%", c.CPUThrottle),
+		fmt.Sprintf("  quota:    %.1f cpus", c.CPUQuota),
+		fmt.Sprintf("  mem:      %3.1f%% (%s/%s)", misc.Finite(c.MemUsagePct()),
+			misc.ByteSize(c.MemUsage), misc.ByteSize(c.MemLimit)),
+		fmt.Sprintf("  io:       read: %s/s write: %s/s",
+			misc.ByteSize(c.IORead), misc.ByteSize(c.IOWrite)),
+	}
+	if c.Pressure != nil {
+		lines = append(lines, "  pressure: "+c.Pressure.String())
+	}
+	return lines
+}
+
+// findCgroup matches a cgroup path of a process ("/a/b") against
+// the cgroup names in the report ("a/b")
+func findCgroup(r *report.Report, path string) *report.Cgroup {
+	name := strings.Trim(path, "/")
+	for _, c := range r.Cgroups {
+		if strings.Trim(c.Name, "/") == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func reverse(s string) string {
+	return "\033[7m" + s + "\033[0m"
+}
+
+func red(s string) string {
+	return "\033[31m" + s + "\033[0m"
+}
+
+// Input
+
+// readKeys decodes key presses from r and sends them on keys.
+// Printable characters are sent as is; special keys by name.
+func readKeys(f *os.File, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 32)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, k := range decodeKeys(buf[:n]) {
+			keys <- k
+		}
+	}
+}
+
+func decodeKeys(b []byte) []string {
+	var ret []string
+	for len(b) > 0 {
+		switch {
+		case bytes.HasPrefix(b, []byte("\033[A")):
+			ret, b = append(ret, "up"), b[3:]
+		case bytes.HasPrefix(b, []byte("\033[B")):
+			ret, b = append(ret, "down"), b[3:]
+		case bytes.HasPrefix(b, []byte("\033[")) && len(b) >= 3:
+			// other escape sequences are ignored
+			b = b[3:]
+		case b[0] == '\033':
+			ret, b = append(ret, "esc"), b[1:]
+		case b[0] == '\r' || b[0] == '\n':
+			ret, b = append(ret, "enter"), b[1:]
+		case b[0] == 0x7f || b[0] == 0x08:
+			ret, b = append(ret, "backspace"), b[1:]
+		default:
+			ret, b = append(ret, string(b[0])), b[1:]
+		}
+	}
+	return ret
+}
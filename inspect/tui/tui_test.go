@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Square, Inc
+//go:build linux || darwin
+// +build linux darwin
+
+package tui
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/square/prodeng/inspect/report"
+)
+
+func TestDecodeKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"q", []string{"q"}},
+		{"jk/", []string{"j", "k", "/"}},
+		{"\033[A\033[B", []string{"up", "down"}},
+		{"\033[C", nil}, // right arrow is ignored
+		{"\033", []string{"esc"}},
+		{"ab\r", []string{"a", "b", "enter"}},
+		{"\n", []string{"enter"}},
+		{"x\x7f\x08", []string{"x", "backspace", "backspace"}},
+	}
+	for _, tt := range tests {
+		if got := decodeKeys([]byte(tt.in)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeKeys(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	ui := New(nil)
+	ui.width = 5
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"abc", "abc"},
+		{"abcdefgh", "abcde"},
+		// escape sequences don't take space and are kept
+		{reverse("abcdefgh"), "\033[7mabcde\033[0m"},
+		{red("ab") + "cdefgh", "\033[31mab\033[0mcde"},
+	}
+	for _, tt := range tests {
+		if got := ui.fit(tt.in); got != tt.want {
+			t.Errorf("fit(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleKey(t *testing.T) {
+	tests := []struct {
+		keys   []string
+		check  func(ui *UI) bool
+		remark string
+	}{
+		{[]string{"m"}, func(ui *UI) bool { return ui.sortKey == report.SortMem }, "sort by mem"},
+		{[]string{"i", "c"}, func(ui *UI) bool { return ui.sortKey == report.SortCPU }, "sort by cpu"},
+		{[]string{"j", "j", "j", "j"}, func(ui *UI) bool { return ui.cursor == 2 }, "cursor stops at last row"},
+		{[]string{"down", "up", "k"}, func(ui *UI) bool { return ui.cursor == 0 }, "cursor stops at first row"},
+		{[]string{"j", "enter"}, func(ui *UI) bool {
+			return ui.detail != nil && ui.detail.cgroup == "web"
+		}, "enter opens detail of selected row"},
+		{[]string{"enter", "esc"}, func(ui *UI) bool { return ui.detail == nil }, "esc closes detail"},
+		{[]string{"u", "r", "o", "o", "t", "enter"}, func(ui *UI) bool {
+			return ui.userFilter == "root" && ui.prompt == nil
+		}, "user filter"},
+		{[]string{"/", "s", "q", "l", "x", "backspace", "enter"}, func(ui *UI) bool {
+			return ui.commFilter == "sql"
+		}, "command filter with backspace"},
+		{[]string{"u", "x", "esc"}, func(ui *UI) bool { return ui.userFilter == "" }, "esc cancels filter"},
+		{[]string{"u", "x", "enter", "r"}, func(ui *UI) bool { return ui.userFilter == "" }, "r resets filters"},
+		{[]string{"d", "p"}, func(ui *UI) bool {
+			return ui.collapsed[sectionDisks] && !ui.collapsed[sectionCPUs]
+		}, "toggle sections"},
+	}
+	for _, tt := range tests {
+		ui := New(nil)
+		ui.rows = []row{{pid: "1"}, {cgroup: "web"}, {pid: "2"}}
+		for _, k := range tt.keys {
+			if !ui.handleKey(k) {
+				t.Fatalf("%s: key %q quit", tt.remark, k)
+			}
+		}
+		if !tt.check(ui) {
+			t.Errorf("%s: unexpected state after %q", tt.remark, tt.keys)
+		}
+	}
+
+	ui := New(nil)
+	if ui.handleKey("q") || ui.handleKey("\x03") {
+		t.Errorf("q and ctrl-c should quit")
+	}
+	// q is text while a filter is edited
+	ui.handleKey("/")
+	if !ui.handleKey("q") || ui.input != "q" {
+		t.Errorf("q should be typed into the filter, input %q", ui.input)
+	}
+}
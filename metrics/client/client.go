@@ -0,0 +1,139 @@
+// Copyright (c) 2014 Square, Inc
+
+// Package client fetches metrics exposed by a remote MetricContext
+// (for example `inspect -server`) and decodes them into typed
+// metrics.Counter/Gauge/StatsTimer values.
+//
+// Example use:
+//   c := client.New("db1.example.com:19999")
+//   m, err := c.Fetch()
+//   if err == nil {
+//   	fmt.Println(m.Gauges["memstat.MemFree"].Get())
+//   	fmt.Println(m.Counters["interfacestat.eth0.RXbytes"].ComputeRate())
+//   }
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/square/prodeng/metrics"
+)
+
+const (
+	DefaultPath    = "/metrics.json"
+	DefaultTimeout = 10 * time.Second
+)
+
+type Client struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// New returns a client for address which is either a full URL or
+// host:port of a server exposing metrics at DefaultPath
+func New(address string) *Client {
+	c := new(Client)
+	c.URL = address
+	if !strings.HasPrefix(address, "http://") &&
+		!strings.HasPrefix(address, "https://") {
+		c.URL = "http://" + address + DefaultPath
+	}
+	c.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	return c
+}
+
+// Fetch retrieves all metrics from the server and returns them in
+// a new metric context
+func (c *Client) Fetch() (*metrics.MetricContext, error) {
+	s, err := c.FetchSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return s.MetricContext(), nil
+}
+
+// FetchSnapshot retrieves all metrics from the server. The binary
+// encoding is requested; servers that only speak JSON are also
+// understood (StatsTimers are not available in that case).
+func (c *Client) FetchSnapshot() (*metrics.Snapshot, error) {
+	req, err := http.NewRequest("GET", c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", metrics.MsgpackContentType+", application/json;q=0.5")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", c.URL, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s := new(metrics.Snapshot)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), metrics.MsgpackContentType) {
+		err = s.UnmarshalBinary(body)
+	} else {
+		err = decodeJSON(body, s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Unexported functions
+
+// HttpJsonHandler prints NaN/Inf as bare words which is not valid JSON
+var nonFiniteRe = regexp.MustCompile(`:\s*[+-]?(NaN|Inf)\b`)
+
+func decodeJSON(body []byte, s *metrics.Snapshot) error {
+	body = nonFiniteRe.ReplaceAll(body, []byte(": null"))
+
+	var entries []struct {
+		Type  string
+		Name  string
+		Value *float64
+		Rate  *float64
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return errors.New("unable to decode metrics: " + err.Error())
+	}
+
+	s.Timestamp = time.Now()
+	for _, e := range entries {
+		value, rate := math.NaN(), math.NaN()
+		if e.Value != nil {
+			value = *e.Value
+		}
+		if e.Rate != nil {
+			rate = *e.Rate
+		}
+		switch e.Type {
+		case "gauge":
+			s.Gauges = append(s.Gauges, metrics.GaugeSnapshot{Name: e.Name, Value: value})
+		case "counter":
+			s.Counters = append(s.Counters,
+				metrics.CounterSnapshot{Name: e.Name, Value: uint64(value), Rate: rate})
+		case "basiccounter":
+			s.BasicCounters = append(s.BasicCounters,
+				metrics.BasicCounterSnapshot{Name: e.Name, Value: uint64(value)})
+		}
+	}
+	return nil
+}
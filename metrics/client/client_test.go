@@ -0,0 +1,52 @@
+// Copyright (c) 2014 Square, Inc
+
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/square/prodeng/metrics"
+)
+
+func TestFetch(t *testing.T) {
+	m := metrics.NewMetricContext("system")
+	g := metrics.NewGauge()
+	g.Set(42)
+	m.Register(g, "memstat.MemFree")
+	c := metrics.NewCounter()
+	c.Set(100)
+	m.Register(c, "cpustat.cpu.User")
+
+	for _, handler := range []http.HandlerFunc{
+		m.HttpJsonHandler,
+		// a server which ignores Accept and always sends JSON
+		func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del("Accept")
+			m.HttpJsonHandler(w, r)
+		},
+	} {
+		ts := httptest.NewServer(handler)
+		r, err := New(ts.URL).Fetch()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := r.Gauges["memstat.MemFree"].Get(); v != 42 {
+			t.Errorf("gauge = %v, want 42", v)
+		}
+		if v := r.Counters["cpustat.cpu.User"].Get(); v != 100 {
+			t.Errorf("counter = %v, want 100", v)
+		}
+	}
+}
+
+func TestNewAddress(t *testing.T) {
+	if u := New("host:1234").URL; u != "http://host:1234/metrics.json" {
+		t.Errorf("URL = %v", u)
+	}
+	if u := New("http://host/x.json").URL; u != "http://host/x.json" {
+		t.Errorf("URL = %v", u)
+	}
+}
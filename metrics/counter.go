@@ -19,7 +19,8 @@ type Counter struct {
 
 // Counters differ from BasicCounter by having additional
 // fields for computing rate
-// All basic counter operations are atomic and no locks are held
+// Set and Add hold the counter's lock and are safe to call from
+// several goroutines
 func NewCounter() *Counter {
 	c := new(Counter)
 	c.Reset()
@@ -40,7 +41,9 @@ func (c *Counter) Reset() {
 // Set Counter value. This is useful if you are reading a metric
 // that is already a counter
 func (c *Counter) Set(v uint64) {
-	c.ticks_v = TICKS
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ticks_v = atomic.LoadInt64(&TICKS)
 	atomic.StoreUint64(&c.v, v)
 
 	// baseline for rate calculation
@@ -52,7 +55,9 @@ func (c *Counter) Set(v uint64) {
 
 // Add value to counter
 func (c *Counter) Add(delta uint64) {
-	c.ticks_v = TICKS
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ticks_v = atomic.LoadInt64(&TICKS)
 	atomic.AddUint64(&c.v, delta)
 
 	// baseline for rate calculation
@@ -64,13 +69,11 @@ func (c *Counter) Add(delta uint64) {
 
 // Get value of counter
 func (c *Counter) Get() uint64 {
-	return c.v
+	return atomic.LoadUint64(&c.v)
 }
 
 // ComputeRate() calculates the rate of change of counter per
 // second. (acquires a lock)
-// Since we avoid locking on Set/Add operations, rate can be
-// inaccurate on highly contended threads
 
 func (c *Counter) ComputeRate() float64 {
 	c.mu.Lock()
@@ -3,9 +3,13 @@
 package metrics
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,17 +23,21 @@ func init() {
 	ticker := time.NewTicker(time.Millisecond * jiffy)
 	go func() {
 		for t := range ticker.C {
-			TICKS = t.UnixNano() - start
+			atomic.StoreInt64(&TICKS, t.UnixNano()-start)
 		}
 	}()
 }
 
+// Collectors register and unregister metrics from their own
+// goroutines; the maps must only be read directly when nothing else
+// uses the context. Use Snapshot() otherwise.
 type MetricContext struct {
 	namespace     string
 	Counters      map[string]*Counter
 	Gauges        map[string]*Gauge
 	BasicCounters map[string]*BasicCounter
 	StatsTimers   map[string]*StatsTimer
+	mu            sync.RWMutex // protects the maps
 }
 
 // Creates a new metric context. A metric context specifies a namespace
@@ -61,6 +69,8 @@ func NewMetricContext(namespace string) *MetricContext {
 // Register(v Metric) registers a metric with metric
 // context
 func (m *MetricContext) Register(v interface{}, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	switch v := v.(type) {
 	case *BasicCounter:
 		m.BasicCounters[name] = v
@@ -76,6 +86,8 @@ func (m *MetricContext) Register(v interface{}, name string) {
 // Unregister(v Metric) unregisters a metric with metric
 // context
 func (m *MetricContext) Unregister(v interface{}, name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	switch v.(type) {
 	case *BasicCounter:
 		delete(m.BasicCounters, name)
@@ -90,6 +102,8 @@ func (m *MetricContext) Unregister(v interface{}, name string) {
 
 // Print() prints ALL metrics to stdout
 func (m *MetricContext) Print() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	for name, c := range m.Counters {
 		fmt.Printf("counter %s %d %.3f \n", name,
 			c.Get(), c.ComputeRate())
@@ -106,7 +120,7 @@ func (m *MetricContext) Print() {
 		for _, p := range percentiles {
 			percentile, err := s.Percentile(p)
 			if err == nil {
-				out += fmt.Sprintf(".3f", percentile)
+				out += fmt.Sprintf("%.3f ", percentile)
 			}
 		}
 		fmt.Printf("statstimer %s %s \n", name, out)
@@ -114,9 +128,27 @@ func (m *MetricContext) Print() {
 }
 
 // HttpJsonHandler exposes all metrics via json
+// Clients sending "Accept: application/x-msgpack" get a binary
+// Snapshot instead (see HttpMsgpackHandler)
 // TODO: too long, too ugly - fix
 func (m *MetricContext) HttpJsonHandler(w http.ResponseWriter, r *http.Request) {
+	if acceptsMsgpack(r) {
+		m.HttpMsgpackHandler(w, r)
+		return
+	}
+
+	// render under the lock, write once it is released so slow
+	// clients don't block registration
+	var b bytes.Buffer
+	m.mu.RLock()
+	m.writeJson(&b)
+	m.mu.RUnlock()
+
 	w.Header().Set("Content-Type", "application/json")
+	w.Write(b.Bytes())
+}
+
+func (m *MetricContext) writeJson(w *bytes.Buffer) {
 	w.Write([]byte("[\n"))
 
 	appendcomma := false
@@ -176,3 +208,24 @@ func (m *MetricContext) HttpJsonHandler(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte("]"))
 	w.Write([]byte("\n")) // Be nice to curl
 }
+
+// HttpMsgpackHandler exposes all metrics as a msgpack encoded Snapshot
+func (m *MetricContext) HttpMsgpackHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := m.Snapshot().MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", MsgpackContentType)
+	w.Write(b)
+}
+
+func acceptsMsgpack(r *http.Request) bool {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt := strings.TrimSpace(strings.Split(a, ";")[0])
+		if mt == MsgpackContentType || mt == "application/msgpack" {
+			return true
+		}
+	}
+	return false
+}
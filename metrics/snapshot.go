@@ -0,0 +1,267 @@
+// Copyright (c) 2014 Square, Inc
+
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Snapshot is a point in time copy of all metrics in a MetricContext.
+// It can be serialized to a compact binary form (msgpack) and turned
+// back into a MetricContext with typed Counter/Gauge/BasicCounter/
+// StatsTimer values on the other side.
+type Snapshot struct {
+	Namespace     string
+	Timestamp     time.Time
+	Counters      []CounterSnapshot
+	Gauges        []GaugeSnapshot
+	BasicCounters []BasicCounterSnapshot
+	StatsTimers   []StatsTimerSnapshot
+}
+
+type CounterSnapshot struct {
+	Name  string
+	Value uint64
+	Rate  float64
+}
+
+type GaugeSnapshot struct {
+	Name  string
+	Value float64
+}
+
+type BasicCounterSnapshot struct {
+	Name  string
+	Value uint64
+}
+
+// StatsTimerSnapshot carries the raw samples of a StatsTimer so
+// that percentiles can be computed by the receiver
+type StatsTimerSnapshot struct {
+	Name     string
+	TimeUnit time.Duration
+	Size     int     // number of samples the timer keeps
+	History  []int64 // recorded samples in nanoseconds
+}
+
+// MIME type used for binary snapshots
+const MsgpackContentType = "application/x-msgpack"
+
+// Snapshot() returns a copy of all metrics registered with the
+// metric context. Entries are sorted by name.
+func (m *MetricContext) Snapshot() *Snapshot {
+	s := new(Snapshot)
+	s.Namespace = m.namespace
+	s.Timestamp = time.Now()
+
+	m.mu.RLock()
+	for name, c := range m.Counters {
+		s.Counters = append(s.Counters,
+			CounterSnapshot{name, c.Get(), c.ComputeRate()})
+	}
+	for name, g := range m.Gauges {
+		s.Gauges = append(s.Gauges, GaugeSnapshot{name, g.Get()})
+	}
+	for name, c := range m.BasicCounters {
+		s.BasicCounters = append(s.BasicCounters,
+			BasicCounterSnapshot{name, c.Get()})
+	}
+	for name, t := range m.StatsTimers {
+		t.mu.RLock()
+		h := make([]int64, 0, len(t.history))
+		for _, v := range t.history {
+			if v != NOT_INITIALIZED {
+				h = append(h, v)
+			}
+		}
+		size := len(t.history)
+		t.mu.RUnlock()
+		s.StatsTimers = append(s.StatsTimers,
+			StatsTimerSnapshot{name, t.timeUnit, size, h})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(s.Counters, func(i, j int) bool {
+		return s.Counters[i].Name < s.Counters[j].Name
+	})
+	sort.Slice(s.Gauges, func(i, j int) bool {
+		return s.Gauges[i].Name < s.Gauges[j].Name
+	})
+	sort.Slice(s.BasicCounters, func(i, j int) bool {
+		return s.BasicCounters[i].Name < s.BasicCounters[j].Name
+	})
+	sort.Slice(s.StatsTimers, func(i, j int) bool {
+		return s.StatsTimers[i].Name < s.StatsTimers[j].Name
+	})
+
+	return s
+}
+
+// MetricContext() builds a new metric context holding typed metrics
+// with the values in the snapshot. Counters report the snapshot rate
+// from ComputeRate() until they are updated again.
+func (s *Snapshot) MetricContext() *MetricContext {
+	m := NewMetricContext(s.Namespace)
+
+	for _, c := range s.Counters {
+		x := NewCounter()
+		x.v = c.Value
+		x.p = c.Value
+		x.rate = c.Rate
+		m.Register(x, c.Name)
+	}
+	for _, g := range s.Gauges {
+		x := NewGauge()
+		x.Set(g.Value)
+		m.Register(x, g.Name)
+	}
+	for _, c := range s.BasicCounters {
+		x := NewBasicCounter()
+		x.Set(c.Value)
+		m.Register(x, c.Name)
+	}
+	for _, t := range s.StatsTimers {
+		size := t.Size
+		if size < len(t.History) {
+			size = len(t.History)
+		}
+		if size < 1 {
+			size = 1
+		}
+		x := NewStatsTimer(t.TimeUnit, size)
+		copy(x.history, t.History)
+		x.idx = len(t.History) % size
+		m.Register(x, t.Name)
+	}
+
+	return m
+}
+
+// MarshalBinary encodes the snapshot as msgpack
+//
+// Layout (all maps keyed by short strings):
+//   {"ns": namespace, "ts": unix nanoseconds,
+//    "c": [[name, value, rate], ...],
+//    "g": [[name, value], ...],
+//    "b": [[name, value], ...],
+//    "t": [[name, timeunit ns, size, [sample ns, ...]], ...]}
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	e := new(msgpackEncoder)
+	e.writeMapHeader(6)
+
+	e.writeString("ns")
+	e.writeString(s.Namespace)
+	e.writeString("ts")
+	e.writeInt(s.Timestamp.UnixNano())
+
+	e.writeString("c")
+	e.writeArrayHeader(len(s.Counters))
+	for _, c := range s.Counters {
+		e.writeArrayHeader(3)
+		e.writeString(c.Name)
+		e.writeUint(c.Value)
+		e.writeFloat(c.Rate)
+	}
+
+	e.writeString("g")
+	e.writeArrayHeader(len(s.Gauges))
+	for _, g := range s.Gauges {
+		e.writeArrayHeader(2)
+		e.writeString(g.Name)
+		e.writeFloat(g.Value)
+	}
+
+	e.writeString("b")
+	e.writeArrayHeader(len(s.BasicCounters))
+	for _, c := range s.BasicCounters {
+		e.writeArrayHeader(2)
+		e.writeString(c.Name)
+		e.writeUint(c.Value)
+	}
+
+	e.writeString("t")
+	e.writeArrayHeader(len(s.StatsTimers))
+	for _, t := range s.StatsTimers {
+		e.writeArrayHeader(4)
+		e.writeString(t.Name)
+		e.writeInt(int64(t.TimeUnit))
+		e.writeInt(int64(t.Size))
+		e.writeArrayHeader(len(t.History))
+		for _, v := range t.History {
+			e.writeInt(v)
+		}
+	}
+
+	return e.buf, nil
+}
+
+// UnmarshalBinary decodes a snapshot encoded with MarshalBinary
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	d := &msgpackDecoder{buf: data}
+	v, err := d.decode()
+	if err != nil {
+		return err
+	}
+	top, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.New("snapshot: expected map")
+	}
+
+	*s = Snapshot{}
+	s.Namespace, _ = top["ns"].(string)
+	if ts, ok := toInt64(top["ts"]); ok {
+		s.Timestamp = time.Unix(0, ts)
+	}
+
+	for _, e := range entries(top["c"], 3) {
+		name, _ := e[0].(string)
+		value, _ := toUint64(e[1])
+		rate, _ := toFloat64(e[2])
+		s.Counters = append(s.Counters, CounterSnapshot{name, value, rate})
+	}
+	for _, e := range entries(top["g"], 2) {
+		name, _ := e[0].(string)
+		value, _ := toFloat64(e[1])
+		s.Gauges = append(s.Gauges, GaugeSnapshot{name, value})
+	}
+	for _, e := range entries(top["b"], 2) {
+		name, _ := e[0].(string)
+		value, _ := toUint64(e[1])
+		s.BasicCounters = append(s.BasicCounters,
+			BasicCounterSnapshot{name, value})
+	}
+	for _, e := range entries(top["t"], 4) {
+		t := StatsTimerSnapshot{}
+		t.Name, _ = e[0].(string)
+		unit, _ := toInt64(e[1])
+		t.TimeUnit = time.Duration(unit)
+		size, _ := toInt64(e[2])
+		t.Size = int(size)
+		h, _ := e[3].([]interface{})
+		for _, x := range h {
+			if v, ok := toInt64(x); ok {
+				t.History = append(t.History, v)
+			}
+		}
+		s.StatsTimers = append(s.StatsTimers, t)
+	}
+
+	return nil
+}
+
+// Unexported functions
+
+// entries returns elements of v which are arrays of at least n items
+func entries(v interface{}, n int) [][]interface{} {
+	var ret [][]interface{}
+	a, _ := v.([]interface{})
+	for _, x := range a {
+		e, ok := x.([]interface{})
+		if ok && len(e) >= n {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Square, Inc
+
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testContext() *MetricContext {
+	m := NewMetricContext("test")
+	c := NewCounter()
+	c.Set(1 << 40)
+	m.Register(c, "counter.big")
+	g := NewGauge()
+	g.Set(-12.5)
+	m.Register(g, "gauge.neg")
+	m.Register(NewGauge(), "gauge.nan")
+	b := NewBasicCounter()
+	b.Add(7)
+	m.Register(b, "basic")
+	s := NewStatsTimer(time.Millisecond, 10)
+	for i := 1; i <= 4; i++ {
+		s.history[i-1] = int64(i) * int64(time.Millisecond)
+	}
+	s.idx = 4
+	m.Register(s, "timer")
+	return m
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	m := testContext()
+	b, err := m.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := new(Snapshot)
+	if err := s.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if s.Namespace != "test" {
+		t.Errorf("namespace = %v, want test", s.Namespace)
+	}
+
+	n := s.MetricContext()
+	if v := n.Counters["counter.big"].Get(); v != 1<<40 {
+		t.Errorf("counter = %v, want %v", v, uint64(1<<40))
+	}
+	if v := n.Gauges["gauge.neg"].Get(); v != -12.5 {
+		t.Errorf("gauge = %v, want -12.5", v)
+	}
+	if v := n.Gauges["gauge.nan"].Get(); !math.IsNaN(v) {
+		t.Errorf("gauge = %v, want NaN", v)
+	}
+	if v := n.BasicCounters["basic"].Get(); v != 7 {
+		t.Errorf("basiccounter = %v, want 7", v)
+	}
+	p, err := n.StatsTimers["timer"].Percentile(100)
+	if err != nil || p != 4 {
+		t.Errorf("timer 100th percentile = %v (%v), want 4", p, err)
+	}
+}
+
+func TestSnapshotTruncated(t *testing.T) {
+	b, _ := testContext().Snapshot().MarshalBinary()
+	s := new(Snapshot)
+	if err := s.UnmarshalBinary(b[:len(b)/2]); err == nil {
+		t.Errorf("expected error decoding truncated snapshot")
+	}
+}
+
+func TestHttpHandlerNegotiation(t *testing.T) {
+	m := testContext()
+	ts := httptest.NewServer(http.HandlerFunc(m.HttpJsonHandler))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Accept", "application/x-msgpack")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != MsgpackContentType {
+		t.Errorf("Content-Type = %v, want %v", ct, MsgpackContentType)
+	}
+
+	resp, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %v, want application/json", ct)
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright (c) 2014 Square, Inc
+
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* SqlDB
+
+SqlDB wraps a *sql.DB and records client side statistics for every
+query that goes through it. Queries are grouped by fingerprint - the
+query text with literals replaced by '?' and whitespace collapsed - so
+that "SELECT a FROM t WHERE id = 1" and "... id = 2" share metrics.
+
+Example use:
+  m := metrics.NewMetricContext("webapp")
+  db, _ := sql.Open("mysql", dsn)
+  sdb := metrics.NewSqlDB(m, db, "webapp.sqldb")
+
+  rows, err := sdb.Query("SELECT name FROM users WHERE id = ?", 10)
+  ...
+  rows.Close() // latency and rows returned are recorded on Close
+
+Metrics registered per fingerprint (prefix.query.<fingerprint>.*):
+  Latency - StatsTimer of query latency in milliseconds
+  Queries - number of queries executed
+  Errors  - number of queries that returned an error
+  Rows    - number of rows returned
+
+Connection pool metrics (prefix.pool.*) mirror sql.DBStats and are
+refreshed after every query or by calling CollectPoolStats.
+
+Like *sql.DB, a SqlDB may be used from several goroutines at once.
+
+*/
+
+type SqlDB struct {
+	Pool    *SqlPoolStats
+	db      *sql.DB
+	m       *MetricContext
+	prefix  string
+	queries map[string]*SqlQueryStats
+	mu      sync.Mutex
+}
+
+// SqlQueryStats holds metrics for a single query fingerprint
+type SqlQueryStats struct {
+	Query   string // normalized query text
+	Latency *StatsTimer
+	Queries *Counter
+	Errors  *Counter
+	Rows    *Counter
+}
+
+// SqlPoolStats holds connection pool metrics as reported by
+// (*sql.DB).Stats()
+type SqlPoolStats struct {
+	MaxOpenConnections *Gauge
+	OpenConnections    *Gauge
+	InUse              *Gauge
+	Idle               *Gauge
+	WaitCount          *Counter
+	WaitDurationMsecs  *Counter
+	MaxIdleClosed      *Counter
+	MaxLifetimeClosed  *Counter
+}
+
+// number of latency samples kept per query fingerprint
+const sqlLatencySamples = 1000
+
+func NewSqlDB(m *MetricContext, db *sql.DB, prefix string) *SqlDB {
+	s := new(SqlDB)
+	s.db = db
+	s.m = m
+	s.prefix = prefix
+	s.queries = make(map[string]*SqlQueryStats)
+
+	s.Pool = new(SqlPoolStats)
+	s.Pool.MaxOpenConnections = NewGauge()
+	s.Pool.OpenConnections = NewGauge()
+	s.Pool.InUse = NewGauge()
+	s.Pool.Idle = NewGauge()
+	s.Pool.WaitCount = NewCounter()
+	s.Pool.WaitDurationMsecs = NewCounter()
+	s.Pool.MaxIdleClosed = NewCounter()
+	s.Pool.MaxLifetimeClosed = NewCounter()
+
+	p := prefix + ".pool."
+	m.Register(s.Pool.MaxOpenConnections, p+"MaxOpenConnections")
+	m.Register(s.Pool.OpenConnections, p+"OpenConnections")
+	m.Register(s.Pool.InUse, p+"InUse")
+	m.Register(s.Pool.Idle, p+"Idle")
+	m.Register(s.Pool.WaitCount, p+"WaitCount")
+	m.Register(s.Pool.WaitDurationMsecs, p+"WaitDurationMsecs")
+	m.Register(s.Pool.MaxIdleClosed, p+"MaxIdleClosed")
+	m.Register(s.Pool.MaxLifetimeClosed, p+"MaxLifetimeClosed")
+
+	s.CollectPoolStats()
+	return s
+}
+
+// DB returns the underlying *sql.DB. Queries made directly on it
+// are not instrumented.
+func (s *SqlDB) DB() *sql.DB {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db
+}
+
+// SetDB replaces the underlying *sql.DB, e.g. after reconnecting.
+// Collected metrics are kept.
+func (s *SqlDB) SetDB(db *sql.DB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.db = db
+}
+
+// Queries returns stats for all query fingerprints seen so far
+// keyed by fingerprint
+func (s *SqlDB) Queries() map[string]*SqlQueryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string]*SqlQueryStats, len(s.queries))
+	for k, v := range s.queries {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Query executes a query that returns rows. Latency is measured
+// until the returned rows are closed.
+func (s *SqlDB) Query(query string, args ...interface{}) (*SqlRows, error) {
+	q := s.queryStats(query)
+	t := q.Latency.Start()
+	rows, err := s.DB().Query(query, args...)
+	if err != nil {
+		q.Latency.Stop(t)
+		q.Queries.Add(1)
+		q.Errors.Add(1)
+		s.CollectPoolStats()
+		return nil, err
+	}
+	return &SqlRows{Rows: rows, s: s, q: q, t: t}, nil
+}
+
+// Exec executes a query without returning any rows
+func (s *SqlDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	q := s.queryStats(query)
+	t := q.Latency.Start()
+	res, err := s.DB().Exec(query, args...)
+	q.Latency.Stop(t)
+	q.Queries.Add(1)
+	if err != nil {
+		q.Errors.Add(1)
+	}
+	s.CollectPoolStats()
+	return res, err
+}
+
+// Ping verifies a connection to the database is still alive
+func (s *SqlDB) Ping() error {
+	return s.DB().Ping()
+}
+
+// Close closes the underlying database
+func (s *SqlDB) Close() error {
+	return s.DB().Close()
+}
+
+// CollectPoolStats updates connection pool metrics from db.Stats()
+func (s *SqlDB) CollectPoolStats() {
+	st := s.DB().Stats()
+	s.Pool.MaxOpenConnections.Set(float64(st.MaxOpenConnections))
+	s.Pool.OpenConnections.Set(float64(st.OpenConnections))
+	s.Pool.InUse.Set(float64(st.InUse))
+	s.Pool.Idle.Set(float64(st.Idle))
+	s.Pool.WaitCount.Set(uint64(st.WaitCount))
+	s.Pool.WaitDurationMsecs.Set(uint64(st.WaitDuration / time.Millisecond))
+	s.Pool.MaxIdleClosed.Set(uint64(st.MaxIdleClosed))
+	s.Pool.MaxLifetimeClosed.Set(uint64(st.MaxLifetimeClosed))
+}
+
+// SqlRows wraps *sql.Rows to count rows returned and record query
+// latency when closed
+type SqlRows struct {
+	*sql.Rows
+	s      *SqlDB
+	q      *SqlQueryStats
+	t      *Timer
+	n      uint64
+	closed bool
+}
+
+// Next advances to the next row, counting it
+func (r *SqlRows) Next() bool {
+	if r.Rows.Next() {
+		r.n++
+		return true
+	}
+	return false
+}
+
+// Close closes the rows and records metrics for the query
+func (r *SqlRows) Close() error {
+	err := r.Rows.Close()
+	if r.closed {
+		return err
+	}
+	r.closed = true
+	r.q.Latency.Stop(r.t)
+	r.q.Queries.Add(1)
+	r.q.Rows.Add(r.n)
+	if err != nil || r.Rows.Err() != nil {
+		r.q.Errors.Add(1)
+	}
+	r.s.CollectPoolStats()
+	return err
+}
+
+// Fingerprint returns the normalized form of query and a short name
+// for it that is safe to use as part of a metric name
+func Fingerprint(query string) (name string, normalized string) {
+	normalized = normalizeQuery(query)
+	h := fnv.New32a()
+	h.Write([]byte(normalized))
+
+	verb := "query"
+	if f := strings.Fields(normalized); len(f) > 0 {
+		verb = nonWordRe.ReplaceAllString(f[0], "")
+	}
+	return fmt.Sprintf("%s_%08x", verb, h.Sum32()), normalized
+}
+
+// Unexported functions
+
+var (
+	stringLiteralRe = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"`)
+	numberLiteralRe = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	inListRe        = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	whitespaceRe    = regexp.MustCompile(`\s+`)
+	nonWordRe       = regexp.MustCompile(`\W`)
+)
+
+func normalizeQuery(query string) string {
+	q := stringLiteralRe.ReplaceAllString(query, "?")
+	q = numberLiteralRe.ReplaceAllString(q, "?")
+	q = inListRe.ReplaceAllString(q, "(?+)")
+	q = whitespaceRe.ReplaceAllString(q, " ")
+	q = strings.TrimRight(strings.TrimSpace(q), ";")
+	return strings.ToLower(q)
+}
+
+func (s *SqlDB) queryStats(query string) *SqlQueryStats {
+	name, normalized := Fingerprint(query)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queries[name]
+	if ok {
+		return q
+	}
+
+	q = new(SqlQueryStats)
+	q.Query = normalized
+	q.Latency = NewStatsTimer(time.Millisecond, sqlLatencySamples)
+	q.Queries = NewCounter()
+	q.Errors = NewCounter()
+	q.Rows = NewCounter()
+
+	p := s.prefix + ".query." + name + "."
+	s.m.Register(q.Latency, p+"Latency")
+	s.m.Register(q.Queries, p+"Queries")
+	s.m.Register(q.Errors, p+"Errors")
+	s.m.Register(q.Rows, p+"Rows")
+
+	s.queries[name] = q
+	return q
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2014 Square, Inc
+
+package metrics
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fake driver returning a fixed number of rows for any query
+// and failing queries containing "fail"
+
+type fakeDriver struct{}
+type fakeConn struct{}
+type fakeStmt struct{ query string }
+type fakeRows struct{ n int }
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if s.query == "fail" {
+		return nil, errors.New("fail")
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.query == "fail" {
+		return nil, errors.New("fail")
+	}
+	return &fakeRows{3}, nil
+}
+
+func (r *fakeRows) Columns() []string { return []string{"a"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.n == 0 {
+		return io.EOF
+	}
+	r.n--
+	dest[0] = int64(r.n)
+	return nil
+}
+
+func init() {
+	sql.Register("metrics_fake", fakeDriver{})
+}
+
+func TestFingerprint(t *testing.T) {
+	a, na := Fingerprint("SELECT a FROM t WHERE id = 1 AND name = 'bob'")
+	b, _ := Fingerprint("select a  from t\n WHERE id = 22 AND name = \"alice\";")
+	if a != b {
+		t.Errorf("Fingerprint mismatch: %v != %v", a, b)
+	}
+	want := "select a from t where id = ? and name = ?"
+	if na != want {
+		t.Errorf("normalized = %q, want %q", na, want)
+	}
+
+	_, n := Fingerprint("SELECT * FROM t WHERE id IN (1, 2, 3)")
+	want = "select * from t where id in (?+)"
+	if n != want {
+		t.Errorf("normalized = %q, want %q", n, want)
+	}
+}
+
+func TestSqlDB(t *testing.T) {
+	db, err := sql.Open("metrics_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMetricContext("test")
+	s := NewSqlDB(m, db, "sqldb")
+
+	for i := 0; i < 2; i++ {
+		rows, err := s.Query("SELECT a FROM t WHERE id = ?", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+	if _, err := s.Query("fail"); err == nil {
+		t.Errorf("expected error from query")
+	}
+
+	name, _ := Fingerprint("SELECT a FROM t WHERE id = ?")
+	q, ok := s.Queries()[name]
+	if !ok {
+		t.Fatalf("no stats for fingerprint %v", name)
+	}
+	if q.Queries.Get() != 2 || q.Rows.Get() != 6 || q.Errors.Get() != 0 {
+		t.Errorf("queries: %v rows: %v errors: %v, want 2 6 0",
+			q.Queries.Get(), q.Rows.Get(), q.Errors.Get())
+	}
+	if _, err := q.Latency.Percentile(50); err != nil {
+		t.Errorf("no latency recorded: %v", err)
+	}
+	if _, ok := m.StatsTimers["sqldb.query."+name+".Latency"]; !ok {
+		t.Errorf("latency not registered with metric context")
+	}
+
+	name, _ = Fingerprint("fail")
+	if e := s.Queries()[name].Errors.Get(); e != 1 {
+		t.Errorf("errors = %v, want 1", e)
+	}
+	if s.Pool.OpenConnections.Get() < 1 {
+		t.Errorf("pool stats not collected")
+	}
+}
+
+func TestSqlDBConcurrent(t *testing.T) {
+	db, err := sql.Open("metrics_fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSqlDB(NewMetricContext("test"), db, "sqldb")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := s.Exec("UPDATE t SET a = ?", j); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	name, _ := Fingerprint("UPDATE t SET a = ?")
+	if n := s.Queries()[name].Queries.Get(); n != 400 {
+		t.Errorf("queries = %v, want 400", n)
+	}
+}